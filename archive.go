@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// archiveSeparator separates archive path from path of file inside it in virtual paths, e.g. backup.zip!/photo.jpg
+const archiveSeparator = "!/"
+
+// isArchiveFile checks if files inside path can be scanned, archives on remote hosts are only hashed as files
+func isArchiveFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".zip" && !isRemotePath(path)
+}
+
+// splitArchivePath splits virtual path of file inside archive into archive path and path inside archive
+// Separator may have been converted to native one, e.g. when path was cleaned on Windows
+func splitArchivePath(path string) (string, string, bool) {
+	lower := strings.ToLower(path)
+	for _, separator := range []string{archiveSeparator, "!" + string(filepath.Separator)} {
+		if i := strings.Index(lower, ".zip"+separator); i >= 0 {
+			i += len(".zip")
+			return path[:i], filepath.ToSlash(path[i+len(separator):]), true
+		}
+	}
+	return "", "", false
+}
+
+// isArchiveEntry checks if path is virtual path of file inside archive, such files can only be reported and never moved
+func isArchiveEntry(path string) bool {
+	_, _, ok := splitArchivePath(path)
+	return ok
+}
+
+// getArchiveEntries hashes files inside archive and returns their records with virtual paths
+// Records keep modification time of archive, so that they are dropped when archive changes
+func getArchiveEntries(path string, f os.FileInfo) ([]*FileMetadata, error) {
+	log.Infof("Processing files inside %s\n", path)
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var records []*FileMetadata
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		fileHash, err := getArchiveEntryHash(file)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&counters.filesParsed, 1)
+		atomic.AddInt64(&counters.bytesHashed, int64(file.UncompressedSize64))
+		records = append(records, &FileMetadata{Path: path + archiveSeparator + file.Name, Size: int64(file.UncompressedSize64), FileHash: fileHash, Created: file.Modified, Modified: file.Modified, FirstSeen: time.Now(), ArchiveModified: f.ModTime()})
+	}
+	return records, nil
+}
+
+func getArchiveEntryHash(file *zip.File) (string, error) {
+	r, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	hasher := getHasher()
+	defer hashers.Put(hasher)
+	buffer := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buffer)
+	if _, err := io.CopyBuffer(hasher, r, *buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// removeArchiveEntries removes records of files inside archive, e.g. when archive was changed
+func removeArchiveEntries(fh *FileHashes, path string) {
+	prefix := path + archiveSeparator
+	for entryPath, record := range fh.files {
+		if strings.HasPrefix(entryPath, prefix) {
+			removeRecord(fh, record)
+		}
+	}
+	delete(fh.archives, path)
+}
+
+// readArchiveEntryRecord loads record of file inside archive if archive was not changed since it was scanned
+func readArchiveEntryRecord(fh *FileHashes, record *FileMetadata, archivePath string) (bool, error) {
+	f, err := os.Stat(archivePath)
+	if os.IsNotExist(err) {
+		log.Warningf("File not found %s\n", record.Path)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !record.ArchiveModified.Equal(f.ModTime()) {
+		log.Debugf("Archive changed for %s\n", record.Path)
+		return true, nil
+	}
+	fh.archives[archivePath] = true
+	return replaceLatestRecord(fh, record)
+}
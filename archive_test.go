@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanArchives(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{"loose/a.txt": "same"})
+	archive, err := os.Create(filepath.Join(root, "backup.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(archive)
+	for name, contents := range map[string]string{"photos/a.txt": "same", "b.txt": "unique"} {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+	fh.options.ScanArchives = true
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	dups, err := FindDuplicates(SearchOptions{}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loose := fh.files[filepath.Join(root, "loose", "a.txt")]
+	archived := filepath.Join(root, "backup.zip") + "!/photos/a.txt"
+	if len(dups) != 1 || len(dups[loose]) != 1 || dups[loose][0].Path != archived {
+		t.Fatalf("Expected archived copy to be duplicate of loose file, got %v", dups)
+	}
+	opts := MoveOptions{Destination: filepath.Join(root, "removed"), RemovePrefix: root, Apply: true}
+	if moved, err := MoveDuplicates(opts, dups, fh); err != nil || moved {
+		t.Errorf("Expected files inside archive to not be moved (moved: %v, error: %v)", moved, err)
+	}
+	assertExists(t, filepath.Join(root, "backup.zip"))
+	assertNotExists(t, filepath.Join(root, "removed"))
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	archive, entry, ok := splitArchivePath("/backups/2019.ZIP!/photos/a.jpg")
+	if !ok || archive != "/backups/2019.ZIP" || entry != "photos/a.jpg" {
+		t.Errorf("Unexpected split %s, %s, %v", archive, entry, ok)
+	}
+	if _, _, ok := splitArchivePath("/photos/wow!/a.jpg"); ok {
+		t.Error("Expected path outside of archive to not be split")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// audioExtensions lists extensions of files that are fingerprinted when audio matching is enabled
+var audioExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".flac": true, ".ogg": true, ".opus": true, ".wav": true, ".wma": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true,
+}
+
+const (
+	// audioMatchSimilarity is minimum share of matching fingerprint bits for files to be reported as audio matches
+	audioMatchSimilarity = 0.85
+	// audioMatchDuration is maximum difference in seconds between durations of matching files
+	audioMatchDuration = 2
+	// audioMatchMaxOffset is maximum number of fingerprint items that matching files can be shifted by
+	audioMatchMaxOffset = 8
+)
+
+func isAudioFile(path string) bool {
+	return audioExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// getAudioFingerprint calculates Chromaprint fingerprint of audio track with fpcalc tool, which has to be installed separately
+// Fingerprint is returned encoded in base64 along with track duration in seconds
+func getAudioFingerprint(path string) (float64, string, error) {
+	output, err := exec.Command("fpcalc", "-raw", path).Output()
+	if err != nil {
+		return 0, "", err
+	}
+	return parseFpcalcOutput(string(output))
+}
+
+// parseFpcalcOutput parses DURATION and FINGERPRINT lines printed by fpcalc -raw
+func parseFpcalcOutput(output string) (float64, string, error) {
+	var duration float64
+	var fingerprint []uint32
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "DURATION":
+			value, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return 0, "", err
+			}
+			duration = value
+		case "FINGERPRINT":
+			for _, item := range strings.Split(parts[1], ",") {
+				value, err := strconv.ParseInt(item, 10, 64)
+				if err != nil {
+					return 0, "", err
+				}
+				// Items are printed signed or unsigned depending on fpcalc version
+				fingerprint = append(fingerprint, uint32(value))
+			}
+		}
+	}
+	if len(fingerprint) == 0 {
+		return 0, "", errors.New("No fingerprint in fpcalc output")
+	}
+	data := make([]byte, 4*len(fingerprint))
+	for i, item := range fingerprint {
+		binary.LittleEndian.PutUint32(data[4*i:], item)
+	}
+	return duration, base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeAudioFingerprint(encoded string) ([]uint32, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := make([]uint32, len(data)/4)
+	for i := range fingerprint {
+		fingerprint[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return fingerprint, nil
+}
+
+// getAudioSimilarity returns share of matching bits of best aligned fingerprints
+func getAudioSimilarity(a []uint32, b []uint32) float64 {
+	best := 0.0
+	for offset := -audioMatchMaxOffset; offset <= audioMatchMaxOffset; offset++ {
+		matching, total := 0, 0
+		for i := range a {
+			j := i + offset
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			matching += 32 - bits.OnesCount32(a[i]^b[j])
+			total += 32
+		}
+		if total > 0 && float64(matching)/float64(total) > best {
+			best = float64(matching) / float64(total)
+		}
+	}
+	return best
+}
+
+type audioTrack struct {
+	record      *FileMetadata
+	fingerprint []uint32
+}
+
+// printAudioMatches prints groups of files with similar audio that are not identical, e.g. same track encoded with different bitrate
+// Audio matches are fuzzy, so they are only listed and never returned for moving
+func printAudioMatches(fh *FileHashes, prefixes []string, listing ListingFormat, policy MasterPolicy) {
+	var tracks []audioTrack
+	for path, record := range fh.files {
+		if len(record.AudioFingerprint) == 0 || (len(prefixes) > 0 && !hasAnyPrefix(path, prefixes)) {
+			continue
+		}
+		fingerprint, err := decodeAudioFingerprint(record.AudioFingerprint)
+		if err != nil {
+			log.Warningf("Invalid audio fingerprint for %s: %s\n", path, err)
+			continue
+		}
+		tracks = append(tracks, audioTrack{record: record, fingerprint: fingerprint})
+	}
+	// Only tracks with similar durations are compared
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].record.AudioDuration < tracks[j].record.AudioDuration })
+	// Groups of matching tracks are identified by index of their first track
+	groupOf := make(map[*FileMetadata]int)
+	groups := make(map[int]map[*FileMetadata]bool)
+	similarities := make(map[*FileMetadata]float64)
+	for i := range tracks {
+		for j := i + 1; j < len(tracks) && tracks[j].record.AudioDuration-tracks[i].record.AudioDuration <= audioMatchDuration; j++ {
+			a, b := tracks[i].record, tracks[j].record
+			if a.FileHash == b.FileHash {
+				continue
+			}
+			similarity := getAudioSimilarity(tracks[i].fingerprint, tracks[j].fingerprint)
+			if similarity < audioMatchSimilarity {
+				continue
+			}
+			log.Debugf("Audio of %s matches %s (%.0f%%)\n", a.Path, b.Path, similarity*100)
+			id, ok := groupOf[a]
+			if !ok {
+				id = i
+				groupOf[a] = id
+				groups[id] = map[*FileMetadata]bool{a: true}
+			}
+			if other, ok := groupOf[b]; ok && other != id {
+				// Merge groups of both tracks
+				for record := range groups[other] {
+					groups[id][record] = true
+					groupOf[record] = id
+				}
+				delete(groups, other)
+			}
+			groups[id][b] = true
+			groupOf[b] = id
+			if similarity > similarities[a] {
+				similarities[a] = similarity
+			}
+			if similarity > similarities[b] {
+				similarities[b] = similarity
+			}
+		}
+	}
+	ids := make([]int, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		group := groups[id]
+		master, _ := pickMaster(group, "", "", policy)
+		listing.print(listing.Master, master.Path, master.Path, "")
+		for record := range group {
+			if record != master {
+				listing.print(listing.Audio, record.Path, master.Path, fmt.Sprintf("%.0f%%", similarities[record]*100))
+			}
+		}
+		listing.endGroup()
+	}
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFpcalcOutput(t *testing.T) {
+	duration, encoded, err := parseFpcalcOutput("FILE=song.mp3\nDURATION=213.5\nFINGERPRINT=1,-2,4294967295\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration != 213.5 {
+		t.Errorf("Expected duration 213.5, got %v", duration)
+	}
+	fingerprint, err := decodeAudioFingerprint(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint32{1, 4294967294, 4294967295}; !reflect.DeepEqual(fingerprint, expected) {
+		t.Errorf("Expected fingerprint %v, got %v", expected, fingerprint)
+	}
+	if _, _, err := parseFpcalcOutput("DURATION=1\n"); err == nil {
+		t.Error("Expected error for output without fingerprint")
+	}
+}
+
+func TestAudioSimilarity(t *testing.T) {
+	a := make([]uint32, 100)
+	for i := range a {
+		a[i] = uint32(i) * 2654435761
+	}
+	// Same track shifted by two items with a few flipped bits
+	b := append([]uint32{7, 7}, a...)
+	b[10] ^= 0xff
+	if similarity := getAudioSimilarity(a, b); similarity < audioMatchSimilarity {
+		t.Errorf("Expected shifted track to match, got similarity %v", similarity)
+	}
+	c := make([]uint32, 100)
+	for i := range c {
+		c[i] = ^a[i]
+	}
+	if similarity := getAudioSimilarity(a, c); similarity >= audioMatchSimilarity {
+		t.Errorf("Expected different track not to match, got similarity %v", similarity)
+	}
+}
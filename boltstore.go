@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// boltFilesBucket maps paths to JSON encoded records
+	boltFilesBucket = []byte("files")
+	// boltHashesBucket contains bucket of paths for every file and image hash
+	boltHashesBucket = []byte("hashes")
+)
+
+// boltStore keeps records in BoltDB keyed by path, so that updated records replace older ones in place
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBoltStore opens BoltDB, read-only databases are shared with other readers and buckets are not created in them
+func openBoltStore(path string, readOnly bool) (*boltStore, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &boltStore{db: db}, nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltFilesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltHashesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) load(fn func(record *FileMetadata) error) error {
+	// Records are collected first, since fn may put refreshed records and writes would block while read transaction is open
+	var records []*FileMetadata
+	err := s.db.View(func(tx *bolt.Tx) error {
+		files := tx.Bucket(boltFilesBucket)
+		if files == nil {
+			// Database opened read-only before anything was saved
+			return nil
+		}
+		return files.ForEach(func(key []byte, value []byte) error {
+			record := &FileMetadata{}
+			if err := json.Unmarshal(value, record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) put(record *FileMetadata) (bool, error) {
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		added = tx.Bucket(boltFilesBucket).Get([]byte(record.Path)) == nil
+		return putBoltRecord(tx, record)
+	})
+	return added, err
+}
+
+func (s *boltStore) rewrite(records []*FileMetadata, backup string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.CopyFile(backup, 0666); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{boltFilesBucket, boltHashesBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		for _, record := range records {
+			if err := putBoltRecord(tx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+// putBoltRecord saves record and replaces hash index entries of older record with same path
+func putBoltRecord(tx *bolt.Tx, record *FileMetadata) error {
+	files := tx.Bucket(boltFilesBucket)
+	hashes := tx.Bucket(boltHashesBucket)
+	key := []byte(record.Path)
+	if data := files.Get(key); data != nil {
+		old := &FileMetadata{}
+		if err := json.Unmarshal(data, old); err != nil {
+			return err
+		}
+		for _, hash := range getRecordHashes(old) {
+			if paths := hashes.Bucket([]byte(hash)); paths != nil {
+				if err := paths.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := files.Put(key, data); err != nil {
+		return err
+	}
+	for _, hash := range getRecordHashes(record) {
+		paths, err := hashes.CreateBucketIfNotExists([]byte(hash))
+		if err != nil {
+			return err
+		}
+		if err := paths.Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getRecordHashes returns file hash and image hash of record if they are known
+func getRecordHashes(record *FileMetadata) []string {
+	var hashes []string
+	for _, hash := range []string{record.FileHash, record.ImageHash} {
+		if len(hash) > 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
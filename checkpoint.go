@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// getCheckpointPath returns path of duplicate search checkpoint for database
+func getCheckpointPath(dbPath string) string {
+	return dbPath + ".checkpoint"
+}
+
+// loadCheckpoint marks paths visited by interrupted duplicate search as visited
+func loadCheckpoint(checkpointPath string, visited map[string]*FileMetadata, fh *FileHashes) error {
+	file, err := os.Open(checkpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var path string
+		if err := json.Unmarshal(scanner.Bytes(), &path); err != nil {
+			// Last line might be partially written if search was interrupted
+			log.Warningf("Skipping malformed checkpoint line %s\n", scanner.Text())
+			continue
+		}
+		if record := fh.files[path]; record != nil {
+			visited[path] = record
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Infof("Resuming duplicate search with %d visited files from %s\n", len(visited), checkpointPath)
+	return nil
+}
+
+// saveCheckpoint appends visited paths to checkpoint file
+func saveCheckpoint(file *os.File, paths []string) error {
+	writer := bufio.NewWriter(file)
+	for _, path := range paths {
+		data, err := json.Marshal(path)
+		if err != nil {
+			return err
+		}
+		writer.Write(data)
+		writer.WriteString("\n")
+	}
+	return writer.Flush()
+}
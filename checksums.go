@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hashAlgorithm is name of algorithm used for file hashes, matching coreutils tool name without "sum" suffix
+const hashAlgorithm = "sha1"
+
+// ExportChecksums writes file hashes in format compatible with sha1sum -c
+// Paths are written relative to root when it is specified, otherwise they are absolute
+func ExportChecksums(manifestPath string, root string, fh *FileHashes) error {
+	if len(root) > 0 {
+		var err error
+		root, err = absPath(root)
+		if err != nil {
+			return err
+		}
+	}
+	paths := make([]string, 0, len(fh.files))
+	for path := range fh.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	file, err := os.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	log.Infof("Writing %s checksums to %s\n", hashAlgorithm, manifestPath)
+	for _, path := range paths {
+		name := path
+		if len(root) > 0 {
+			name, err = filepath.Rel(root, path)
+			if err != nil {
+				file.Close()
+				return err
+			}
+		}
+		if _, err := writer.WriteString(formatChecksumLine(fh.files[path].FileHash, name)); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// formatChecksumLine formats line the same way coreutils do, escaping backslashes and new lines in file names
+func formatChecksumLine(hash string, name string) string {
+	if strings.ContainsAny(name, "\\\n") {
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+		return fmt.Sprintf("\\%s  %s\n", hash, name)
+	}
+	return fmt.Sprintf("%s  %s\n", hash, name)
+}
+
+// ImportChecksums seeds database with file hashes from sha1sum manifest without reading files
+// Relative paths are resolved against root, or current folder when root is not specified
+// Imported records have no image hashes or shooting dates until files change and get rescanned
+func ImportChecksums(manifestPath string, root string, fh *FileHashes) error {
+	root, err := absPath(root)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	log.Infof("Importing %s checksums from %s\n", hashAlgorithm, manifestPath)
+	imported := 0
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		hash, name, err := parseChecksumLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", manifestPath, line, err)
+		}
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		path = normalizePath(path)
+		f, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			log.Warningf("File not found %s\n", path)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			continue
+		}
+		if record := fh.files[path]; record != nil && checkFileDidNotChange(f, record) {
+			if record.FileHash != hash {
+				log.Warningf("Keeping existing hash for %s, manifest hash %s does not match\n", path, hash)
+			}
+			continue
+		}
+		record := &FileMetadata{Path: path, Size: f.Size(), FileHash: hash, Created: getCreationTime(path, f), Modified: f.ModTime(), FirstSeen: time.Now()}
+		if old := fh.files[path]; old != nil {
+			record.FirstSeen = old.FirstSeen
+			removeRecord(fh, old)
+		}
+		log.Debugf("Imported %s\n", path)
+		addRecord(fh, record)
+		if err := addFileToDB(fh, record); err != nil {
+			return err
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Infof("Imported %d checksums\n", imported)
+	return nil
+}
+
+// parseChecksumLine parses coreutils checksum line in either GNU or BSD (--tag) format
+func parseChecksumLine(line string) (string, string, error) {
+	if strings.HasPrefix(line, strings.ToUpper(hashAlgorithm)+" (") {
+		// BSD format: SHA1 (name) = hash
+		i := strings.LastIndex(line, ") = ")
+		if i < 0 {
+			return "", "", fmt.Errorf("Malformed checksum line")
+		}
+		return checkHash(line[i+4:], line[len(hashAlgorithm)+2:i])
+	}
+	if i := strings.Index(line, " ("); i > 0 && strings.Contains(line, ") = ") && !strings.ContainsAny(line[:i], " *") {
+		return "", "", fmt.Errorf("Manifest uses %s checksums, database uses %s", strings.ToLower(line[:i]), hashAlgorithm)
+	}
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	i := strings.Index(line, " ")
+	if i < 0 || len(line) < i+2 {
+		return "", "", fmt.Errorf("Malformed checksum line")
+	}
+	// Second character is either space for text mode or asterisk for binary mode
+	hash, name := line[:i], line[i+2:]
+	if escaped {
+		name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+	}
+	return checkHash(hash, name)
+}
+
+// checkHash validates that hash was produced by same algorithm that is used for database
+func checkHash(hash string, name string) (string, string, error) {
+	hash = strings.ToLower(hash)
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", "", fmt.Errorf("Malformed checksum %s", hash)
+	}
+	if len(hash) != sha1.Size*2 {
+		algorithms := map[int]string{md5.Size * 2: "md5", sha256.Size * 2: "sha256", sha512.Size * 2: "sha512"}
+		if algorithm, ok := algorithms[len(hash)]; ok {
+			return "", "", fmt.Errorf("Manifest uses %s checksums, database uses %s", algorithm, hashAlgorithm)
+		}
+		return "", "", fmt.Errorf("Checksum %s is not %s", hash, hashAlgorithm)
+	}
+	return hash, name, nil
+}
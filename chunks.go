@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// chunkHashLength is number of hex digits kept from hash of every chunk, which is enough to tell chunks apart while keeping records of large files short
+const chunkHashLength = 16
+
+// getChunkHashes hashes consecutive chunks of file, last chunk is shorter unless file size is multiple of chunk size
+func getChunkHashes(path string, chunkSize int64) ([]string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	log.Debugf("Hashing chunks of %s\n", path)
+	hasher := getHasher()
+	defer hashers.Put(hasher)
+	buffer := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buffer)
+	var hashes []string
+	for {
+		hasher.Reset()
+		n, err := io.CopyBuffer(hasher, io.LimitReader(f, chunkSize), *buffer)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		hashes = append(hashes, hex.EncodeToString(hasher.Sum(nil))[:chunkHashLength])
+		if n < chunkSize {
+			break
+		}
+	}
+	return hashes, nil
+}
+
+// partialMatch is longest run of consecutive chunks shared by two different files
+type partialMatch struct {
+	first        *FileMetadata
+	second       *FileMetadata
+	firstOffset  int64
+	secondOffset int64
+	size         int64
+}
+
+// chunkKey identifies chunk contents, chunks are only compared with chunks of same size
+type chunkKey struct {
+	size int64
+	hash string
+}
+
+// chunkRef is chunk at index of file
+type chunkRef struct {
+	record *FileMetadata
+	index  int
+}
+
+// getPartialMatches finds pairs of different files sharing run of at least minSize bytes of chunks, e.g. truncated copies, sorted by shared size from largest
+// Chunks are compared at multiples of chunk size, so shared contents at unaligned offsets (e.g. after prepended data) are not found
+func getPartialMatches(fh *FileHashes, minSize int64) []partialMatch {
+	index := make(map[chunkKey][]chunkRef)
+	var records []*FileMetadata
+	for _, record := range fh.files {
+		if record.ChunkSize <= 0 || len(record.ChunkHashes) == 0 {
+			continue
+		}
+		records = append(records, record)
+		for i, hash := range record.ChunkHashes {
+			key := chunkKey{record.ChunkSize, hash}
+			index[key] = append(index[key], chunkRef{record, i})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	var matches []partialMatch
+	for _, record := range records {
+		best := make(map[*FileMetadata]partialMatch)
+		// Length of run of shared chunks ending at each chunk of other files, for previous chunk of record
+		previous := make(map[chunkRef]int)
+		for i, hash := range record.ChunkHashes {
+			current := make(map[chunkRef]int)
+			for _, ref := range index[chunkKey{record.ChunkSize, hash}] {
+				// Each pair is only searched once, and identical files are already reported as duplicates
+				if ref.record.Path <= record.Path || ref.record.FileHash == record.FileHash {
+					continue
+				}
+				run := previous[chunkRef{ref.record, ref.index - 1}] + 1
+				current[ref] = run
+				match := partialMatch{first: record, second: ref.record, firstOffset: int64(i-run+1) * record.ChunkSize, secondOffset: int64(ref.index-run+1) * record.ChunkSize}
+				match.size = minInt64(int64(run)*record.ChunkSize, minInt64(record.Size-match.firstOffset, ref.record.Size-match.secondOffset))
+				if match.size > best[ref.record].size {
+					best[ref.record] = match
+				}
+			}
+			previous = current
+		}
+		for _, match := range best {
+			if match.size >= minSize {
+				matches = append(matches, match)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].size != matches[j].size {
+			return matches[i].size > matches[j].size
+		}
+		if matches[i].first.Path != matches[j].first.Path {
+			return matches[i].first.Path < matches[j].first.Path
+		}
+		return matches[i].second.Path < matches[j].second.Path
+	})
+	return matches
+}
+
+func minInt64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// PrintPartialMatches prints pairs of files sharing at least minSize bytes of contents, only files with recorded chunk hashes are compared
+func PrintPartialMatches(fh *FileHashes, minSize int64) {
+	matches := getPartialMatches(fh, minSize)
+	fmt.Printf("* Files sharing part of contents:\n")
+	for _, match := range matches {
+		fmt.Printf("%011d %s at %d and %s at %d\n", match.size, match.first.Path, match.firstOffset, match.second.Path, match.secondOffset)
+	}
+	fmt.Printf("* %d pairs of files share at least %s\n", len(matches), formatSize(minSize))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetPartialMatches(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	files := map[string]string{
+		"/lib/full.bin":      "AAAABBBBCCCCDDDD",
+		"/lib/truncated.bin": "AAAABBBBCC",
+		"/lib/middle.bin":    "XXXXBBBBCCCCYYYY",
+		"/lib/copy.bin":      "AAAABBBBCCCCDDDD",
+		"/lib/other.bin":     "ZZZZ",
+	}
+	var memFiles []memTestFile
+	for path, contents := range files {
+		memFiles = append(memFiles, memTestFile{path, contents, modified})
+	}
+	_, fh := makeMemTestFiles(t, memFiles)
+	for _, record := range fh.files {
+		hashes, err := getChunkHashes(record.Path, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.ChunkSize, record.ChunkHashes = 4, hashes
+	}
+	if chunks := len(fh.files["/lib/truncated.bin"].ChunkHashes); chunks != 3 {
+		t.Errorf("Expected 3 chunks including shorter last one, got %d", chunks)
+	}
+	type pair struct {
+		first        string
+		second       string
+		firstOffset  int64
+		secondOffset int64
+		size         int64
+	}
+	var found []pair
+	for _, match := range getPartialMatches(fh, 8) {
+		found = append(found, pair{match.first.Path, match.second.Path, match.firstOffset, match.secondOffset, match.size})
+	}
+	// Identical copies are not partial matches, and truncated file only shares single chunk with middle one
+	expected := []pair{
+		{"/lib/copy.bin", "/lib/middle.bin", 4, 4, 8},
+		{"/lib/copy.bin", "/lib/truncated.bin", 0, 0, 8},
+		{"/lib/full.bin", "/lib/middle.bin", 4, 4, 8},
+		{"/lib/full.bin", "/lib/truncated.bin", 0, 0, 8},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+}
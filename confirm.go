@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// countDuplicates returns number and total size of duplicates
+func countDuplicates(dups map[*FileMetadata][]*FileMetadata) (int, int64) {
+	count := 0
+	size := int64(0)
+	for _, list := range dups {
+		for _, dup := range list {
+			count++
+			size += dup.Size
+		}
+	}
+	return count, size
+}
+
+// isTerminal checks if file is interactive terminal
+func isTerminal(file *os.File) bool {
+	f, err := file.Stat()
+	return err == nil && f.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmAction asks user on standard input to confirm destructive action unless assumeYes is set
+// Actions are refused without asking when standard input is not a terminal, so that scripts have to pass assumeYes explicitly
+func confirmAction(prompt string, assumeYes bool) error {
+	if assumeYes {
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%w, refusing to ask when input is not a terminal, use -yes", ErrNotConfirmed)
+	}
+	if !confirm(prompt, os.Stdin) {
+		return fmt.Errorf("%w, use -yes to proceed without confirmation", ErrNotConfirmed)
+	}
+	return nil
+}
+
+// confirm prints prompt and reads answer from input, only y or yes confirm action
+func confirm(prompt string, in io.Reader) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && len(answer) == 0 {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CopyMasters copies one file of each content (masters and unique files) into destination folder preserving relative paths
+// Originals are left in place, each copy is verified by hash and files that were already copied are skipped
+// Only files inside folder are copied when it is specified, files inside archives are never copied
+func CopyMasters(opts MoveOptions, dups map[*FileMetadata][]*FileMetadata, folder string, fh *FileHashes) (int, error) {
+	destination, err := filepath.Abs(opts.Destination)
+	if err != nil {
+		return 0, err
+	}
+	kept, err := getKeptPaths(dups, folder, fh, opts.StrictOnly)
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, path := range kept {
+		record := fh.files[path]
+		if len(record.SymlinkTarget) > 0 {
+			// Target is copied on its own when it is recorded
+			continue
+		}
+		relPath, err := getRelativeDestination(record, opts)
+		if err != nil {
+			return copied, err
+		}
+		newPath := filepath.Join(destination, relPath)
+		if _, err := os.Stat(newPath); err == nil {
+			// Copy left by previous run is kept when it is same file, so that mirror can be updated incrementally
+			if hash, err := getFileHash(newPath); err != nil {
+				return copied, err
+			} else if hash != record.FileHash {
+				return copied, fmt.Errorf("%w: %s", ErrDestinationExists, newPath)
+			}
+			log.Debugf("Already copied %s\n", path)
+			continue
+		} else if !os.IsNotExist(err) {
+			return copied, err
+		}
+		fmt.Printf("%011d Copying %s to %s\n", record.Size, path, newPath)
+		copied++
+		if !opts.Apply {
+			continue
+		}
+		if err := copyFile(record, newPath); err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// copyFile copies recorded file to path through temporary file, which is only renamed when its hash matches the record
+func copyFile(record *FileMetadata, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	src, err := os.Open(record.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "copy")
+	if err != nil {
+		return err
+	}
+	// Temporary files are only readable by owner, so copy gets permissions of original
+	if err := file.Chmod(info.Mode()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	hash, err := getFileHash(file.Name())
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if hash != record.FileHash {
+		os.Remove(file.Name())
+		return fmt.Errorf("%w: %s", ErrCopyMismatch, record.Path)
+	}
+	if err := copyXattrs(record.Path, file.Name()); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Chtimes(file.Name(), record.Modified, record.Modified); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyMasters(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"photos/a.txt":        "duplicate",
+		"incoming/a.txt":      "duplicate",
+		"photos/unique.txt":   "unique",
+		"photos/nested/b.txt": "other",
+	})
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: filepath.Join(root, "incoming")}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror := filepath.Join(root, "mirror")
+	opts := MoveOptions{Destination: mirror, RemovePrefix: root}
+	if copied, err := CopyMasters(opts, dups, "", fh); err != nil || copied != 3 {
+		t.Fatalf("Expected 3 files to be copied without apply, got %d: %v", copied, err)
+	}
+	assertNotExists(t, mirror)
+	opts.Apply = true
+	if copied, err := CopyMasters(opts, dups, "", fh); err != nil || copied != 3 {
+		t.Fatalf("Expected 3 files to be copied, got %d: %v", copied, err)
+	}
+	assertExists(t, filepath.Join(mirror, "photos", "a.txt"))
+	assertExists(t, filepath.Join(mirror, "photos", "unique.txt"))
+	assertExists(t, filepath.Join(mirror, "photos", "nested", "b.txt"))
+	assertNotExists(t, filepath.Join(mirror, "incoming", "a.txt"))
+	assertExists(t, filepath.Join(root, "incoming", "a.txt"))
+	// Files copied by previous run are skipped
+	if copied, err := CopyMasters(opts, dups, "", fh); err != nil || copied != 0 {
+		t.Fatalf("Expected nothing to be copied again, got %d: %v", copied, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mirror, "photos", "unique.txt"), []byte("changed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CopyMasters(opts, dups, "", fh); !errors.Is(err, ErrDestinationExists) {
+		t.Errorf("Expected %v, got %v", ErrDestinationExists, err)
+	}
+}
+
+func TestCopyFileVerifiesHash(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{"a.txt": "original"})
+	record := fh.files[filepath.Join(root, "a.txt")]
+	if err := ioutil.WriteFile(record.Path, []byte("changed after scan"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "mirror", "a.txt")
+	if err := copyFile(record, path); !errors.Is(err, ErrCopyMismatch) {
+		t.Errorf("Expected %v, got %v", ErrCopyMismatch, err)
+	}
+	assertNotExists(t, path)
+}
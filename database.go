@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,6 +20,28 @@ type FileMetadata struct {
 	Created   time.Time
 	Modified  time.Time
 	DateShot  time.Time
+	// Time when path was first recorded in database, zero for records created before it was tracked
+	FirstSeen time.Time
+	// Device and inode identifying physical file, zero when not supported or recorded before they were tracked
+	DeviceID uint64
+	Inode    uint64
+	// Duration in seconds and base64 encoded Chromaprint fingerprint of audio track, empty when audio matching was not enabled
+	AudioDuration    float64
+	AudioFingerprint string
+	// Modification time of archive for files inside archives, zero for other files
+	ArchiveModified time.Time
+	// Reason why image is possibly corrupt, e.g. truncated, empty for valid images and other files
+	Corrupt string
+	// Image hash was not calculated yet, since no other file had same size, see ParseOptions.LazyImageHashes
+	ImageHashPending bool
+	// Absolute path of file that symlink points to, empty for other files
+	// Symlinks are never indexed, so they are not reported as duplicates of their targets
+	SymlinkTarget string
+	// Hashes of consecutive chunks of ChunkSize bytes used to find files sharing part of contents, empty when chunks were not hashed
+	ChunkSize   int64
+	ChunkHashes []string
+	// Duration in seconds read from video container, zero for other files and videos that could not be parsed
+	Duration float64
 }
 
 // FileHashes holds database records
@@ -29,74 +51,203 @@ type FileHashes struct {
 	files map[string]*FileMetadata
 	// Map of lists of file records by their hash
 	hashes map[string][]*FileMetadata
-	lock   sync.RWMutex
-	wg     sync.WaitGroup
+	// Options used to parse new and changed files
+	options ParseOptions
+	// Storage of database records
+	store recordStore
+	// Lock file held while database is open, nil for snapshots
+	lockFile *os.File
+	// Modification times of scanned directories, only loaded when unchanged directories are skipped
+	dirTimes map[string]time.Time
+	// Archives that have records of files inside them
+	archives map[string]bool
+	// Number of records written to database file, including outdated ones
+	dbLines int
+	lock    sync.RWMutex
+	wg      sync.WaitGroup
 }
 
-func addFileToDB(fh *FileHashes, record *FileMetadata) error {
-	if fh.dbPath == record.Path {
-		return errors.New("Tried to write db data to destination file")
+// getLockPath returns path of lock file kept next to database
+// Database file itself is not locked, since compaction replaces it
+func getLockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// lockDB acquires exclusive lock for database, so that other processes can not modify it concurrently
+// Lock is released by operating system when process exits, including when it is killed by signal
+func lockDB(dbPath string) (*os.File, error) {
+	file, err := os.OpenFile(getLockPath(dbPath), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLockFile(file)
+	if err != nil || !locked {
+		file.Close()
 	}
-	file, err := os.OpenFile(fh.dbPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
-	return writeRecordToFile(file, record)
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseInUse, dbPath)
+	}
+	return file, nil
 }
 
-func writeRecordToFile(file *os.File, record *FileMetadata) error {
-	data, err := json.Marshal(record)
-	if err != nil {
+// releaseDB releases database lock
+func releaseDB(file *os.File) error {
+	if err := unlockFile(file); err != nil {
+		file.Close()
 		return err
 	}
-	if _, err = file.Write(data); err != nil {
+	return file.Close()
+}
+
+// CloseDB closes database storage and releases its lock
+func CloseDB(fh *FileHashes) error {
+	if err := fh.store.close(); err != nil {
 		return err
 	}
-	if _, err = file.WriteString("\n"); err != nil {
-		return err
+	if fh.lockFile == nil {
+		return nil
 	}
-	log.Debugf("Saved metadata for %s\n", record.Path)
-	return nil
+	err := releaseDB(fh.lockFile)
+	fh.lockFile = nil
+	return err
 }
 
-func writeAllRecordsToDB(fh *FileHashes) error {
-	file, err := os.OpenFile(fh.dbPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+func addFileToDB(fh *FileHashes, record *FileMetadata) error {
+	if fh.dbPath == record.Path {
+		return ErrDBWriteToDestination
+	}
+	added, err := fh.store.put(getStoredRecord(record, fh.options.DBRoot))
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	for _, v := range fh.files {
-		if err := writeRecordToFile(file, v); err != nil {
-			return err
-		}
+	log.Debugf("Saved metadata for %s\n", record.Path)
+	if added {
+		fh.dbLines++
 	}
 	return nil
 }
 
+// getStoredRecord returns record as it is stored in database, paths under root are stored relative to it
+func getStoredRecord(record *FileMetadata, root string) *FileMetadata {
+	relPath, ok := getRelativeToRoot(record.Path, root)
+	if !ok {
+		return record
+	}
+	relative := *record
+	relative.Path = relPath
+	return &relative
+}
+
 // CompactDB rewrites database file with latest file records
 func CompactDB(fh *FileHashes) error {
 	backup := fh.dbPath + "." + strconv.FormatInt(time.Now().Unix(), 16)
 	log.Infof("Compacting db file %s with backup in %s\n", fh.dbPath, backup)
-	os.Rename(fh.dbPath, backup)
-	if err := writeAllRecordsToDB(fh); err != nil {
+	records := make([]*FileMetadata, 0, len(fh.files))
+	for _, record := range fh.files {
+		records = append(records, getStoredRecord(record, fh.options.DBRoot))
+	}
+	wal, err := openWAL(fh.dbPath)
+	if err != nil {
 		return err
 	}
-	return nil
+	if err := wal.begin("compact", fh.dbPath, backup); err != nil {
+		return err
+	}
+	if err := fh.store.rewrite(records, backup); err != nil {
+		// Failed compaction is rolled back from backup like interrupted one, so that log is not left behind
+		wal.file.Close()
+		if rollbackErr := replayWAL(fh.dbPath); rollbackErr != nil {
+			log.Errorf("Failed to roll back compaction of %s: %s\n", fh.dbPath, rollbackErr)
+		}
+		return err
+	}
+	if err := wal.commit("compact", fh.dbPath); err != nil {
+		return err
+	}
+	fh.dbLines = len(fh.files)
+	return wal.close()
+}
+
+// AutoCompactDB compacts database when outdated records exceed given percentage of current records
+func AutoCompactDB(fh *FileHashes, threshold float64) error {
+	stale := fh.dbLines - len(fh.files)
+	log.Debugf("Database has %d outdated records and %d current records\n", stale, len(fh.files))
+	if stale <= 0 || float64(stale)*100 <= threshold*float64(len(fh.files)) {
+		return nil
+	}
+	log.Infof("Database has %d outdated records, compacting\n", stale)
+	return CompactDB(fh)
+}
+
+// ReindexDB discards all records and hashes files at previously recorded paths and in folders again, so that no cached data is kept
+// Records of files that no longer exist are dropped and database is rewritten, number of records before and after is returned
+func ReindexDB(fh *FileHashes, folders []string, concurrency int) (int, int, error) {
+	before := len(fh.files)
+	previous := fh.files
+	var paths []string
+	for path := range previous {
+		// Archives are scanned again along with their entries
+		if isArchiveEntry(path) {
+			continue
+		}
+		if _, err := fsys.Lstat(path); os.IsNotExist(err) {
+			log.Debugf("Dropping record of missing %s\n", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fh.files = make(map[string]*FileMetadata)
+	fh.hashes = make(map[string][]*FileMetadata)
+	fh.archives = make(map[string]bool)
+	// Every file is hashed again, so unchanged folders are not skipped either
+	opts := fh.options
+	fh.options.SkipUnchangedDirs = false
+	err := ScanFolders(append(paths, folders...), fh, concurrency)
+	fh.options = opts
+	if err != nil {
+		return before, len(fh.files), err
+	}
+	for path, record := range fh.files {
+		if old := previous[path]; old != nil {
+			// Time when path was first recorded is not cached data, so it is kept
+			record.FirstSeen = old.FirstSeen
+		}
+	}
+	if err := CompactDB(fh); err != nil {
+		return before, len(fh.files), err
+	}
+	return before, len(fh.files), nil
 }
 
 func addRecord(fh *FileHashes, record *FileMetadata) {
 	fh.files[record.Path] = record
-	if record.Size > 0 {
-		fh.hashes[record.FileHash] = append(fh.hashes[record.FileHash], record)
+	addToIndex(fh.hashes, record, fh.options.MatchEmpty)
+}
+
+// isIndexed checks if record belongs to hash index, empty files all have same hash and are only indexed when matchEmpty is set
+// Symlinks are never indexed, and files that are not indexed are never reported as duplicates
+func isIndexed(record *FileMetadata, matchEmpty bool) bool {
+	return len(record.SymlinkTarget) == 0 && (record.Size > 0 || matchEmpty)
+}
+
+func addToIndex(hashes map[string][]*FileMetadata, record *FileMetadata, matchEmpty bool) {
+	if isIndexed(record, matchEmpty) {
+		hashes[record.FileHash] = append(hashes[record.FileHash], record)
 		if len(record.ImageHash) > 0 {
-			fh.hashes[record.ImageHash] = append(fh.hashes[record.ImageHash], record)
+			hashes[record.ImageHash] = append(hashes[record.ImageHash], record)
 		}
 	}
 }
 
 func removeRecord(fh *FileHashes, record *FileMetadata) {
 	delete(fh.files, record.Path)
+	if !isIndexed(record, fh.options.MatchEmpty) {
+		return
+	}
 	fh.hashes[record.FileHash] = deleteRecord(fh.hashes[record.FileHash], record)
 	if len(record.ImageHash) > 0 {
 		fh.hashes[record.ImageHash] = deleteRecord(fh.hashes[record.ImageHash], record)
@@ -112,15 +263,93 @@ func deleteRecord(records []*FileMetadata, record *FileMetadata) []*FileMetadata
 	return records
 }
 
+// CheckIndex rebuilds hash index from file records and reports discrepancies with existing index
+func CheckIndex(fh *FileHashes) int {
+	hashes := make(map[string][]*FileMetadata)
+	for _, record := range fh.files {
+		addToIndex(hashes, record, fh.options.MatchEmpty)
+	}
+	discrepancies := countMissingEntries(fh.hashes, hashes, "Stale index entry")
+	discrepancies += countMissingEntries(hashes, fh.hashes, "Missing index entry")
+	fh.hashes = hashes
+	if discrepancies > 0 {
+		log.Warningf("Rebuilt hash index, found %d discrepancies\n", discrepancies)
+	} else {
+		log.Infof("Hash index is consistent\n")
+	}
+	return discrepancies
+}
+
+// countMissingEntries counts records indexed in source that are not indexed under same hash in target
+func countMissingEntries(source map[string][]*FileMetadata, target map[string][]*FileMetadata, message string) int {
+	missing := 0
+	for hash, records := range source {
+		indexed := make(map[*FileMetadata]int)
+		for _, record := range target[hash] {
+			indexed[record]++
+		}
+		for _, record := range records {
+			if indexed[record] > 0 {
+				indexed[record]--
+				continue
+			}
+			log.Warningf("%s %s for %s\n", message, hash, record.Path)
+			missing++
+		}
+	}
+	return missing
+}
+
 type addFn func(fh *FileHashes, record *FileMetadata) (bool, error)
 
-type updatePathFn func(record *FileMetadata) (bool, error)
+type updatePathFn func(record *FileMetadata, root string) (bool, error)
+
+// remapPath replaces from prefix of path with to, returns false if path is not located under from
+func remapPath(path string, from string, to string) (string, bool) {
+	if path == from {
+		return to, true
+	}
+	prefix := fmt.Sprintf("%s%c", from, filepath.Separator)
+	if !strings.HasPrefix(path, prefix) {
+		return path, false
+	}
+	return normalizePath(filepath.Join(to, path[len(prefix):])), true
+}
 
-func updateToAbsolutePath(record *FileMetadata) (bool, error) {
-	newPath, err := filepath.Abs(record.Path)
+// getRelativeToRoot returns path relative to root if root is set and path is located under it
+func getRelativeToRoot(path string, root string) (string, bool) {
+	if len(root) == 0 || !strings.HasPrefix(path, fmt.Sprintf("%s%c", root, filepath.Separator)) {
+		return "", false
+	}
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(relPath), true
+}
+
+// updateToAbsolutePath converts record path to normalized absolute path, relative paths are resolved against root when it is set
+// Absolute paths under root are reported as updated, so that compaction rewrites them relative to root
+func updateToAbsolutePath(record *FileMetadata, root string) (bool, error) {
+	if len(root) > 0 {
+		if filepath.IsAbs(record.Path) || isRemotePath(record.Path) {
+			newPath := normalizePath(record.Path)
+			_, underRoot := getRelativeToRoot(newPath, root)
+			updated := newPath != record.Path
+			if updated {
+				log.Debugf("Normalizing path %s to %s\n", record.Path, newPath)
+				record.Path = newPath
+			}
+			return underRoot || updated, nil
+		}
+		record.Path = normalizePath(filepath.Join(root, filepath.FromSlash(record.Path)))
+		return false, nil
+	}
+	newPath, err := absPath(record.Path)
 	if err != nil {
 		return false, err
 	}
+	newPath = normalizePath(newPath)
 	updated := newPath != record.Path
 	if updated {
 		log.Debugf("Updating path %s to %s\n", record.Path, newPath)
@@ -140,44 +369,72 @@ func replaceLatestRecord(fh *FileHashes, record *FileMetadata) (bool, error) {
 	return needsCompacting, nil
 }
 
-func readDB(dbPath string, compact bool, addRec addFn, updatePath updatePathFn) (*FileHashes, error) {
+// ReadSnapshotDB reads database records as is, without checking files on disk or modifying database file
+// Relative paths are resolved against root, or current folder if root is not specified
+// Database is opened read-only and closed once records are loaded, so that it can be read while other process uses it
+func ReadSnapshotDB(dbPath string, root string) (*FileHashes, error) {
+	return readDB(dbPath, false, true, ParseOptions{DBRoot: root}, replaceLatestRecord, updateToAbsolutePath)
+}
+
+func readDB(dbPath string, compact bool, readOnly bool, opts ParseOptions, addRec addFn, updatePath updatePathFn) (*FileHashes, error) {
 	dbPath, err := filepath.Abs(dbPath)
 	if err != nil {
 		return nil, err
 	}
 	log.Infof("Reading database from %s\n", dbPath)
-	fh := &FileHashes{dbPath: dbPath, files: make(map[string]*FileMetadata), hashes: make(map[string][]*FileMetadata), lock: sync.RWMutex{}, wg: sync.WaitGroup{}}
-	file, err := os.Open(dbPath)
+	store, err := openStore(dbPath, readOnly)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fh, nil
-		}
 		return nil, err
 	}
-	defer file.Close()
+	fh := &FileHashes{dbPath: dbPath, files: make(map[string]*FileMetadata), hashes: make(map[string][]*FileMetadata), archives: make(map[string]bool), options: opts, store: store, lock: sync.RWMutex{}, wg: sync.WaitGroup{}}
 	needsCompacting := false
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		record := &FileMetadata{}
-		err := json.Unmarshal(scanner.Bytes(), record)
-		if err != nil {
-			return nil, err
-		}
+	lines := 0
+	remapped, missing := 0, 0
+	err = store.load(func(record *FileMetadata) error {
+		lines++
 		if len(record.Path) > 0 {
-			updated, err := updatePath(record)
+			updated, err := updatePath(record, opts.DBRoot)
 			if err != nil {
-				return nil, err
+				return err
+			}
+			if len(opts.RemapFrom) > 0 {
+				if newPath, ok := remapPath(record.Path, opts.RemapFrom, opts.RemapTo); ok {
+					log.Debugf("Remapping path %s to %s\n", record.Path, newPath)
+					record.Path = newPath
+					updated = true
+					remapped++
+					if _, err := fsys.Stat(newPath); err != nil {
+						missing++
+					}
+				}
 			}
 			refreshed, err := addRec(fh, record)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			needsCompacting = needsCompacting || refreshed || updated
 		}
-	}
-	if err := scanner.Err(); err != nil {
+		return nil
+	})
+	if err != nil {
+		store.close()
 		return nil, err
 	}
+	if len(opts.RemapFrom) > 0 {
+		log.Infof("Remapped %d paths from %s to %s\n", remapped, opts.RemapFrom, opts.RemapTo)
+		if remapped == 0 {
+			log.Warningf("No paths in database start with %s\n", opts.RemapFrom)
+		} else if missing > 0 {
+			log.Warningf("%d remapped paths do not exist, check -remap value\n", missing)
+		}
+	}
+	// Refreshed records are appended to the file being read, so they are already counted
+	fh.dbLines = lines
+	if readOnly {
+		if err := store.close(); err != nil {
+			return nil, err
+		}
+	}
 	if compact && needsCompacting {
 		if err := CompactDB(fh); err != nil {
 			return nil, err
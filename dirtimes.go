@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// getDirTimesPath returns path of file with modification times of scanned directories for database
+func getDirTimesPath(dbPath string) string {
+	return dbPath + ".dirs"
+}
+
+// loadDirTimes reads modification times of directories recorded by previous scans
+func loadDirTimes(path string) (map[string]time.Time, error) {
+	dirTimes := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return dirTimes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &dirTimes); err != nil {
+		return nil, err
+	}
+	return dirTimes, nil
+}
+
+// saveDirTimes writes modification times of directories into temporary file and replaces previous file with it
+func saveDirTimes(path string, dirTimes map[string]time.Time) error {
+	data, err := json.Marshal(dirTimes)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// walkChangedDirs walks file tree like filepath.Walk, but files are not listed in directories
+// whose modification time did not change since it was recorded in dirTimes
+// Subdirectories of unchanged directories are still walked, since their changes are not reflected in parent modification time
+func walkChangedDirs(root string, dirTimes map[string]time.Time, walkFn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = walkChangedDir(root, info, dirTimes, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkChangedDir(path string, info os.FileInfo, dirTimes map[string]time.Time, walkFn filepath.WalkFunc) error {
+	if err := walkFn(path, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	recorded, ok := dirTimes[path]
+	unchanged := ok && recorded.Equal(info.ModTime())
+	if unchanged {
+		log.Debugf("Skipping files in unchanged directory %s\n", path)
+	}
+	for _, entry := range entries {
+		if unchanged && !entry.IsDir() {
+			continue
+		}
+		childPath := filepath.Join(path, entry.Name())
+		if err := walkChangedDir(childPath, entry, dirTimes, walkFn); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	// Only record directory once all its files were queued, so that interrupted scan lists them again
+	dirTimes[path] = info.ModTime()
+	return nil
+}
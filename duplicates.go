@@ -1,57 +1,206 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-// Pick oldest files, unless it's an image with larger size
-func pickMaster(candidates map[*FileMetadata]bool, duplicatePrefix string, masterPrefix string) *FileMetadata {
-	var selected *FileMetadata
+// MasterPolicy controls how master is picked among duplicates
+type MasterPolicy struct {
+	// Prefer newest files instead of oldest ones, affects comparisons of shooting date, modification and creation times.
+	// Files with known shooting date are preferred over files without it regardless of this setting.
+	PreferNewest bool
+	// Prefer smaller files instead of larger ones
+	PreferSmaller bool
+	// Prefer files modified later regardless of PreferNewest, e.g. most recently synced copy when sync tools reset creation time
+	PreferNewestModified bool
+	// Order in which master rules are applied after folder rules, DefaultMasterOrder is used when empty
+	Order []string
+	// Files in these paths are always preferred as masters, even over files in masters folder, and never returned as duplicates
+	Protected ProtectedPaths
+	// Times that differ by at most this much are treated as equal, so that next rule decides, e.g. when copying changed them slightly
+	TimeTolerance time.Duration
+}
+
+// DefaultMasterOrder lists master rules in default order of precedence
+var DefaultMasterOrder = []string{"size", "shot", "modified", "created"}
+
+// masterRule checks if rule tells candidate and selected master apart and if candidate is preferred
+type masterRule func(policy MasterPolicy, candidate *FileMetadata, selected *FileMetadata) (bool, bool)
+
+var masterRules = map[string]masterRule{
+	"size": func(policy MasterPolicy, candidate *FileMetadata, selected *FileMetadata) (bool, bool) {
+		// Picking larger files since they most likely have more metadata with same image data
+		return candidate.Size != selected.Size, (candidate.Size > selected.Size) != policy.PreferSmaller
+	},
+	"shot": func(policy MasterPolicy, candidate *FileMetadata, selected *FileMetadata) (bool, bool) {
+		// Pick earliest shooting date
+		// Files without shooting date are ranked after all files with it, also when PreferNewest is set,
+		// so that they only win when no candidate has it and next rule decides between them
+		if candidate.DateShot.IsZero() || selected.DateShot.IsZero() {
+			return candidate.DateShot.IsZero() != selected.DateShot.IsZero(), selected.DateShot.IsZero()
+		}
+		return policy.timesDiffer(candidate.DateShot, selected.DateShot), policy.isPreferredTime(candidate.DateShot, selected.DateShot)
+	},
+	"modified": func(policy MasterPolicy, candidate *FileMetadata, selected *FileMetadata) (bool, bool) {
+		// For copied files modification date would be more accurate than creation date
+		// Pick file that was modified earlier, or later per policy
+		if policy.PreferNewestModified {
+			return policy.timesDiffer(candidate.Modified, selected.Modified), candidate.Modified.Unix() > selected.Modified.Unix()
+		}
+		return policy.timesDiffer(candidate.Modified, selected.Modified), policy.isPreferredTime(candidate.Modified, selected.Modified)
+	},
+	"created": func(policy MasterPolicy, candidate *FileMetadata, selected *FileMetadata) (bool, bool) {
+		// Pick file that is older
+		return policy.timesDiffer(candidate.Created, selected.Created), policy.isPreferredTime(candidate.Created, selected.Created)
+	},
+}
+
+// ParseMasterOrder parses comma separated list of master rules, rules that are not listed are applied afterwards in default order
+func ParseMasterOrder(value string) ([]string, error) {
+	order := make([]string, 0, len(DefaultMasterOrder))
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if masterRules[name] == nil {
+			return nil, fmt.Errorf("Unknown master rule %s", name)
+		}
+		if listed[name] {
+			return nil, fmt.Errorf("Master rule %s is listed more than once", name)
+		}
+		listed[name] = true
+		order = append(order, name)
+	}
+	for _, name := range DefaultMasterOrder {
+		if !listed[name] {
+			order = append(order, name)
+		}
+	}
+	return order, nil
+}
+
+// timesDiffer checks if times differ by more than policy tolerance at one second precision
+func (policy MasterPolicy) timesDiffer(candidate time.Time, selected time.Time) bool {
+	diff := candidate.Unix() - selected.Unix()
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > int64(policy.TimeTolerance/time.Second)
+}
+
+// isPreferredTime checks if candidate time is preferred over selected time at one second precision
+func (policy MasterPolicy) isPreferredTime(candidate time.Time, selected time.Time) bool {
+	if policy.PreferNewest {
+		return candidate.Unix() > selected.Unix()
+	}
+	return candidate.Unix() < selected.Unix()
+}
+
+// isPreferred applies master rules in policy order and checks if candidate is preferred over selected master
+// Name of rule that told them apart is returned along, it is empty when no rule did
+func (policy MasterPolicy) isPreferred(candidate *FileMetadata, selected *FileMetadata) (bool, string) {
+	order := policy.Order
+	if len(order) == 0 {
+		order = DefaultMasterOrder
+	}
+	for _, name := range order {
+		if differs, preferred := masterRules[name](policy, candidate, selected); differs {
+			return preferred, name
+		}
+	}
+	return false, ""
+}
+
+// describeMasterRule explains why master rule preferred master over other file
+func describeMasterRule(name string, master *FileMetadata, other *FileMetadata) string {
+	earlier := map[string]string{"shot": "was shot", "modified": "was modified", "created": "was created"}
+	var masterTime, otherTime time.Time
+	switch name {
+	case "size":
+		if master.Size > other.Size {
+			return "is larger"
+		}
+		return "is smaller"
+	case "shot":
+		if other.DateShot.IsZero() {
+			return "has shooting date"
+		}
+		masterTime, otherTime = master.DateShot, other.DateShot
+	case "modified":
+		masterTime, otherTime = master.Modified, other.Modified
+	case "created":
+		masterTime, otherTime = master.Created, other.Created
+	default:
+		return name
+	}
+	if masterTime.Before(otherTime) {
+		return earlier[name] + " earlier"
+	}
+	return earlier[name] + " later"
+}
+
+// compareMasters checks if candidate is preferred over selected master and explains why preferred one of them wins
+func compareMasters(candidate *FileMetadata, selected *FileMetadata, duplicatePrefix string, masterPrefix string, policy MasterPolicy) (bool, string) {
+	if policy.Protected.Contains(candidate.Path) != policy.Protected.Contains(selected.Path) {
+		// Pick protected master, since protected files can never be duplicates
+		return policy.Protected.Contains(candidate.Path), "is protected"
+	} else if strings.HasPrefix(candidate.Path, masterPrefix) != strings.HasPrefix(selected.Path, masterPrefix) {
+		// Pick master inside masters folder
+		return strings.HasPrefix(candidate.Path, masterPrefix), "is in masters folder"
+	} else if strings.HasPrefix(candidate.Path, duplicatePrefix) != strings.HasPrefix(selected.Path, duplicatePrefix) {
+		// Pick master outside of searched folder
+		return strings.HasPrefix(selected.Path, duplicatePrefix), "is outside of duplicates folder"
+	} else if isArchiveEntry(candidate.Path) != isArchiveEntry(selected.Path) {
+		// Pick master outside of archives, so that archived copies are reported as duplicates
+		return isArchiveEntry(selected.Path), "is outside of archive"
+	}
+	preferred, rule := policy.isPreferred(candidate, selected)
+	if len(rule) == 0 {
+		return false, "no rule tells files apart"
+	}
+	if preferred {
+		return true, describeMasterRule(rule, candidate, selected)
+	}
+	return false, describeMasterRule(rule, selected, candidate)
+}
+
+// Pick oldest (or newest per policy) files, unless it's an image with larger size
+// Reasons why master was picked over each of other candidates are returned along with it
+func pickMaster(candidates map[*FileMetadata]bool, duplicatePrefix string, masterPrefix string, policy MasterPolicy) (*FileMetadata, map[*FileMetadata]string) {
+	// Times within tolerance are equal, which is not transitive, so candidates are compared in fixed order to pick same master every time
+	sorted := make([]*FileMetadata, 0, len(candidates))
 	for candidate := range candidates {
+		sorted = append(sorted, candidate)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	var selected *FileMetadata
+	for _, candidate := range sorted {
 		log.Debugf("Master candidate %s\n", candidate.Path)
 		if selected == nil {
 			selected = candidate
-		} else if strings.HasPrefix(candidate.Path, masterPrefix) != strings.HasPrefix(selected.Path, masterPrefix) {
-			// Pick master inside masters folder
-			if !strings.HasPrefix(selected.Path, masterPrefix) {
-				selected = candidate
-			}
-		} else if strings.HasPrefix(candidate.Path, duplicatePrefix) != strings.HasPrefix(selected.Path, duplicatePrefix) {
-			// Pick master outside of searched folder
-			if strings.HasPrefix(selected.Path, duplicatePrefix) {
-				selected = candidate
-			}
-		} else if candidate.Size != selected.Size {
-			// Picking larger files since they most likely have more metadata with same image data
-			if candidate.Size > selected.Size {
-				selected = candidate
-			}
-		} else if !candidate.DateShot.IsZero() && (selected.DateShot.IsZero() || candidate.DateShot.Unix() != selected.DateShot.Unix()) {
-			// Pick earliest shooting date
-			if selected.DateShot.IsZero() || candidate.DateShot.Unix() < selected.DateShot.Unix() {
-				selected = candidate
-			}
-		} else if candidate.Modified.Unix() != selected.Modified.Unix() {
-			// For copied files modification date would be more accurate than creation date
-			// Pick file that was modified earlier
-			if candidate.Modified.Unix() < selected.Modified.Unix() {
-				selected = candidate
-			}
-		} else if candidate.Created.Unix() != selected.Created.Unix() {
-			// Pick file that is older
-			if candidate.Created.Unix() < selected.Created.Unix() {
-				selected = candidate
-			}
+		} else if preferred, _ := compareMasters(candidate, selected, duplicatePrefix, masterPrefix, policy); preferred {
+			selected = candidate
+		}
+	}
+	reasons := make(map[*FileMetadata]string, len(candidates))
+	for candidate := range candidates {
+		if candidate != selected {
+			_, reasons[candidate] = compareMasters(candidate, selected, duplicatePrefix, masterPrefix, policy)
 		}
 	}
-	return selected
+	return selected, reasons
 }
 
-func getDupsForFile(record *FileMetadata, visited map[string]*FileMetadata, prefix string, filesWithSameHash []*FileMetadata, foundDups map[*FileMetadata]bool) {
+func getDupsForFile(record *FileMetadata, visited map[string]*FileMetadata, prefix string, filesWithSameHash []*FileMetadata, foundDups map[*FileMetadata]bool, sameExtension bool) {
 	found := false
 	if len(filesWithSameHash) > 1 {
 		for _, dupPath := range filesWithSameHash {
@@ -67,6 +216,9 @@ func getDupsForFile(record *FileMetadata, visited map[string]*FileMetadata, pref
 			if _, ok := foundDups[dupPath]; ok {
 				continue
 			}
+			if sameExtension && !hasSameExtension(record.Path, dupPath.Path) {
+				continue
+			}
 			if record.FileHash == dupPath.FileHash {
 				log.Debugf("Found exact duplicate %s\n", dupPath.Path)
 			} else {
@@ -82,23 +234,150 @@ func getDupsForFile(record *FileMetadata, visited map[string]*FileMetadata, pref
 	}
 }
 
+// hasSameExtension checks if both paths have same extension regardless of case, e.g. .JPG and .jpg
+func hasSameExtension(a string, b string) bool {
+	return strings.EqualFold(filepath.Ext(a), filepath.Ext(b))
+}
+
+// isSameFile checks if both records point to same physical file, e.g. reached through bind mount or hard link
+func isSameFile(a *FileMetadata, b *FileMetadata) bool {
+	return a.Inode != 0 && a.DeviceID == b.DeviceID && a.Inode == b.Inode
+}
+
+// Match types from most to least trusted
+const (
+	// StrictMatch is byte-identical file
+	StrictMatch = "Strict Match"
+	// PixelMatch is image with identical decoded pixels, which only differs in metadata (e.g. appended XMP or IPTC block)
+	// Image hash is calculated on losslessly encoded pixels, so scaled or recompressed images are never pixel matches
+	PixelMatch = "Pixel Match"
+	// FuzzyMatch is file that is only likely to be duplicate, e.g. video with same duration and similar size, it is only reported for manual review
+	FuzzyMatch = "Fuzzy Match"
+)
+
+// getMatchType describes how duplicate matches master
+func getMatchType(master *FileMetadata, dup *FileMetadata) string {
+	if master.FileHash == dup.FileHash {
+		return StrictMatch
+	}
+	return PixelMatch
+}
+
+// ListingFormat controls how found duplicates are printed while searching
+// Formats are passed duplicate (or master) path, master path and message as arguments, empty format skips the line
+type ListingFormat struct {
+	Master string
+	// Duplicate and Image are used for strict and pixel matches, message argument is reason why master was picked over duplicate
+	Duplicate string
+	Image     string
+	Skipped   string
+	// Audio is used for fuzzy audio matches, message argument is their similarity
+	Audio string
+	// Kept is used for files that are kept after moving duplicates
+	Kept string
+	// GroupEnd is printed as is after master and all its duplicates were printed
+	GroupEnd string
+}
+
+// ListingFormats contains named presets for duplicates listing
+var ListingFormats = map[string]ListingFormat{
+	"default": {Master: "* Duplicates for: %[1]s\n", Duplicate: "    %[1]s\n", Image: "?   Image duplicate: %[1]s\n", Skipped: "!   %[3]s: %[1]s\n", Audio: "~   Audio match (%[3]s): %[1]s\n", Kept: "%[1]s\n"},
+	"tabbed":  {Master: "master\t%[1]s\n", Duplicate: "duplicate\t%[1]s\t%[2]s\t%[3]s\n", Image: "image\t%[1]s\t%[2]s\t%[3]s\n", Skipped: "skipped\t%[1]s\t%[2]s\t%[3]s\n", Audio: "audio\t%[1]s\t%[2]s\t%[3]s\n", Kept: "kept\t%[1]s\n"},
+	"grouped": {Master: "master\t%[1]s\n", Duplicate: "strict\t%[1]s\n", Image: "pixel\t%[1]s\n", Audio: "audio\t%[1]s\n", Kept: "%[1]s\n", GroupEnd: "\n"},
+	"null":    {Duplicate: "%[1]s\x00", Image: "%[1]s\x00", Kept: "%[1]s\x00"},
+	"none":    {},
+}
+
+func (listing ListingFormat) print(format string, path string, master string, message string) {
+	if len(format) > 0 {
+		fmt.Printf(format, path, master, message)
+	}
+}
+
+func (listing ListingFormat) endGroup() {
+	fmt.Print(listing.GroupEnd)
+}
+
+// SearchOptions controls duplicate search
+type SearchOptions struct {
+	// If specified, only duplicate files from that folder will be returned
+	DuplicatesFolder string
+	// If specified, only duplicates of files present in that folder will be returned
+	MastersFolder string
+	// Policy used to pick masters
+	Policy MasterPolicy
+	// Format used to print duplicates while searching, nothing is printed by default
+	Listing ListingFormat
+	// Stop after finding this many duplicate groups, database is searched in no particular order so results are an arbitrary subset
+	Limit int
+	// Save visited files into checkpoint next to database, so that interrupted search can be resumed
+	// Groups found before interruption are not returned again when search is resumed
+	Resumable bool
+	// Only count duplicates into Stats without returning them, so that huge databases can be summarized with less memory
+	CountOnly bool
+	// If specified, receives statistics of found duplicates
+	Stats *DuplicateStats
+	// If specified, receives reasons why master was picked over each of returned duplicates, e.g. "is larger"
+	Reasons map[*FileMetadata]string
+	// Skip files that are same physical file as master or other duplicate, e.g. reached through different mount points
+	SameFileCheck bool
+	// Only look for duplicates within same directory, so that one copy of each file is kept in every directory
+	PerDirectory bool
+	// Also list files with similar audio fingerprints, audio matches are never returned
+	AudioMatches bool
+	// Only match byte-identical files by file hash, image hashes recorded in database are ignored
+	FileHashOnly bool
+	// Only match files with same extension, e.g. so that RAW and JPEG images with same pixels are kept
+	SameExtension bool
+	// Number of workers searching groups of files with shared hashes in parallel, one worker is used when not set
+	Concurrency int
+}
+
+// DuplicateStats summarizes found duplicates
+type DuplicateStats struct {
+	Groups      int
+	Duplicates  int
+	Reclaimable int64
+}
+
+// keepSameDirectory removes duplicates located outside of record directory, record itself is removed if no duplicates are left
+func keepSameDirectory(record *FileMetadata, dups map[*FileMetadata]bool) {
+	dir := filepath.Dir(record.Path)
+	for dup := range dups {
+		if filepath.Dir(dup.Path) != dir {
+			delete(dups, dup)
+		}
+	}
+	if len(dups) == 1 {
+		delete(dups, record)
+	}
+}
+
+// findSameFile returns record from list that points to same physical file as given record
+func findSameFile(records []*FileMetadata, record *FileMetadata) *FileMetadata {
+	for _, other := range records {
+		if isSameFile(other, record) {
+			return other
+		}
+	}
+	return nil
+}
+
 // FindDuplicates tries to find duplicate files in database
-// If folderToScanForMasters is specified, only duplicates of files present in that folder will be returned
-// If folderToScanForDuplicates is specified, only duplicate files from that directory will be returned
-func FindDuplicates(folderToScanForDuplicates string, folderToScanForMasters string, fh *FileHashes) (map[*FileMetadata][]*FileMetadata, error) {
+func FindDuplicates(opts SearchOptions, fh *FileHashes) (map[*FileMetadata][]*FileMetadata, error) {
 	result := make(map[*FileMetadata][]*FileMetadata)
 	visited := make(map[string]*FileMetadata)
 	duplicatePrefix := ""
-	if len(folderToScanForDuplicates) > 0 {
-		folderToScanForDuplicates, err := filepath.Abs(folderToScanForDuplicates)
+	if len(opts.DuplicatesFolder) > 0 {
+		folderToScanForDuplicates, err := absPath(opts.DuplicatesFolder)
 		if err != nil {
 			return nil, err
 		}
 		duplicatePrefix = fmt.Sprintf("%s%c", folderToScanForDuplicates, filepath.Separator)
 	}
 	masterPrefix := ""
-	if len(folderToScanForMasters) > 0 {
-		folderToScanForMasters, err := filepath.Abs(folderToScanForMasters)
+	if len(opts.MastersFolder) > 0 {
+		folderToScanForMasters, err := absPath(opts.MastersFolder)
 		if err != nil {
 			return nil, err
 		}
@@ -113,99 +392,412 @@ func FindDuplicates(folderToScanForDuplicates string, folderToScanForMasters str
 	} else {
 		log.Infof("Searching for duplicates across all db\n")
 	}
-	for path, record := range fh.files {
-		if visited[path] != nil {
-			continue
+	var checkpoint *os.File
+	if opts.Resumable {
+		checkpointPath := getCheckpointPath(fh.dbPath)
+		if err := loadCheckpoint(checkpointPath, visited, fh); err != nil {
+			return nil, err
 		}
-		if len(masterPrefix) > 0 {
-			if !strings.HasPrefix(path, masterPrefix) {
-				continue
-			}
-		} else if len(duplicatePrefix) > 0 && !strings.HasPrefix(path, duplicatePrefix) {
-			continue
+		var err error
+		checkpoint, err = os.OpenFile(checkpointPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, err
 		}
-		prefix := ""
-		dups := make(map[*FileMetadata]bool)
-		if len(masterPrefix) > 0 {
-			// With masters we only care about finding duplicates in duplicates directory
-			prefix = duplicatePrefix
-		} else {
-			// Find masters anywhere given file in duplicates directory
-		}
-		if len(prefix) > 0 {
-			log.Debugf("Looking for duplicates of %s in %s\n", record.Path, prefix)
-		} else {
-			log.Debugf("Looking for duplicates of %s\n", record.Path)
-		}
-		getDupsForFile(record, visited, prefix, fh.hashes[record.FileHash], dups)
-		if len(record.ImageHash) > 0 {
-			getDupsForFile(record, visited, prefix, fh.hashes[record.ImageHash], dups)
-		}
-		if len(dups) > 0 {
-			var master *FileMetadata
-			master = pickMaster(dups, duplicatePrefix, masterPrefix)
-			log.Debugf("Picked master: %s (Shot: %s, Created: %s, Modified: %s)\n", master.Path, master.DateShot, master.Created, master.Modified)
-			fmt.Printf("* Duplicates for: %s\n", master.Path)
-			resultDups := make([]*FileMetadata, 0)
-			visited[master.Path] = master
-			for dup := range dups {
-				if dup == master {
-					continue
-				}
-				isStrictMatch := master.FileHash == dup.FileHash
-				matchType := "Strict Match"
-				if !isStrictMatch {
-					matchType = "Image Match"
-				}
-				log.Debugf("Duplicate File: %s (%s, Shot: %s, Created: %s, Modified: %s)\n", dup.Path, matchType, dup.DateShot, dup.Created, dup.Modified)
-				if len(masterPrefix) > 0 && strings.HasPrefix(dup.Path, masterPrefix) && masterPrefix != duplicatePrefix {
-					fmt.Printf("!   Duplicate is in master directory: %s\n", dup.Path)
-				} else if len(duplicatePrefix) > 0 && !strings.HasPrefix(dup.Path, duplicatePrefix) {
-					fmt.Printf("!   Duplicate outside duplicates directory: %s\n", dup.Path)
-				} else if len(masterPrefix) > 0 && !strings.HasPrefix(master.Path, masterPrefix) {
-					fmt.Printf("!   Master is outside of master directory: %s\n", dup.Path)
-				} else {
-					if !isStrictMatch {
-						fmt.Printf("?   Image duplicate: %s\n", dup.Path)
-					} else {
-						fmt.Printf("    %s\n", dup.Path)
-						visited[dup.Path] = dup
+		defer checkpoint.Close()
+	}
+	complete := true
+	stats := DuplicateStats{}
+	search := &duplicateSearch{opts: opts, fh: fh, visited: visited, duplicatePrefix: duplicatePrefix, masterPrefix: masterPrefix}
+	done := make(chan struct{})
+	defer close(done)
+	for group := range search.run(done) {
+		// Groups are printed and counted in one place, so that output of groups found in parallel is not interleaved
+		for _, line := range group.lines {
+			opts.Listing.print(line.format, line.path, line.master, line.message)
+		}
+		opts.Listing.endGroup()
+		if len(group.dups) > 0 {
+			stats.Groups++
+			for _, dup := range group.dups {
+				stats.Duplicates++
+				stats.Reclaimable += dup.Size
+			}
+			if !opts.CountOnly {
+				result[group.master] = group.dups
+				if opts.Reasons != nil {
+					for dup, reason := range group.reasons {
+						opts.Reasons[dup] = reason
 					}
-					resultDups = append(resultDups, dup)
 				}
 			}
-			if len(resultDups) > 0 {
-				result[master] = resultDups
+		}
+		if checkpoint != nil {
+			if err := saveCheckpoint(checkpoint, group.visited); err != nil {
+				return nil, err
+			}
+		}
+		if opts.Limit > 0 && stats.Groups >= opts.Limit {
+			log.Infof("Stopping after finding %d duplicate groups\n", stats.Groups)
+			complete = false
+			break
+		}
+	}
+	if opts.AudioMatches && complete {
+		var prefixes []string
+		for _, prefix := range []string{duplicatePrefix, masterPrefix} {
+			if len(prefix) > 0 {
+				prefixes = append(prefixes, prefix)
 			}
 		}
+		printAudioMatches(fh, prefixes, opts.Listing, opts.Policy)
+	}
+	if checkpoint != nil && complete {
+		// Search is complete, next one should start from scratch
+		checkpoint.Close()
+		if err := os.Remove(checkpoint.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Stats != nil {
+		*opts.Stats = stats
 	}
 	log.Infof("Done looking for duplicates\n")
 	return result, nil
 }
 
-// MoveDuplicates moves found duplicates to destination folder with preserving relative path
-func MoveDuplicates(moveDuplicatesTo string, removePrefix string, dups map[*FileMetadata][]*FileMetadata, fh *FileHashes, applyMove bool) (bool, error) {
-	moveDuplicatesTo, err := filepath.Abs(moveDuplicatesTo)
+// MoveOptions controls where and how duplicates are moved
+type MoveOptions struct {
+	// Destination folder for duplicates
+	Destination string
+	// Prefix to strip from duplicate paths, volume name is stripped by default
+	RemovePrefix string
+	// Folder that must never be modified, any attempt to move files from or into it is an error
+	ReadOnlyFolder string
+	// Paths that must never be modified, any attempt to move files from or into them is an error, and their files are not moved along with duplicates
+	Protected ProtectedPaths
+	// Script that receives commands of intended moves when Apply is not set, nothing is written when nil
+	Script *MoveScript
+	// Name moved files after their shooting date when it is known
+	RenameByDate bool
+	// Template of path relative to destination with placeholders, e.g. {year}/{month}/{basename}, relative path is kept when empty
+	Template string
+	// Actually move files instead of printing intended actions
+	Apply bool
+	// List directories left empty after moving duplicates
+	ReportEmptyDirs bool
+	// Remove directories left empty after moving duplicates, implies ReportEmptyDirs
+	RemoveEmptyDirs bool
+	// Stop moving when free space at destination would drop below specified number of bytes
+	MinFreeSpace int64
+	// Only move byte-identical duplicates, pixel matches are reported but kept in place
+	StrictOnly bool
+	// Leave symlink to master at original path of each moved duplicate, so that existing links to it keep working
+	Quarantine bool
+	// Policy of moving halves of Live Photos (LivePhotosKeep or LivePhotosMove), they are moved like other files when empty or LivePhotosOff
+	LivePhotos string
+	// Extensions of still images and videos paired as Live Photos
+	LivePhotoPairing LivePhotoPairing
+	// Policy of sidecar files of moved duplicates (SidecarsMove or SidecarsDelete), they are left in place when empty or SidecarsLeave
+	Sidecars string
+	// Extensions of sidecar files with same name as media, e.g. .xmp
+	SidecarExtensions []string
+}
+
+// dateFileNameLayout is used to name files after their shooting date
+const dateFileNameLayout = "2006-01-02_15-04-05"
+
+// getRelativeDestination returns path of record relative to destination folder, with prefix or volume name stripped,
+// or built from template
+func getRelativeDestination(record *FileMetadata, opts MoveOptions) (string, error) {
+	var err error
+	base := fmt.Sprintf("%s%c", filepath.VolumeName(record.Path), filepath.Separator)
+	if len(opts.RemovePrefix) > 0 {
+		if base, err = absPath(opts.RemovePrefix); err != nil {
+			return "", err
+		}
+	}
+	relPath, err := filepath.Rel(base, record.Path)
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	moved := false
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		// Otherwise file would end up outside of destination folder
+		return "", fmt.Errorf("%w: %s", ErrOutsidePrefix, record.Path)
+	}
+	if opts.RenameByDate && !record.DateShot.IsZero() {
+		// Name file after its shooting date keeping original extension
+		relPath = filepath.Join(filepath.Dir(relPath), record.DateShot.Format(dateFileNameLayout)+filepath.Ext(relPath))
+	}
+	if len(opts.Template) > 0 {
+		return expandMoveTemplate(opts.Template, record, relPath)
+	}
+	return relPath, nil
+}
+
+// planDestinations returns destinations of moved duplicates relative to destination folder
+// Duplicates that would end up at same path, e.g. when they are renamed after same shooting date, get numbered suffix in order of their paths
+func planDestinations(dups map[*FileMetadata][]*FileMetadata, opts MoveOptions, duplicatePaths map[string]bool, destination string) (map[*FileMetadata]string, error) {
+	var records []*FileMetadata
 	for _, list := range dups {
 		for _, p := range list {
-			var relPath string
-			var err error
-			if len(removePrefix) > 0 {
-				removePrefix, err := filepath.Abs(removePrefix)
-				if err != nil {
-					return moved, err
+			if duplicatePaths[p.Path] {
+				records = append(records, p)
+			}
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Path < records[j].Path
+	})
+	destinations := make(map[*FileMetadata]string, len(records))
+	planned := make(map[string]bool, len(records))
+	for _, record := range records {
+		relPath, err := getRelativeDestination(record, opts)
+		if err != nil {
+			return nil, err
+		}
+		if planned[relPath] {
+			ext := filepath.Ext(relPath)
+			for i := 1; ; i++ {
+				candidate := fmt.Sprintf("%s_%d%s", strings.TrimSuffix(relPath, ext), i, ext)
+				if _, err := fsys.Lstat(filepath.Join(destination, candidate)); !planned[candidate] && os.IsNotExist(err) {
+					log.Warningf("%s would be moved to same path as other duplicate, moving it to %s instead\n", record.Path, candidate)
+					relPath = candidate
+					break
 				}
-				relPath, err = filepath.Rel(removePrefix, p.Path)
+			}
+		}
+		planned[relPath] = true
+		destinations[record] = relPath
+	}
+	return destinations, nil
+}
+
+// companionMove is file that belongs to moved duplicate with its destination
+type companionMove struct {
+	path        string
+	destination string
+}
+
+// getCompanions returns files that belong to duplicate and are moved or deleted along with it, they are moved on their own when they are duplicates as well
+// Reason is returned instead when duplicate can not be moved with them, e.g. when its Live Photo pair is kept or destination of companion exists
+func getCompanions(p *FileMetadata, newPath string, opts MoveOptions, duplicatePaths map[string]bool) ([]companionMove, []string, string, error) {
+	var companions []companionMove
+	var deletions []string
+	if opts.LivePhotos == LivePhotosKeep || opts.LivePhotos == LivePhotosMove {
+		if pair, ok := getLivePhotoPair(p.Path, opts.LivePhotoPairing); ok && !duplicatePaths[pair] {
+			if opts.LivePhotos == LivePhotosKeep || opts.Protected.Contains(pair) {
+				return nil, nil, fmt.Sprintf("its Live Photo pair %s is not a duplicate", pair), nil
+			}
+			companions = append(companions, companionMove{pair, getCompanionDestination(newPath, pair)})
+		}
+	}
+	if opts.Sidecars == SidecarsMove || opts.Sidecars == SidecarsDelete {
+		sidecars, err := getDuplicateSidecars(p.Path, opts, duplicatePaths)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for _, sidecar := range sidecars {
+			if opts.Sidecars == SidecarsMove {
+				companions = append(companions, companionMove{sidecar, getSidecarDestination(p.Path, newPath, sidecar)})
 			} else {
-				relPath, err = filepath.Rel(fmt.Sprintf("%s%c", filepath.VolumeName(p.Path), filepath.Separator), p.Path)
+				deletions = append(deletions, sidecar)
+			}
+		}
+	}
+	// Duplicate is only moved when all files that belong to it can be moved too, so that none of them is left behind
+	if taken, err := findTakenDestination(companions); err != nil {
+		return nil, nil, "", err
+	} else if taken != nil {
+		return nil, nil, fmt.Sprintf("destination %s of %s already exists", taken.destination, taken.path), nil
+	}
+	return companions, deletions, "", nil
+}
+
+// findTakenDestination returns companion whose destination already exists, nil when all of them can be moved
+func findTakenDestination(companions []companionMove) (*companionMove, error) {
+	for i := range companions {
+		if _, err := fsys.Stat(companions[i].destination); err == nil {
+			return &companions[i], nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// moveCompanions moves and deletes files that belong to moved duplicate, their paths are added to movedPaths
+func moveCompanions(companions []companionMove, deletions []string, opts MoveOptions, wal *writeAheadLog, fh *FileHashes, movedPaths map[string]bool) error {
+	for _, companion := range companions {
+		if err := moveCompanion(companion.path, companion.destination, opts, wal, fh); err != nil {
+			return err
+		}
+		movedPaths[companion.path] = true
+	}
+	for _, path := range deletions {
+		if err := deleteCompanion(path, opts, fh); err != nil {
+			return err
+		}
+		movedPaths[path] = true
+	}
+	return nil
+}
+
+// moveCompanion moves file that belongs to moved duplicate, e.g. other half of Live Photo, its intended move is only printed without Apply
+func moveCompanion(path string, newPath string, opts MoveOptions, wal *writeAheadLog, fh *FileHashes) error {
+	f, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, err := fsys.Stat(newPath); err == nil || !os.IsNotExist(err) {
+		return &MoveError{Path: path, Destination: newPath, Err: ErrDestinationExists}
+	}
+	fmt.Printf("%011d Moving %s to %s\n", f.Size(), path, newPath)
+	if !opts.Apply {
+		if opts.Script != nil {
+			opts.Script.Move(path, newPath)
+		}
+		return nil
+	}
+	if err := wal.begin("move", path, newPath); err != nil {
+		return err
+	}
+	if err := fsys.Rename(path, newPath); err != nil {
+		return &MoveError{Path: path, Destination: newPath, Err: err}
+	}
+	if record := fh.files[path]; record != nil {
+		removeRecord(fh, record)
+	}
+	return wal.commit("move", path)
+}
+
+// deleteCompanion deletes file that belongs to moved duplicate, e.g. its sidecar, its intended deletion is only printed without Apply
+func deleteCompanion(path string, opts MoveOptions, fh *FileHashes) error {
+	f, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%011d Deleting %s\n", f.Size(), path)
+	if !opts.Apply {
+		if opts.Script != nil {
+			opts.Script.Remove(path)
+		}
+		return nil
+	}
+	if err := fsys.Remove(path); err != nil {
+		return err
+	}
+	if record := fh.files[path]; record != nil {
+		removeRecord(fh, record)
+	}
+	return nil
+}
+
+// countMoves returns number and size of files that MoveDuplicates moves with opts, including files that belong to duplicates, along with number of sidecars it deletes
+// Same rules are applied as when moving, so that what is confirmed is what is moved
+func countMoves(opts MoveOptions, dups map[*FileMetadata][]*FileMetadata) (int, int64, int, error) {
+	destination, err := absPath(opts.Destination)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	duplicatePaths := getDuplicatePaths(opts, dups)
+	destinations, err := planDestinations(dups, opts, duplicatePaths, destination)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// Sidecar may be shared by several duplicates, so files are only counted once
+	moves := make(map[string]int64)
+	deletions := make(map[string]bool)
+	for _, list := range dups {
+		for _, p := range list {
+			if !duplicatePaths[p.Path] || isArchiveEntry(p.Path) {
+				continue
+			}
+			if _, err := fsys.Stat(p.Path); os.IsNotExist(err) {
+				continue
 			}
+			newPath := fmt.Sprintf("%s%c%s", filepath.Clean(destination), filepath.Separator, destinations[p])
+			companions, deleted, skip, err := getCompanions(p, newPath, opts, duplicatePaths)
 			if err != nil {
-				return moved, err
+				return 0, 0, 0, err
+			} else if len(skip) > 0 {
+				continue
+			}
+			moves[p.Path] = p.Size
+			for _, companion := range companions {
+				f, err := fsys.Stat(companion.path)
+				if err != nil {
+					return 0, 0, 0, err
+				}
+				moves[companion.path] = f.Size()
+			}
+			for _, path := range deleted {
+				deletions[path] = true
+			}
+		}
+	}
+	size := int64(0)
+	for _, fileSize := range moves {
+		size += fileSize
+	}
+	return len(moves), size, len(deletions), nil
+}
+
+// getDuplicatePaths returns paths of duplicates that are moved with opts
+func getDuplicatePaths(opts MoveOptions, dups map[*FileMetadata][]*FileMetadata) map[string]bool {
+	duplicatePaths := make(map[string]bool)
+	for master, list := range dups {
+		for _, p := range list {
+			if !opts.StrictOnly || getMatchType(master, p) == StrictMatch {
+				duplicatePaths[p.Path] = true
 			}
+		}
+	}
+	return duplicatePaths
+}
+
+// MoveDuplicates moves found duplicates to destination folder with preserving relative path
+func MoveDuplicates(opts MoveOptions, dups map[*FileMetadata][]*FileMetadata, fh *FileHashes) (bool, error) {
+	moveDuplicatesTo, err := absPath(opts.Destination)
+	if err != nil {
+		return false, err
+	}
+	readOnlyPrefix := ""
+	if len(opts.ReadOnlyFolder) > 0 {
+		readOnlyFolder, err := absPath(opts.ReadOnlyFolder)
+		if err != nil {
+			return false, err
+		}
+		readOnlyPrefix = fmt.Sprintf("%s%c", readOnlyFolder, filepath.Separator)
+	}
+	moved := false
+	// Paths of moved duplicates, or ones that would be moved without Apply
+	movedPaths := make(map[string]bool)
+	// Number and size of moved files, or files that would be moved without Apply
+	var movedCount int
+	var movedSize int64
+	var wal *writeAheadLog
+	if opts.Apply {
+		// Moves are recorded before they are performed, so that interrupted ones are reported on next run
+		wal, err = openWAL(fh.dbPath)
+		if err != nil {
+			return false, err
+		}
+		defer wal.close()
+	}
+	// Paths of all duplicates that are moved, so that halves of Live Photos are only moved along with their pairs
+	duplicatePaths := getDuplicatePaths(opts, dups)
+	// Destinations are planned before anything is moved, so that dry run reports same paths that are used with Apply
+	destinations, err := planDestinations(dups, opts, duplicatePaths, moveDuplicatesTo)
+	if err != nil {
+		return false, err
+	}
+	for master, list := range dups {
+		for _, p := range list {
+			if opts.StrictOnly && getMatchType(master, p) != StrictMatch {
+				log.Warningf("Not moving %s, it is only %s of %s\n", p.Path, strings.ToLower(getMatchType(master, p)), master.Path)
+				continue
+			}
+			relPath := destinations[p]
 			relDir := filepath.Dir(relPath)
 			newDir := moveDuplicatesTo
 			if relDir != "." {
@@ -214,30 +806,107 @@ func MoveDuplicates(moveDuplicatesTo string, removePrefix string, dups map[*File
 			log.Debugf("Destination folder: %s\n", newDir)
 			newPath := fmt.Sprintf("%s%c%s", filepath.Clean(moveDuplicatesTo), filepath.Separator, relPath)
 			log.Debugf("Destination path: %s\n", newPath)
+			if len(readOnlyPrefix) > 0 && (strings.HasPrefix(p.Path, readOnlyPrefix) || strings.HasPrefix(newPath, readOnlyPrefix)) {
+				return moved, &MoveError{Path: p.Path, Destination: newPath, Err: ErrReadOnlyFolder}
+			}
+			if opts.Protected.Contains(p.Path) || opts.Protected.Contains(newPath) {
+				return moved, &MoveError{Path: p.Path, Destination: newPath, Err: ErrProtectedPath}
+			}
+			if isArchiveEntry(p.Path) {
+				log.Warningf("Not moving %s, files inside archives are only reported\n", p.Path)
+				continue
+			}
+			companions, deletions, skip, err := getCompanions(p, newPath, opts, duplicatePaths)
+			if err != nil {
+				return moved, err
+			} else if len(skip) > 0 {
+				log.Warningf("Not moving %s, %s\n", p.Path, skip)
+				continue
+			}
 			fmt.Printf("%011d Moving %s to %s\n", p.Size, p.Path, newPath)
-			if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+			if _, err := fsys.Stat(p.Path); os.IsNotExist(err) {
 				// Most likely we already moved this duplicate
 				log.Warningf("File does not exist %s\n", p.Path)
 				continue
 			} else if err != nil {
 				return moved, err
 			}
-			if _, err := os.Stat(newPath); err == nil || !os.IsNotExist(err) {
-				return moved, errors.New("Destination file already exists")
+			if _, err := fsys.Stat(newPath); err == nil || !os.IsNotExist(err) {
+				return moved, &MoveError{Path: p.Path, Destination: newPath, Err: ErrDestinationExists}
+			}
+			// Free space of remote hosts is not known
+			if opts.MinFreeSpace > 0 && !isRemotePath(newDir) {
+				free, err := getExistingFreeSpace(newDir)
+				if err != nil {
+					return moved, err
+				}
+				if !opts.Apply {
+					// Nothing is moved yet, so account for files that would be moved before this one
+					free -= movedSize
+				}
+				if free-p.Size < opts.MinFreeSpace {
+					err := fmt.Errorf("%w, only %s would be left free, moved %d files (%s) before stopping", ErrNotEnoughSpace, formatSize(free-p.Size), movedCount, formatSize(movedSize))
+					return moved, &MoveError{Path: p.Path, Destination: newPath, Err: err}
+				}
 			}
-			if !applyMove {
+			movedPaths[p.Path] = true
+			movedCount++
+			movedSize += p.Size
+			if !opts.Apply {
+				if opts.Script != nil {
+					opts.Script.Mkdir(newDir)
+					opts.Script.Move(p.Path, newPath)
+					if opts.Quarantine {
+						opts.Script.Symlink(master.Path, p.Path)
+					}
+				}
+				if err := moveCompanions(companions, deletions, opts, wal, fh, movedPaths); err != nil {
+					return moved, err
+				}
 				continue
 			}
-			err = os.MkdirAll(newDir, 0777)
+			err = fsys.MkdirAll(newDir, 0777)
 			if err != nil && !os.IsExist(err) {
+				return moved, &MoveError{Path: p.Path, Destination: newPath, Err: err}
+			}
+			op := "move"
+			if opts.Quarantine {
+				op = "quarantine"
+			}
+			if err := wal.begin(op, p.Path, newPath); err != nil {
 				return moved, err
 			}
-			err = os.Rename(p.Path, newPath)
+			err = fsys.Rename(p.Path, newPath)
 			if err != nil {
-				return moved, err
+				// Underlying error is kept, so that e.g. cross-device moves can be told apart with errors.Is
+				return moved, &MoveError{Path: p.Path, Destination: newPath, Err: err}
 			}
 			removeRecord(fh, p)
 			moved = true
+			if opts.Quarantine {
+				// Placeholder is never reported as duplicate, since symlinks are not indexed
+				if err := fsys.Symlink(master.Path, p.Path); err != nil {
+					return moved, &MoveError{Path: p.Path, Destination: newPath, Err: err}
+				}
+			}
+			if err := wal.commit(op, p.Path); err != nil {
+				return moved, err
+			}
+			if err := moveCompanions(companions, deletions, opts, wal, fh, movedPaths); err != nil {
+				return moved, err
+			}
+		}
+	}
+	if opts.ReportEmptyDirs || opts.RemoveEmptyDirs {
+		// Directories above stripped prefix are never touched
+		root := ""
+		if len(opts.RemovePrefix) > 0 {
+			if root, err = absPath(opts.RemovePrefix); err != nil {
+				return moved, err
+			}
+		}
+		if err := reportEmptiedDirs(movedPaths, root, opts.RemoveEmptyDirs && opts.Apply); err != nil {
+			return moved, err
 		}
 	}
 	return moved, nil
@@ -0,0 +1,1184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// makeTestFiles creates files with given contents inside root and returns database with their records
+func makeTestFiles(t *testing.T, root string, files map[string]string) *FileHashes {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	for path, contents := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	return fh
+}
+
+func assertExists(t *testing.T, path string) {
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected %s to exist: %v", path, err)
+	}
+}
+
+func assertNotExists(t *testing.T, path string) {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to not exist: %v", path, err)
+	}
+}
+
+func TestMoveDuplicatesReadOnlyMasters(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.txt":  "same",
+		"masters/b.txt":  "other",
+		"incoming/a.txt": "same",
+		"incoming/b.txt": "other",
+	})
+	masters := filepath.Join(root, "masters")
+	incoming := filepath.Join(root, "incoming")
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: incoming, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: filepath.Join(root, "removed"), RemovePrefix: root, ReadOnlyFolder: masters, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, filepath.Join(masters, "a.txt"))
+	assertExists(t, filepath.Join(masters, "b.txt"))
+	assertNotExists(t, filepath.Join(incoming, "a.txt"))
+	assertNotExists(t, filepath.Join(incoming, "b.txt"))
+	assertExists(t, filepath.Join(root, "removed", "incoming", "a.txt"))
+}
+
+func TestMoveDuplicatesReadOnlyMastersRefusesMasterDuplicates(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.txt":     "same",
+		"masters/sub/a.txt": "same",
+	})
+	masters := filepath.Join(root, "masters")
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: masters, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 {
+		t.Fatalf("Expected 1 duplicate group, got %d", len(dups))
+	}
+	for _, apply := range []bool{false, true} {
+		opts := MoveOptions{Destination: filepath.Join(root, "removed"), ReadOnlyFolder: masters, Apply: apply}
+		if moved, err := MoveDuplicates(opts, dups, fh); !errors.Is(err, ErrReadOnlyFolder) || moved {
+			t.Errorf("Expected move from read-only folder to fail (apply: %v)", apply)
+		}
+	}
+	assertExists(t, filepath.Join(masters, "a.txt"))
+	assertExists(t, filepath.Join(masters, "sub", "a.txt"))
+}
+
+func TestMoveDuplicatesReadOnlyMastersRefusesDestinationInsideMasters(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.txt":  "same",
+		"incoming/a.txt": "same",
+	})
+	masters := filepath.Join(root, "masters")
+	incoming := filepath.Join(root, "incoming")
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: incoming, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: filepath.Join(masters, "removed"), RemovePrefix: root, ReadOnlyFolder: masters, Apply: true}
+	var moveErr *MoveError
+	if _, err := MoveDuplicates(opts, dups, fh); !errors.As(err, &moveErr) || !errors.Is(err, ErrReadOnlyFolder) {
+		t.Errorf("Expected move into read-only folder to fail, got %v", err)
+	} else if moveErr.Path != filepath.Join(incoming, "a.txt") {
+		t.Errorf("Expected error for %s, got %s", filepath.Join(incoming, "a.txt"), moveErr.Path)
+	}
+	assertExists(t, filepath.Join(incoming, "a.txt"))
+	assertNotExists(t, filepath.Join(masters, "removed"))
+}
+
+func TestPickMasterAgePolicy(t *testing.T) {
+	older := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		name   string
+		first  FileMetadata
+		second FileMetadata
+		oldest string
+		newest string
+	}{
+		{"shot date", FileMetadata{Path: "/a", DateShot: older, Modified: newer}, FileMetadata{Path: "/b", DateShot: newer, Modified: older}, "/a", "/b"},
+		{"known shot date", FileMetadata{Path: "/a", Modified: older}, FileMetadata{Path: "/b", DateShot: newer, Modified: newer}, "/b", "/b"},
+		{"modified", FileMetadata{Path: "/a", Modified: newer, Created: older}, FileMetadata{Path: "/b", Modified: older, Created: newer}, "/b", "/a"},
+		{"created", FileMetadata{Path: "/a", Modified: older, Created: older}, FileMetadata{Path: "/b", Modified: older, Created: newer}, "/a", "/b"},
+		{"size", FileMetadata{Path: "/a", Size: 2, DateShot: newer}, FileMetadata{Path: "/b", Size: 1, DateShot: older}, "/a", "/a"},
+	}
+	for _, test := range tests {
+		first, second := test.first, test.second
+		candidates := map[*FileMetadata]bool{&first: true, &second: true}
+		if master, _ := pickMaster(candidates, "", "", MasterPolicy{}); master.Path != test.oldest {
+			t.Errorf("%s: expected oldest master %s, got %s", test.name, test.oldest, master.Path)
+		}
+		if master, _ := pickMaster(candidates, "", "", MasterPolicy{PreferNewest: true}); master.Path != test.newest {
+			t.Errorf("%s: expected newest master %s, got %s", test.name, test.newest, master.Path)
+		}
+	}
+}
+
+func TestPickMasterNewestModifiedPolicy(t *testing.T) {
+	older := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	modifiedFirst, err := ParseMasterOrder("modified")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		first    FileMetadata
+		second   FileMetadata
+		policy   MasterPolicy
+		expected string
+	}{
+		{"newest modified", FileMetadata{Path: "/a", Modified: older, Created: newer}, FileMetadata{Path: "/b", Modified: newer, Created: older}, MasterPolicy{PreferNewestModified: true}, "/b"},
+		{"oldest created", FileMetadata{Path: "/a", Modified: newer, Created: newer}, FileMetadata{Path: "/b", Modified: newer, Created: older}, MasterPolicy{PreferNewestModified: true}, "/b"},
+		{"ignores master age", FileMetadata{Path: "/a", Modified: older}, FileMetadata{Path: "/b", Modified: newer}, MasterPolicy{PreferNewestModified: true, PreferNewest: true}, "/b"},
+		{"shot date first", FileMetadata{Path: "/a", DateShot: older, Modified: older}, FileMetadata{Path: "/b", DateShot: newer, Modified: newer}, MasterPolicy{PreferNewestModified: true}, "/a"},
+		{"modified before shot date", FileMetadata{Path: "/a", DateShot: older, Modified: older}, FileMetadata{Path: "/b", DateShot: newer, Modified: newer}, MasterPolicy{PreferNewestModified: true, Order: modifiedFirst}, "/b"},
+	}
+	for _, test := range tests {
+		first, second := test.first, test.second
+		candidates := map[*FileMetadata]bool{&first: true, &second: true}
+		if master, _ := pickMaster(candidates, "", "", test.policy); master.Path != test.expected {
+			t.Errorf("%s: expected master %s, got %s", test.name, test.expected, master.Path)
+		}
+	}
+}
+
+func TestPickMasterTimeTolerance(t *testing.T) {
+	shot := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	older := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		expected  string
+	}{
+		// Shooting dates differ by 2 seconds, so file shot earlier is master without tolerance
+		{"no tolerance", 0, "/a"},
+		{"drift within tolerance", 2 * time.Second, "/b"},
+		{"drift over tolerance", time.Second, "/a"},
+	}
+	for _, test := range tests {
+		a := FileMetadata{Path: "/a", DateShot: shot, Modified: older.Add(time.Hour)}
+		b := FileMetadata{Path: "/b", DateShot: shot.Add(2 * time.Second), Modified: older}
+		candidates := map[*FileMetadata]bool{&a: true, &b: true}
+		master, reasons := pickMaster(candidates, "", "", MasterPolicy{TimeTolerance: test.tolerance})
+		if master.Path != test.expected {
+			t.Errorf("%s: expected master %s, got %s", test.name, test.expected, master.Path)
+		}
+		if test.expected == "/b" && reasons[&a] != "was modified earlier" {
+			t.Errorf("%s: expected modification time to decide, got %q", test.name, reasons[&a])
+		}
+	}
+}
+
+func TestPickMasterTimeToleranceChain(t *testing.T) {
+	shot := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	modified := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	// Neighbours are shot within tolerance, so they are compared by modification time, while /a and /c are not
+	a := FileMetadata{Path: "/a", DateShot: shot, Modified: modified.Add(2 * time.Hour)}
+	b := FileMetadata{Path: "/b", DateShot: shot.Add(2 * time.Second), Modified: modified.Add(time.Hour)}
+	c := FileMetadata{Path: "/c", DateShot: shot.Add(4 * time.Second), Modified: modified}
+	for i := 0; i < 20; i++ {
+		candidates := map[*FileMetadata]bool{&a: true, &b: true, &c: true}
+		if master, _ := pickMaster(candidates, "", "", MasterPolicy{TimeTolerance: 2 * time.Second}); master.Path != "/c" {
+			t.Fatalf("Expected candidates to be compared in path order and pick /c, got %s", master.Path)
+		}
+	}
+}
+
+func TestPickMasterReasons(t *testing.T) {
+	older := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		master FileMetadata
+		other  FileMetadata
+		reason string
+	}{
+		{FileMetadata{Path: "/archive/a", Size: 1}, FileMetadata{Path: "/masters/a", Size: 2}, "is protected"},
+		{FileMetadata{Path: "/masters/a", Size: 1}, FileMetadata{Path: "/b", Size: 2}, "is in masters folder"},
+		{FileMetadata{Path: "/b", Size: 1}, FileMetadata{Path: "/dups/a", Size: 2}, "is outside of duplicates folder"},
+		{FileMetadata{Path: "/b", Size: 1}, FileMetadata{Path: "/a.zip!/a", Size: 2}, "is outside of archive"},
+		{FileMetadata{Path: "/b", Size: 2}, FileMetadata{Path: "/a", Size: 1}, "is larger"},
+		{FileMetadata{Path: "/b", DateShot: newer}, FileMetadata{Path: "/a", Modified: older}, "has shooting date"},
+		{FileMetadata{Path: "/b", DateShot: older}, FileMetadata{Path: "/a", DateShot: newer}, "was shot earlier"},
+		{FileMetadata{Path: "/b", Modified: older}, FileMetadata{Path: "/a", Modified: newer}, "was modified earlier"},
+		{FileMetadata{Path: "/b", Created: older}, FileMetadata{Path: "/a", Created: newer}, "was created earlier"},
+		{FileMetadata{Path: "/b"}, FileMetadata{Path: "/a"}, "no rule tells files apart"},
+	}
+	policy := MasterPolicy{Protected: ProtectedPaths{"/archive"}}
+	for _, test := range tests {
+		master, other := test.master, test.other
+		candidates := map[*FileMetadata]bool{&master: true, &other: true}
+		selected, reasons := pickMaster(candidates, "/dups/", "/masters/", policy)
+		if test.reason != "no rule tells files apart" && selected != &master {
+			t.Errorf("%s: expected master %s, got %s", test.reason, master.Path, selected.Path)
+		}
+		if selected == &master && reasons[&other] != test.reason {
+			t.Errorf("%s: expected reason %q, got %q", test.reason, test.reason, reasons[&other])
+		}
+	}
+	newest := FileMetadata{Path: "/b", Modified: newer}
+	oldest := FileMetadata{Path: "/a", Modified: older}
+	master, reasons := pickMaster(map[*FileMetadata]bool{&newest: true, &oldest: true}, "", "", MasterPolicy{PreferNewest: true})
+	if master != &newest || reasons[&oldest] != "was modified later" {
+		t.Errorf("Expected newest master modified later, got %s (%s)", master.Path, reasons[&oldest])
+	}
+}
+
+func TestFindDuplicatesReasons(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/a.jpg", "same", modified},
+		{"/lib/incoming/a.jpg", "same", modified},
+		{"/lib/incoming/b.jpg", "same", modified.Add(time.Hour)},
+	})
+	reasons := make(map[*FileMetadata]string)
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters", Reasons: reasons}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := getDuplicateGroups(dups, reasons)
+	if len(groups) != 1 || len(groups[0].Duplicates) != 2 {
+		t.Fatalf("Expected one group with 2 duplicates, got %v", groups)
+	}
+	for i, dup := range groups[0].Duplicates {
+		if groups[0].Reasons[i] != "is in masters folder" {
+			t.Errorf("Expected %s to lose to master in masters folder, got %q", dup.Path, groups[0].Reasons[i])
+		}
+	}
+}
+
+func TestPickMasterSizePolicy(t *testing.T) {
+	older := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	larger := FileMetadata{Path: "/larger", Size: 2, DateShot: older.Add(time.Hour)}
+	smaller := FileMetadata{Path: "/smaller", Size: 1, DateShot: older}
+	candidates := map[*FileMetadata]bool{&larger: true, &smaller: true}
+	shotFirst, err := ParseMasterOrder("shot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		policy   MasterPolicy
+		expected string
+	}{
+		{"default", MasterPolicy{}, "/larger"},
+		{"prefer smaller", MasterPolicy{PreferSmaller: true}, "/smaller"},
+		{"shot before size", MasterPolicy{Order: shotFirst}, "/smaller"},
+		{"newest shot before size", MasterPolicy{Order: shotFirst, PreferNewest: true}, "/larger"},
+	}
+	for _, test := range tests {
+		if master, _ := pickMaster(candidates, "", "", test.policy); master.Path != test.expected {
+			t.Errorf("%s: expected master %s, got %s", test.name, test.expected, master.Path)
+		}
+	}
+	if _, err := ParseMasterOrder("size,unknown"); err == nil {
+		t.Error("Expected unknown master rule to fail")
+	}
+}
+
+func TestPickMasterMissingShotDate(t *testing.T) {
+	older := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	undated := FileMetadata{Path: "/undated", Size: 1, Modified: older}
+	early := FileMetadata{Path: "/early", Size: 1, DateShot: older, Modified: older.Add(time.Hour)}
+	late := FileMetadata{Path: "/late", Size: 1, DateShot: older.Add(time.Hour), Modified: older.Add(time.Hour)}
+	tests := []struct {
+		name       string
+		candidates []*FileMetadata
+		policy     MasterPolicy
+		expected   string
+	}{
+		{"earliest shot", []*FileMetadata{&undated, &early, &late}, MasterPolicy{}, "/early"},
+		{"newest shot", []*FileMetadata{&undated, &early, &late}, MasterPolicy{PreferNewest: true}, "/late"},
+		{"no shot date", []*FileMetadata{&undated, &FileMetadata{Path: "/other", Size: 1, Modified: older.Add(time.Hour)}}, MasterPolicy{}, "/undated"},
+	}
+	for _, test := range tests {
+		candidates := make(map[*FileMetadata]bool)
+		for _, candidate := range test.candidates {
+			candidates[candidate] = true
+		}
+		// Map order is random, so repeat to try different orders of comparison
+		for i := 0; i < 10; i++ {
+			if master, _ := pickMaster(candidates, "", "", test.policy); master.Path != test.expected {
+				t.Errorf("%s: expected master %s, got %s", test.name, test.expected, master.Path)
+				break
+			}
+		}
+	}
+}
+
+func TestFindDuplicatesPerDirectory(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"a/1.txt": "same",
+		"a/2.txt": "same",
+		"b/1.txt": "same",
+		"b/2.txt": "other",
+	})
+	dups, err := FindDuplicates(SearchOptions{PerDirectory: true}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 {
+		t.Fatalf("Expected 1 duplicate group, got %d", len(dups))
+	}
+	for master, list := range dups {
+		if filepath.Dir(master.Path) != filepath.Join(root, "a") {
+			t.Errorf("Expected master in %s, got %s", filepath.Join(root, "a"), master.Path)
+		}
+		if len(list) != 1 || filepath.Dir(list[0].Path) != filepath.Join(root, "a") {
+			t.Errorf("Expected one duplicate in same directory as %s, got %v", master.Path, list)
+		}
+	}
+}
+
+func TestMoveDuplicatesRemovesEmptiedDirs(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.txt":                 "a",
+		"masters/b.txt":                 "b",
+		"masters/c.txt":                 "c",
+		"incoming/2019/trip/a.txt":      "a",
+		"incoming/2019/trip/day2/b.txt": "b",
+		"incoming/2020/c.txt":           "c",
+		"incoming/2020/unique.txt":      "unique",
+	})
+	masters := filepath.Join(root, "masters")
+	incoming := filepath.Join(root, "incoming")
+	// Directories that were empty before moving must be kept
+	for _, dir := range []string{"incoming/old", "incoming/2019/trip/day2/empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: incoming, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := make(map[string]bool)
+	for _, list := range dups {
+		for _, dup := range list {
+			moved[dup.Path] = true
+		}
+	}
+	// Without applying moves emptied directories are predicted
+	dirs, err := findEmptiedDirs(moved, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 0 {
+		t.Errorf("Expected directory with pre-existing empty folder to be kept, got %v", dirs)
+	}
+	if err := os.Remove(filepath.Join(root, "incoming/2019/trip/day2/empty")); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err = findEmptiedDirs(moved, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(incoming, "2019", "trip", "day2"), filepath.Join(incoming, "2019", "trip"), filepath.Join(incoming, "2019")}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected emptied directories %v, got %v", expected, dirs)
+	}
+	opts := MoveOptions{Destination: filepath.Join(root, "removed"), RemovePrefix: root, RemoveEmptyDirs: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, filepath.Join(incoming, "2019"))
+	assertExists(t, filepath.Join(incoming, "2020", "unique.txt"))
+	assertExists(t, filepath.Join(incoming, "old"))
+	assertExists(t, filepath.Join(root, "removed", "incoming", "2019", "trip", "day2", "b.txt"))
+}
+
+func TestMoveDuplicatesStrictOnly(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	// Data appended after end of image marker changes file hash, but not decoded pixels
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.jpg":       jpg.String(),
+		"incoming/copy.jpg":   jpg.String(),
+		"incoming/tagged.jpg": jpg.String() + "<x:xmpmeta/>",
+	})
+	masters := filepath.Join(root, "masters")
+	incoming := filepath.Join(root, "incoming")
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: incoming, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := fh.files[filepath.Join(masters, "a.jpg")]
+	if len(dups[master]) != 2 {
+		t.Fatalf("Expected strict and pixel match, got %v", dups)
+	}
+	if matchType := getMatchType(master, fh.files[filepath.Join(incoming, "tagged.jpg")]); matchType != PixelMatch {
+		t.Errorf("Expected %s, got %s", PixelMatch, matchType)
+	}
+	opts := MoveOptions{Destination: filepath.Join(root, "removed"), RemovePrefix: root, StrictOnly: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, filepath.Join(incoming, "copy.jpg"))
+	assertExists(t, filepath.Join(incoming, "tagged.jpg"))
+}
+
+// makeBenchmarkDatabase creates database with records where every tenth file has a copy and two of every hundred files are image matches
+func makeBenchmarkDatabase() *FileHashes {
+	fh := &FileHashes{files: make(map[string]*FileMetadata), hashes: make(map[string][]*FileMetadata)}
+	for i := 0; i < benchmarkRecords; i++ {
+		record := &FileMetadata{Path: fmt.Sprintf("/photos/%03d/IMG_%06d.jpg", i%1000, i), Size: int64(1000000 + i), FileHash: fmt.Sprintf("%040x", i), Modified: time.Unix(int64(1500000000+i), 0)}
+		if i%10 == 1 {
+			record.FileHash = fmt.Sprintf("%040x", i-1)
+		}
+		if i%100 == 2 || i%100 == 3 {
+			record.ImageHash = fmt.Sprintf("image%034x", i/100)
+		}
+		addRecord(fh, record)
+	}
+	return fh
+}
+
+func benchmarkFindDuplicates(b *testing.B, concurrency int) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	fh := makeBenchmarkDatabase()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		stats := DuplicateStats{}
+		if _, err := FindDuplicates(SearchOptions{Listing: ListingFormats["none"], Stats: &stats, Concurrency: concurrency}, fh); err != nil {
+			b.Fatal(err)
+		}
+		if expected := benchmarkRecords/10 + benchmarkRecords/100; stats.Groups != expected {
+			b.Fatalf("Expected %d groups, got %d", expected, stats.Groups)
+		}
+	}
+}
+
+func BenchmarkFindDuplicates1(b *testing.B) {
+	benchmarkFindDuplicates(b, 1)
+}
+
+func BenchmarkFindDuplicates4(b *testing.B) {
+	benchmarkFindDuplicates(b, 4)
+}
+
+func TestFindDuplicatesConcurrency(t *testing.T) {
+	fh := makeBenchmarkDatabase()
+	for _, concurrency := range []int{1, 4} {
+		stats := DuplicateStats{}
+		dups, err := FindDuplicates(SearchOptions{Listing: ListingFormats["none"], Stats: &stats, Concurrency: concurrency}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every tenth file has a copy and every hundred files have a pair of image matches
+		if stats.Groups != benchmarkRecords/10+benchmarkRecords/100 || stats.Duplicates != stats.Groups || len(dups) != stats.Groups {
+			t.Errorf("Unexpected stats with concurrency %d: %+v", concurrency, stats)
+		}
+	}
+}
+
+func TestFindDuplicatesIgnoresEmptyFiles(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"a/empty.txt":  "",
+		"b/empty.txt":  "",
+		"c/empty.log":  "",
+		"a/photo.jpg":  "photo",
+		"b/photo.jpg":  "photo",
+		"c/unique.txt": "unique",
+	})
+	dups, err := FindDuplicates(SearchOptions{}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 {
+		t.Fatalf("Expected only non-empty files to be duplicates, got %v", dups)
+	}
+	for master, list := range dups {
+		for _, record := range append(list, master) {
+			if record.Size == 0 {
+				t.Errorf("Expected empty %s to not be reported", record.Path)
+			}
+		}
+	}
+	emptyHash := fh.files[filepath.Join(root, "a", "empty.txt")].FileHash
+	removeRecord(fh, fh.files[filepath.Join(root, "c", "empty.log")])
+	if _, ok := fh.hashes[emptyHash]; ok {
+		t.Error("Expected empty files to not be indexed")
+	}
+	fh.options.MatchEmpty = true
+	CheckIndex(fh)
+	dups, err = FindDuplicates(SearchOptions{}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 2 {
+		t.Errorf("Expected empty files to be reported with MatchEmpty, got %v", dups)
+	}
+	fh.options.MatchEmpty = false
+	fh.options.IgnoreEmpty = true
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(fh.files) != 3 {
+		t.Errorf("Expected empty files to not be recorded, got %d files", len(fh.files))
+	}
+}
+
+func TestMoveDuplicatesQuarantine(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{
+		"masters/a.txt":  "same",
+		"incoming/a.txt": "same",
+	})
+	masters := filepath.Join(root, "masters")
+	incoming := filepath.Join(root, "incoming")
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: incoming, MastersFolder: masters}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: filepath.Join(root, "quarantine"), RemovePrefix: root, Quarantine: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, filepath.Join(root, "quarantine", "incoming", "a.txt"))
+	if target, err := os.Readlink(filepath.Join(incoming, "a.txt")); err != nil || target != filepath.Join(masters, "a.txt") {
+		t.Errorf("Expected symlink to master, got %s: %v", target, err)
+	}
+	// Placeholder is not recorded when scanned again
+	if err := ScanFolders([]string{incoming}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if record := fh.files[filepath.Join(incoming, "a.txt")]; record != nil {
+		t.Errorf("Expected symlink to not be recorded, got %+v", record)
+	}
+}
+
+func TestGetLargestDuplicates(t *testing.T) {
+	master := &FileMetadata{Path: "/m"}
+	small, large, medium := &FileMetadata{Path: "/s", Size: 1}, &FileMetadata{Path: "/l", Size: 30}, &FileMetadata{Path: "/md", Size: 20}
+	other := &FileMetadata{Path: "/o"}
+	dups := map[*FileMetadata][]*FileMetadata{master: {small, large}, other: {medium}}
+	largest := getLargestDuplicates(dups, 2)
+	if len(largest) != 2 || largest[0].dup != large || largest[0].master != master || largest[1].dup != medium || largest[1].master != other {
+		t.Errorf("Expected two largest duplicates with their masters, got %v", largest)
+	}
+	if all := getLargestDuplicates(dups, 0); len(all) != 3 || all[2].dup != small {
+		t.Errorf("Expected all duplicates without limit, got %v", all)
+	}
+}
+
+func TestFindDuplicatesMasterSelection(t *testing.T) {
+	older := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		name       string
+		files      []memTestFile
+		opts       SearchOptions
+		master     string
+		duplicates []string
+	}{
+		{"earlier modification", []memTestFile{{"/lib/a.txt", "same", newer}, {"/lib/b.txt", "same", older}}, SearchOptions{}, "/lib/b.txt", []string{"/lib/a.txt"}},
+		{"newest policy", []memTestFile{{"/lib/a.txt", "same", newer}, {"/lib/b.txt", "same", older}}, SearchOptions{Policy: MasterPolicy{PreferNewest: true}}, "/lib/a.txt", []string{"/lib/b.txt"}},
+		{"masters folder", []memTestFile{{"/lib/masters/a.txt", "same", newer}, {"/lib/incoming/a.txt", "same", older}}, SearchOptions{MastersFolder: "/lib/masters"}, "/lib/masters/a.txt", []string{"/lib/incoming/a.txt"}},
+		{"outside duplicates folder", []memTestFile{{"/lib/keep/a.txt", "same", newer}, {"/lib/incoming/a.txt", "same", older}}, SearchOptions{DuplicatesFolder: "/lib/incoming"}, "/lib/keep/a.txt", []string{"/lib/incoming/a.txt"}},
+		{"only inside duplicates folder", []memTestFile{{"/lib/keep/a.txt", "same", older}, {"/lib/keep/b.txt", "same", newer}, {"/lib/incoming/a.txt", "same", newer}}, SearchOptions{DuplicatesFolder: "/lib/incoming"}, "/lib/keep/a.txt", []string{"/lib/incoming/a.txt"}},
+		{"different contents", []memTestFile{{"/lib/a.txt", "same", older}, {"/lib/b.txt", "other", older}}, SearchOptions{}, "", nil},
+	}
+	for _, test := range tests {
+		_, fh := makeMemTestFiles(t, test.files)
+		dups, err := FindDuplicates(test.opts, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.master) == 0 {
+			if len(dups) > 0 {
+				t.Errorf("%s: expected no duplicates, got %v", test.name, dups)
+			}
+			continue
+		}
+		if len(dups) != 1 {
+			t.Errorf("%s: expected 1 duplicate group, got %d", test.name, len(dups))
+			continue
+		}
+		for master, list := range dups {
+			var paths []string
+			for _, dup := range list {
+				paths = append(paths, filepath.ToSlash(dup.Path))
+			}
+			if filepath.ToSlash(master.Path) != test.master || !reflect.DeepEqual(paths, test.duplicates) {
+				t.Errorf("%s: expected %s with duplicates %v, got %s with %v", test.name, test.master, test.duplicates, master.Path, paths)
+			}
+		}
+	}
+}
+
+func TestGetRelativeDestination(t *testing.T) {
+	shot := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		path     string
+		opts     MoveOptions
+		expected string
+		err      error
+	}{
+		{"volume stripped", "/lib/stuff/a.txt", MoveOptions{}, "lib/stuff/a.txt", nil},
+		{"prefix stripped", "/lib/stuff/a.txt", MoveOptions{RemovePrefix: "/lib"}, "stuff/a.txt", nil},
+		{"prefix with separator", "/lib/stuff/a.txt", MoveOptions{RemovePrefix: "/lib/"}, "stuff/a.txt", nil},
+		{"renamed by date", "/lib/stuff/a.txt", MoveOptions{RemovePrefix: "/lib", RenameByDate: true}, "stuff/2020-07-04_12-30-00.txt", nil},
+		{"outside prefix", "/library/a.txt", MoveOptions{RemovePrefix: "/lib"}, "", ErrOutsidePrefix},
+		{"other folder", "/other/a.txt", MoveOptions{RemovePrefix: "/lib/stuff"}, "", ErrOutsidePrefix},
+	}
+	for _, test := range tests {
+		record := &FileMetadata{Path: filepath.FromSlash(test.path), DateShot: shot}
+		test.opts.RemovePrefix = filepath.FromSlash(test.opts.RemovePrefix)
+		relPath, err := getRelativeDestination(record, test.opts)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %s, %v", test.name, test.err, relPath, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if filepath.ToSlash(relPath) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, relPath)
+		}
+	}
+}
+
+func TestMoveDuplicatesInMemory(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name        string
+		existing    string
+		volumes     []string
+		opts        MoveOptions
+		destination string
+		err         error
+	}{
+		{"moved", "", nil, MoveOptions{Destination: "/removed", RemovePrefix: "/lib"}, "/removed/incoming/a.txt", nil},
+		{"volume kept", "", nil, MoveOptions{Destination: "/removed"}, "/removed/lib/incoming/a.txt", nil},
+		{"destination exists", "/removed/incoming/a.txt", nil, MoveOptions{Destination: "/removed", RemovePrefix: "/lib"}, "", ErrDestinationExists},
+		{"outside prefix", "", nil, MoveOptions{Destination: "/removed", RemovePrefix: "/other"}, "", ErrOutsidePrefix},
+		{"cross volume", "", []string{"/removed"}, MoveOptions{Destination: "/removed", RemovePrefix: "/lib"}, "", syscall.EXDEV},
+	}
+	for _, test := range tests {
+		files := []memTestFile{{"/lib/masters/a.txt", "same", modified}, {"/lib/incoming/a.txt", "same", modified}}
+		if len(test.existing) > 0 {
+			files = append(files, memTestFile{test.existing, "different", modified})
+		}
+		mem, fh := makeMemTestFiles(t, files)
+		mem.volumes = test.volumes
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.opts.Apply = true
+		moved, err := MoveDuplicates(test.opts, dups, fh)
+		if test.err != nil {
+			if !errors.Is(err, test.err) || moved {
+				t.Errorf("%s: expected %v without moving, got %v", test.name, test.err, err)
+			}
+			if _, err := mem.Stat("/lib/incoming/a.txt"); err != nil {
+				t.Errorf("%s: expected duplicate to be kept: %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil || !moved {
+			t.Errorf("%s: expected duplicate to be moved, got %v", test.name, err)
+			continue
+		}
+		if _, err := mem.Stat(test.destination); err != nil {
+			t.Errorf("%s: expected duplicate at %s: %v", test.name, test.destination, err)
+		}
+		if _, err := mem.Stat("/lib/incoming/a.txt"); !os.IsNotExist(err) {
+			t.Errorf("%s: expected duplicate to be moved away: %v", test.name, err)
+		}
+		if _, err := mem.Stat("/lib/masters/a.txt"); err != nil || fh.files[filepath.FromSlash("/lib/incoming/a.txt")] != nil {
+			t.Errorf("%s: expected master to be kept and duplicate record removed: %v", test.name, err)
+		}
+	}
+}
+
+func TestFindDuplicatesFileHashOnly(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/a.jpg", jpg.String(), modified},
+		{"/lib/incoming/copy.jpg", jpg.String(), modified},
+		{"/lib/incoming/tagged.jpg", jpg.String() + "<x:xmpmeta/>", modified},
+	})
+	for _, fileHashOnly := range []bool{false, true} {
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters", DuplicatesFolder: "/lib/incoming", FileHashOnly: fileHashOnly}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 2
+		if fileHashOnly {
+			expected = 1
+		}
+		master := fh.files[filepath.FromSlash("/lib/masters/a.jpg")]
+		if len(dups) != 1 || len(dups[master]) != expected {
+			t.Errorf("Expected %d duplicates of %s with file hash only %v, got %v", expected, master.Path, fileHashOnly, dups)
+		}
+	}
+}
+
+func TestFindDuplicatesSameExtension(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/a.jpg", "same", modified},
+		{"/lib/incoming/copy.JPG", "same", modified},
+		{"/lib/incoming/copy.png", "same", modified},
+	})
+	for _, sameExtension := range []bool{false, true} {
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters", DuplicatesFolder: "/lib/incoming", SameExtension: sameExtension}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 2
+		if sameExtension {
+			expected = 1
+		}
+		master := fh.files[filepath.FromSlash("/lib/masters/a.jpg")]
+		if len(dups) != 1 || len(dups[master]) != expected {
+			t.Errorf("Expected %d duplicates of %s with same extension only %v, got %v", expected, master.Path, sameExtension, dups)
+		}
+		if sameExtension && len(dups[master]) == 1 && filepath.Ext(dups[master][0].Path) != ".JPG" {
+			t.Errorf("Expected duplicate with same extension, got %s", dups[master][0].Path)
+		}
+	}
+}
+
+func TestMoveDuplicatesLivePhotos(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	pairing, err := ParseLivePhotoExtensions(DefaultLivePhotoExtensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		policy    string
+		moved     []string
+		kept      []string
+		duplicate bool
+	}{
+		{LivePhotosOff, []string{"/removed/incoming/IMG_0001.HEIC"}, []string{"/lib/incoming/IMG_0001.MOV"}, false},
+		{LivePhotosKeep, nil, []string{"/lib/incoming/IMG_0001.HEIC", "/lib/incoming/IMG_0001.MOV"}, false},
+		{LivePhotosMove, []string{"/removed/incoming/IMG_0001.HEIC", "/removed/incoming/IMG_0001.MOV"}, nil, false},
+		// Pair that is duplicate as well is moved on its own
+		{LivePhotosKeep, []string{"/removed/incoming/IMG_0001.HEIC", "/removed/incoming/IMG_0001.MOV"}, nil, true},
+	}
+	for _, test := range tests {
+		files := []memTestFile{
+			{"/lib/masters/IMG_0001.HEIC", "image", modified},
+			{"/lib/incoming/IMG_0001.HEIC", "image", modified},
+			{"/lib/incoming/IMG_0001.MOV", "video", modified},
+		}
+		if test.duplicate {
+			files = append(files, memTestFile{"/lib/masters/IMG_0001.MOV", "video", modified})
+		}
+		mem, fh := makeMemTestFiles(t, files)
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, LivePhotos: test.policy, LivePhotoPairing: pairing}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range test.moved {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("%s: expected %s to be moved: %v", test.policy, path, err)
+			}
+		}
+		for _, path := range test.kept {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("%s: expected %s to be kept: %v", test.policy, path, err)
+			}
+		}
+	}
+}
+
+func TestMoveDuplicatesLivePhotoDestinationExists(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	pairing, err := ParseLivePhotoExtensions(DefaultLivePhotoExtensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/IMG_0001.HEIC", "image", modified},
+		{"/lib/incoming/IMG_0001.HEIC", "image", modified},
+		{"/lib/incoming/IMG_0001.MOV", "video", modified},
+		// Left by earlier move, so pair can not be moved next to image
+		{"/removed/incoming/IMG_0001.MOV", "other video", modified},
+	})
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, LivePhotos: LivePhotosMove, LivePhotoPairing: pairing}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/lib/incoming/IMG_0001.HEIC", "/lib/incoming/IMG_0001.MOV"} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+	if _, err := mem.Stat("/removed/incoming/IMG_0001.HEIC"); err == nil {
+		t.Error("Expected image not to be moved without its pair")
+	}
+}
+
+func TestCountMoves(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	pairing, err := ParseLivePhotoExtensions(DefaultLivePhotoExtensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		livePhotos string
+		count      int
+	}{
+		// Duplicate image with its Live Photo video, duplicate text with its sidecar
+		{LivePhotosMove, 4},
+		// Duplicate image is skipped, since its pair is not a duplicate
+		{LivePhotosKeep, 2},
+	}
+	for _, test := range tests {
+		mem, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/masters/IMG_0001.HEIC", "image", modified},
+			{"/lib/incoming/IMG_0001.HEIC", "image", modified},
+			{"/lib/incoming/IMG_0001.MOV", "video", modified},
+			{"/lib/masters/a.txt", "same", modified},
+			{"/lib/incoming/a.txt", "same", modified},
+			{"/lib/incoming/a.txt.xmp", "<x:xmpmeta/>", modified},
+		})
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, LivePhotos: test.livePhotos, LivePhotoPairing: pairing, Sidecars: SidecarsMove, SidecarExtensions: splitExtensions(DefaultSidecarExtensions)}
+		count, size, _, err := countMoves(opts, dups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Fatal(err)
+		}
+		movedCount, movedSize := 0, int64(0)
+		for path, f := range mem.files {
+			if strings.HasPrefix(path, "/removed/") && !f.dir {
+				movedCount++
+				movedSize += f.Size()
+			}
+		}
+		if count != test.count || count != movedCount || size != movedSize {
+			t.Errorf("%s: expected %d files to be counted, counted %d files of %d bytes, moved %d files of %d bytes", test.livePhotos, test.count, count, size, movedCount, movedSize)
+		}
+	}
+}
+
+func TestMoveDuplicatesRenameByDateCollision(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	shot := time.Date(2019, 5, 1, 10, 0, 0, 0, time.Local)
+	for _, apply := range []bool{false, true} {
+		mem, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/masters/a.jpg", "same", modified},
+			{"/lib/incoming/a.jpg", "same", modified},
+			{"/lib/incoming/b.jpg", "same", modified},
+		})
+		// Burst shots may share shooting date down to a second
+		for _, path := range []string{"/lib/incoming/a.jpg", "/lib/incoming/b.jpg"} {
+			fh.files[path].DateShot = shot
+		}
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", RenameByDate: true, Apply: apply}
+		destinations, err := planDestinations(dups, opts, getDuplicatePaths(opts, dups), "/removed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		planned := make(map[string]string)
+		for record, relPath := range destinations {
+			planned[record.Path] = relPath
+		}
+		expected := map[string]string{"/lib/incoming/a.jpg": "incoming/2019-05-01_10-00-00.jpg", "/lib/incoming/b.jpg": "incoming/2019-05-01_10-00-00_1.jpg"}
+		if !reflect.DeepEqual(planned, expected) {
+			t.Errorf("%t: expected destinations %v, got %v", apply, expected, planned)
+		}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Fatalf("%t: expected colliding names to be moved, got %v", apply, err)
+		}
+		if !apply {
+			continue
+		}
+		for _, path := range []string{"/removed/incoming/2019-05-01_10-00-00.jpg", "/removed/incoming/2019-05-01_10-00-00_1.jpg"} {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("Expected %s to exist: %v", path, err)
+			}
+		}
+	}
+}
+
+func TestMoveDuplicatesSidecars(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	extensions := splitExtensions(DefaultSidecarExtensions)
+	tests := []struct {
+		policy    string
+		exists    []string
+		missing   []string
+		deletions int
+	}{
+		{SidecarsMove, []string{"/removed/incoming/IMG_0001.JPG", "/removed/incoming/IMG_0001.xmp", "/removed/incoming/IMG_0001.JPG.AAE"}, []string{"/lib/incoming/IMG_0001.xmp", "/lib/incoming/IMG_0001.JPG.AAE"}, 0},
+		{SidecarsLeave, []string{"/removed/incoming/IMG_0001.JPG", "/lib/incoming/IMG_0001.xmp", "/lib/incoming/IMG_0001.JPG.AAE"}, []string{"/removed/incoming/IMG_0001.xmp"}, 0},
+		{SidecarsDelete, []string{"/removed/incoming/IMG_0001.JPG"}, []string{"/lib/incoming/IMG_0001.xmp", "/lib/incoming/IMG_0001.JPG.AAE", "/removed/incoming/IMG_0001.xmp"}, 2},
+	}
+	for _, test := range tests {
+		mem, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/masters/IMG_0001.JPG", "image", modified},
+			{"/lib/incoming/IMG_0001.JPG", "image", modified},
+			{"/lib/incoming/IMG_0001.xmp", "<x:xmpmeta/>", modified},
+			{"/lib/incoming/IMG_0001.JPG.AAE", "<plist/>", modified},
+		})
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, Sidecars: test.policy, SidecarExtensions: extensions}
+		if _, _, deletions, err := countMoves(opts, dups); err != nil || deletions != test.deletions {
+			t.Errorf("%s: expected %d sidecars to be confirmed for deletion, got %d: %v", test.policy, test.deletions, deletions, err)
+		}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range test.exists {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("%s: expected %s to exist: %v", test.policy, path, err)
+			}
+		}
+		for _, path := range test.missing {
+			if _, err := mem.Stat(path); err == nil {
+				t.Errorf("%s: expected %s to be missing", test.policy, path)
+			}
+			if fh.files[path] != nil {
+				t.Errorf("%s: expected %s to be removed from database", test.policy, path)
+			}
+		}
+	}
+}
+
+func TestMoveDuplicatesSharedSidecars(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	extensions := splitExtensions(DefaultSidecarExtensions)
+	for _, policy := range []string{SidecarsMove, SidecarsDelete} {
+		mem, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/masters/IMG_0001.JPG", "image", modified},
+			{"/lib/incoming/IMG_0001.JPG", "image", modified},
+			// RAW of same photo is kept, so sidecar shared by both is left with it
+			{"/lib/incoming/IMG_0001.CR2", "raw", modified},
+			{"/lib/incoming/IMG_0001.xmp", "<x:xmpmeta/>", modified},
+			{"/lib/incoming/IMG_0001.JPG.AAE", "<plist/>", modified},
+		})
+		dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, Sidecars: policy, SidecarExtensions: extensions}
+		if _, _, deletions, err := countMoves(opts, dups); err != nil || policy == SidecarsDelete && deletions != 1 {
+			t.Errorf("%s: expected only unshared sidecar to be confirmed for deletion, got %d: %v", policy, deletions, err)
+		}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/removed/incoming/IMG_0001.JPG", "/lib/incoming/IMG_0001.CR2", "/lib/incoming/IMG_0001.xmp"} {
+			if _, err := mem.Stat(path); err != nil {
+				t.Errorf("%s: expected %s to exist: %v", policy, path, err)
+			}
+		}
+		for _, path := range []string{"/lib/incoming/IMG_0001.JPG.AAE", "/removed/incoming/IMG_0001.xmp"} {
+			if _, err := mem.Stat(path); err == nil {
+				t.Errorf("%s: expected %s to be missing", policy, path)
+			}
+		}
+	}
+}
+
+func TestMoveDuplicatesSidecarDestinationExists(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/IMG_0001.JPG", "image", modified},
+		{"/lib/incoming/IMG_0001.JPG", "image", modified},
+		{"/lib/incoming/IMG_0001.xmp", "<x:xmpmeta/>", modified},
+		{"/removed/incoming/IMG_0001.xmp", "<x:xmpmeta>other</x:xmpmeta>", modified},
+	})
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, Sidecars: SidecarsMove, SidecarExtensions: splitExtensions(DefaultSidecarExtensions)}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	// Media is not moved without its sidecar
+	for _, path := range []string{"/lib/incoming/IMG_0001.JPG", "/lib/incoming/IMG_0001.xmp"} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+	if _, err := mem.Stat("/removed/incoming/IMG_0001.JPG"); err == nil {
+		t.Error("Expected media not to be moved without its sidecar")
+	}
+}
+
+func TestFindDuplicatesProtected(t *testing.T) {
+	older := time.Date(2019, 7, 4, 12, 30, 0, 0, time.Local)
+	newer := older.Add(time.Hour)
+	protected := ProtectedPaths{"/lib/archive"}
+	tests := []struct {
+		name  string
+		opts  SearchOptions
+		found bool
+	}{
+		{"default", SearchOptions{Policy: MasterPolicy{Protected: protected}}, true},
+		// Protected master is outside of masters folder, so its duplicates are only reported as skipped
+		{"masters", SearchOptions{MastersFolder: "/lib/masters", Policy: MasterPolicy{Protected: protected}}, false},
+		{"duplicates in protected folder", SearchOptions{DuplicatesFolder: "/lib/archive", Policy: MasterPolicy{Protected: protected}}, false},
+	}
+	for _, test := range tests {
+		_, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/archive/a.jpg", "same", newer},
+			{"/lib/archive/copy/a.jpg", "same", newer},
+			{"/lib/masters/a.jpg", "same", older},
+			{"/lib/incoming/a.jpg", "same", older},
+		})
+		dups, err := FindDuplicates(test.opts, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.found != (len(dups) > 0) {
+			t.Errorf("%s: expected duplicates to be found: %t, got %d groups", test.name, test.found, len(dups))
+		}
+		for master, list := range dups {
+			if !protected.Contains(master.Path) {
+				t.Errorf("%s: expected protected master, got %s", test.name, master.Path)
+			}
+			for _, dup := range list {
+				if protected.Contains(dup.Path) {
+					t.Errorf("%s: expected protected %s not to be listed as duplicate", test.name, dup.Path)
+				}
+			}
+		}
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, Protected: protected}
+		if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		for _, path := range []string{"/lib/archive/a.jpg", "/lib/archive/copy/a.jpg"} {
+			if _, err := fsys.Stat(path); err != nil {
+				t.Errorf("%s: expected protected %s to be kept: %v", test.name, path, err)
+			}
+		}
+	}
+}
+
+func TestMoveDuplicatesRefusesProtected(t *testing.T) {
+	modified := time.Date(2019, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/archive/a.jpg", "same", modified},
+		{"/lib/incoming/a.jpg", "same", modified},
+	})
+	master, dup := fh.files["/lib/incoming/a.jpg"], fh.files["/lib/archive/a.jpg"]
+	tests := []struct {
+		name      string
+		protected ProtectedPaths
+	}{
+		{"protected duplicate", ProtectedPaths{"/lib/archive"}},
+		{"protected destination", ProtectedPaths{"/removed"}},
+	}
+	for _, test := range tests {
+		opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Apply: true, Protected: test.protected}
+		_, err := MoveDuplicates(opts, map[*FileMetadata][]*FileMetadata{master: {dup}}, fh)
+		if !errors.Is(err, ErrProtectedPath) {
+			t.Errorf("%s: expected protected path error, got %v", test.name, err)
+		}
+		if _, err := mem.Stat("/lib/archive/a.jpg"); err != nil {
+			t.Errorf("%s: expected duplicate to be kept: %v", test.name, err)
+		}
+	}
+}
+
+func TestFilterNewDuplicates(t *testing.T) {
+	taken := time.Date(2020, 7, 4, 12, 30, 0, 0, time.UTC)
+	snapshot := &FileHashes{files: map[string]*FileMetadata{
+		"/old/1.jpg": {Path: "/old/1.jpg", FileHash: "1", FirstSeen: taken.Add(-time.Hour)},
+		"/old/2.jpg": {Path: "/old/2.jpg", FileHash: "2", FirstSeen: taken},
+		"/old/3.jpg": {Path: "/old/3.jpg", FileHash: "3", FirstSeen: taken},
+	}}
+	old1 := &FileMetadata{Path: "/old/1.jpg", FileHash: "1", FirstSeen: taken.Add(-time.Hour)}
+	old2 := &FileMetadata{Path: "/old/2.jpg", FileHash: "2", FirstSeen: taken}
+	changed := &FileMetadata{Path: "/old/3.jpg", FileHash: "1", FirstSeen: taken}
+	added := &FileMetadata{Path: "/new/2.jpg", FileHash: "2", FirstSeen: taken.Add(time.Hour)}
+	// Record that snapshot did not cover, but was seen before it was taken
+	uncovered := &FileMetadata{Path: "/other/1.jpg", FileHash: "1", FirstSeen: taken.Add(-time.Hour)}
+	untracked := &FileMetadata{Path: "/other/2.jpg", FileHash: "2"}
+	tests := []struct {
+		name     string
+		master   *FileMetadata
+		dups     []*FileMetadata
+		expected bool
+	}{
+		{"unchanged", old1, []*FileMetadata{uncovered}, false},
+		{"changed", old1, []*FileMetadata{changed}, true},
+		{"added", old2, []*FileMetadata{added}, true},
+		{"without first seen time", old2, []*FileMetadata{untracked}, true},
+	}
+	for _, test := range tests {
+		result := FilterNewDuplicates(map[*FileMetadata][]*FileMetadata{test.master: test.dups}, snapshot)
+		if isNew := len(result) > 0; isNew != test.expected {
+			t.Errorf("%s: expected group to be new: %t, got %t", test.name, test.expected, isNew)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// findEmptiedDirs returns directories that are left empty once moved files are gone, deepest directories first
+// Only folders of moved files and their parents below root are considered, so directories that were empty before are never listed
+func findEmptiedDirs(moved map[string]bool, root string) ([]string, error) {
+	candidates := make(map[string]bool)
+	// Folders that directly contained moved files
+	sources := make(map[string]bool)
+	for path := range moved {
+		sources[filepath.Dir(path)] = true
+		for dir := filepath.Dir(path); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if len(root) > 0 && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+				break
+			}
+			candidates[dir] = true
+		}
+	}
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+	// Children are checked before their parents
+	sort.Slice(dirs, func(i, j int) bool {
+		if depthI, depthJ := strings.Count(dirs[i], string(filepath.Separator)), strings.Count(dirs[j], string(filepath.Separator)); depthI != depthJ {
+			return depthI > depthJ
+		}
+		return dirs[i] < dirs[j]
+	})
+	emptied := make(map[string]bool)
+	var result []string
+	for _, dir := range dirs {
+		entries, err := fsys.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		empty := true
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !moved[path] && !emptied[path] {
+				empty = false
+				break
+			}
+		}
+		// Folders without entries are only listed when moved files were in them
+		if empty && (sources[dir] || len(entries) > 0) {
+			emptied[dir] = true
+			result = append(result, dir)
+		}
+	}
+	return result, nil
+}
+
+// reportEmptiedDirs prints directories left empty after moving files and removes them when asked to
+func reportEmptiedDirs(moved map[string]bool, root string, remove bool) error {
+	dirs, err := findEmptiedDirs(moved, root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !remove {
+			fmt.Printf("Empty directory %s\n", dir)
+			continue
+		}
+		fmt.Printf("Removing empty directory %s\n", dir)
+		if err := fsys.Remove(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveEmptyDirs removes directories without files inside folders bottom-up, so that directories only containing empty ones are removed too
+// Folders themselves are kept, directories are only printed when apply is false
+func RemoveEmptyDirs(folders []string, apply bool) (int, error) {
+	removed := 0
+	for _, folder := range folders {
+		folder, err := absPath(folder)
+		if err != nil {
+			return removed, err
+		}
+		log.Infof("Looking for empty directories in %s\n", folder)
+		entries, err := fsys.ReadDir(folder)
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := removeEmptyDir(filepath.Join(folder, entry.Name()), apply, &removed); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// removeEmptyDir removes empty subdirectories of dir and then dir itself if nothing else was left in it
+// Returns whether dir was empty
+func removeEmptyDir(dir string, apply bool, removed *int) (bool, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			empty = false
+			continue
+		}
+		childEmpty, err := removeEmptyDir(filepath.Join(dir, entry.Name()), apply, removed)
+		if err != nil {
+			return false, err
+		}
+		empty = empty && childEmpty
+	}
+	if !empty {
+		return false, nil
+	}
+	*removed++
+	if !apply {
+		fmt.Printf("Empty directory %s\n", dir)
+		return true, nil
+	}
+	fmt.Printf("Removing empty directory %s\n", dir)
+	return true, fsys.Remove(dir)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by database and duplicate operations, use errors.Is to check for them since they are usually wrapped
+var (
+	// ErrDatabaseInUse is returned when database is locked by another process
+	ErrDatabaseInUse = errors.New("Database is in use by another process")
+	// ErrDBWriteToDestination is returned when record of database file itself would be written into it
+	ErrDBWriteToDestination = errors.New("Tried to write db data to destination file")
+	// ErrDestinationExists is returned when file already exists where duplicate would be moved
+	ErrDestinationExists = errors.New("Destination file already exists")
+	// ErrReadOnlyFolder is returned when duplicate would be moved from or into read-only folder
+	ErrReadOnlyFolder = errors.New("Folder is read-only")
+	// ErrNotEnoughSpace is returned when moving duplicate would leave less than required free space at destination
+	ErrNotEnoughSpace = errors.New("Not enough free space at destination")
+	// ErrNotConfirmed is returned when user did not confirm destructive action
+	ErrNotConfirmed = errors.New("Action was not confirmed")
+	// ErrCorruptImage is returned when image header is valid, but its contents can not be fully decoded, e.g. after interrupted download
+	ErrCorruptImage = errors.New("Possibly corrupt image")
+	// ErrCopyMismatch is returned when hash of copied file does not match hash of original
+	ErrCopyMismatch = errors.New("Copy does not match original")
+	// ErrOutsidePrefix is returned when file that would be moved or copied is outside of folder stripped from its path
+	ErrOutsidePrefix = errors.New("File is outside of prefix folder")
+	// ErrRemotePath is returned for URLs of remote folders with other scheme than sftp://, which can only be scanned when mounted locally
+	ErrRemotePath = errors.New("Only sftp:// remote paths are supported, mount remote folder and pass local path instead")
+	// ErrSFTPProtocol is returned when SFTP server sends packet that does not follow protocol
+	ErrSFTPProtocol = errors.New("Invalid SFTP packet")
+	// ErrProtectedPath is returned when protected file would be moved or deleted, or file would be moved into protected folder
+	ErrProtectedPath = errors.New("Path is protected")
+	// ErrFileTimeout is returned when file was not parsed in time, e.g. when it is on stale network mount
+	ErrFileTimeout = errors.New("File took too long to read")
+)
+
+// MoveError records failed move of duplicate along with its cause, e.g. ErrDestinationExists or error returned by file system
+type MoveError struct {
+	Path        string
+	Destination string
+	Err         error
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("Failed to move %s to %s: %s", e.Path, e.Destination, e.Err)
+}
+
+func (e *MoveError) Unwrap() error {
+	return e.Err
+}
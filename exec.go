@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// ExecDuplicates runs command for each duplicate, {master} and {duplicate} placeholders in command are replaced with file paths
+// Command is split into arguments on white space before placeholders are replaced, so paths are always passed as single arguments
+func ExecDuplicates(command string, dups map[*FileMetadata][]*FileMetadata, apply bool) error {
+	template := strings.Fields(command)
+	if len(template) == 0 {
+		return fmt.Errorf("Empty command")
+	}
+	masters := make([]*FileMetadata, 0, len(dups))
+	for master := range dups {
+		masters = append(masters, master)
+	}
+	sort.Slice(masters, func(i, j int) bool { return masters[i].Path < masters[j].Path })
+	failures := 0
+	for _, master := range masters {
+		for _, dup := range dups[master] {
+			if isArchiveEntry(master.Path) || isArchiveEntry(dup.Path) {
+				log.Warningf("Not running command for %s, files inside archives are only reported\n", dup.Path)
+				continue
+			}
+			replacer := strings.NewReplacer("{master}", master.Path, "{duplicate}", dup.Path)
+			args := make([]string, len(template))
+			for i, arg := range template {
+				args[i] = replacer.Replace(arg)
+			}
+			fmt.Printf("Running %q\n", args)
+			if !apply {
+				continue
+			}
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				log.Errorf("Command failed for %s: %s\n", dup.Path, err)
+				failures++
+			}
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d commands failed", failures)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Federation holds databases loaded read-only, e.g. caches of offline drives, each keeping its own hash index
+type Federation struct {
+	dbs []*FileHashes
+}
+
+// FederatedMatch is record with same content found in one of federated databases
+type FederatedMatch struct {
+	// Path of database that record came from
+	DBPath    string
+	Record    *FileMetadata
+	MatchType string
+}
+
+// ReadFederation reads databases as snapshots without merging them, relative paths are resolved against root
+func ReadFederation(dbPaths []string, root string) (*Federation, error) {
+	federation := &Federation{}
+	for _, dbPath := range dbPaths {
+		fh, err := ReadSnapshotDB(dbPath, root)
+		if err != nil {
+			return nil, err
+		}
+		federation.dbs = append(federation.dbs, fh)
+	}
+	return federation, nil
+}
+
+// Lookup returns records with same file or image hash as record from all federated databases in order they were read
+func (federation *Federation) Lookup(record *FileMetadata) []FederatedMatch {
+	var matches []FederatedMatch
+	for _, fh := range federation.dbs {
+		found := make(map[*FileMetadata]bool)
+		for _, hash := range []string{record.FileHash, record.ImageHash} {
+			if len(hash) == 0 {
+				continue
+			}
+			for _, match := range fh.hashes[hash] {
+				if !found[match] {
+					found[match] = true
+					matches = append(matches, FederatedMatch{DBPath: fh.dbPath, Record: match, MatchType: getMatchType(record, match)})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// PrintFederatedMatches prints files from folder, or whole database when folder is empty, that have copies in federated databases
+// along with database and path of each copy
+func PrintFederatedMatches(folder string, fh *FileHashes, federation *Federation) error {
+	prefix := ""
+	if len(folder) > 0 {
+		folder, err := absPath(folder)
+		if err != nil {
+			return err
+		}
+		prefix = fmt.Sprintf("%s%c", folder, filepath.Separator)
+	}
+	var paths []string
+	for path := range fh.files {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	fmt.Printf("* Found in other databases:\n")
+	for _, path := range paths {
+		matches := federation.Lookup(fh.files[path])
+		if len(matches) == 0 {
+			continue
+		}
+		fmt.Printf("    %s\n", path)
+		for _, match := range matches {
+			fmt.Printf("        %s: %s (%s)\n", match.DBPath, match.Record.Path, match.MatchType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFederationLookup(t *testing.T) {
+	driveA := makeTestFiles(t, t.TempDir(), map[string]string{"a.txt": "shared", "b.txt": "only on a"})
+	driveB := makeTestFiles(t, t.TempDir(), map[string]string{"copy.txt": "shared"})
+	local := makeTestFiles(t, t.TempDir(), map[string]string{"local.txt": "shared", "new.txt": "only local"})
+	CloseDB(driveA)
+	CloseDB(driveB)
+	federation, err := ReadFederation([]string{driveA.dbPath, driveB.dbPath}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record, unique *FileMetadata
+	for path, r := range local.files {
+		switch filepath.Base(path) {
+		case "local.txt":
+			record = r
+		case "new.txt":
+			unique = r
+		}
+	}
+	matches := federation.Lookup(record)
+	if len(matches) != 2 {
+		t.Fatalf("Expected match in each database, got %v", matches)
+	}
+	if matches[0].DBPath != driveA.dbPath || filepath.Base(matches[0].Record.Path) != "a.txt" || matches[0].MatchType != StrictMatch {
+		t.Errorf("Unexpected match in first database: %+v", matches[0])
+	}
+	if matches[1].DBPath != driveB.dbPath || filepath.Base(matches[1].Record.Path) != "copy.txt" {
+		t.Errorf("Unexpected match in second database: %+v", matches[1])
+	}
+	if matches := federation.Lookup(unique); len(matches) != 0 {
+		t.Errorf("Expected no matches, got %v", matches)
+	}
+	// Federated databases keep separate indexes
+	if len(federation.dbs[0].files) != 2 || len(federation.dbs[1].files) != 1 {
+		t.Errorf("Expected databases to not be merged")
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// getFileID is not supported, file index is only available from open file handle on Windows
+func getFileID(f os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
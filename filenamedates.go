@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultFilenameDateLayouts are layouts of dates commonly found in names of photos and videos,
+// e.g. IMG_20230704_123000.jpg, 2023-07-04 12.30.00.png or names produced by -rename-by-date
+var DefaultFilenameDateLayouts = []string{"20060102_150405", "2006-01-02 15.04.05", dateFileNameLayout, "2006-01-02"}
+
+// FilenameDateLayout is time layout of date embedded in file name along with pattern matching it
+type FilenameDateLayout struct {
+	Layout  string
+	pattern *regexp.Regexp
+}
+
+// layoutElements maps numeric time layout elements to patterns matching them
+var layoutElements = strings.NewReplacer("2006", `\d{4}`, "01", `\d{2}`, "02", `\d{2}`, "15", `\d{2}`, "04", `\d{2}`, "05", `\d{2}`)
+
+// ParseFilenameDateLayouts parses comma separated time layouts of dates in file names, only numeric elements are supported
+func ParseFilenameDateLayouts(value string) ([]FilenameDateLayout, error) {
+	var layouts []FilenameDateLayout
+	for _, layout := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(layout)) == 0 {
+			continue
+		}
+		if !strings.Contains(layout, "2006") {
+			return nil, fmt.Errorf("Date layout %s has no year", layout)
+		}
+		// Layout elements are replaced after quoting, since quoting does not change digits
+		pattern, err := regexp.Compile(`(?:^|\D)(` + layoutElements.Replace(regexp.QuoteMeta(layout)) + `)(?:\D|$)`)
+		if err != nil {
+			return nil, err
+		}
+		layouts = append(layouts, FilenameDateLayout{Layout: layout, pattern: pattern})
+	}
+	return layouts, nil
+}
+
+// getFilenameDate parses date from file name using first matching layout
+func getFilenameDate(path string, layouts []FilenameDateLayout) (time.Time, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, layout := range layouts {
+		match := layout.pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		if date, err := time.ParseInLocation(layout.Layout, match[1], time.Local); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("No date in file name %s", path)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetFilenameDate(t *testing.T) {
+	layouts, err := ParseFilenameDateLayouts("20060102_150405,2006-01-02 15.04.05,2006-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]time.Time{
+		"/photos/IMG_20230704_123000.jpg":   time.Date(2023, 7, 4, 12, 30, 0, 0, time.Local),
+		"/photos/2023-07-04 12.30.00.png":   time.Date(2023, 7, 4, 12, 30, 0, 0, time.Local),
+		"/photos/2023-07-04 beach.jpg":      time.Date(2023, 7, 4, 0, 0, 0, 0, time.Local),
+		"/photos/VID_20231304_123000.mp4":   {},
+		"/photos/IMG_1202301041_123000.jpg": {},
+		"/photos/20230704_123000/beach.jpg": {},
+	}
+	for path, expected := range tests {
+		date, err := getFilenameDate(path, layouts)
+		if expected.IsZero() {
+			if err == nil {
+				t.Errorf("Expected no date in %s, got %s", path, date)
+			}
+		} else if err != nil || !date.Equal(expected) {
+			t.Errorf("Expected %s in %s, got %s (error: %v)", expected, path, date, err)
+		}
+	}
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"crypto/sha1"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
 	"image"
 	"image/jpeg"
 	"io"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
@@ -17,41 +20,129 @@ import (
 	"s.mcquay.me/sm/mov"
 )
 
+// DefaultHashBufferSize is size of buffer files are read with while hashing, larger than io.Copy default to reduce reads of large files
+const DefaultHashBufferSize = 1 << 20
+
+var hashBufferSize = DefaultHashBufferSize
+
+// hashBuffers are reused across hashed files, so that big scans do not allocate buffer for every file
+var hashBuffers = newHashBuffers()
+
+func newHashBuffers() *sync.Pool {
+	size := hashBufferSize
+	return &sync.Pool{New: func() interface{} {
+		buffer := make([]byte, size)
+		return &buffer
+	}}
+}
+
+// SetHashBufferSize changes size of buffer files are read with while hashing, it has to be called before scanning
+func SetHashBufferSize(size int) {
+	hashBufferSize = size
+	hashBuffers = newHashBuffers()
+}
+
+// hashers are reused across files, so that big scans do not allocate hasher for every file
+var hashers = sync.Pool{New: func() interface{} { return sha1.New() }}
+
+// getHasher returns SHA-1 hasher from pool, which has to be returned with hashers.Put once its sum is read
+// Hasher is reset before it is returned, so that data written by previous user never leaks into next hash
+func getHasher() hash.Hash {
+	hasher := hashers.Get().(hash.Hash)
+	hasher.Reset()
+	return hasher
+}
+
 func getFileHash(path string) (string, error) {
-	f, err := os.Open(path)
+	f, err := fsys.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 	log.Debugf("Hashing file %s\n", path)
-	hasher := sha1.New()
-	if _, err := io.Copy(hasher, f); err != nil {
+	hasher := getHasher()
+	defer hashers.Put(hasher)
+	buffer := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buffer)
+	// File is wrapped, since its WriteTo would copy with default buffer instead
+	if _, err := io.CopyBuffer(hasher, struct{ io.Reader }{f}, *buffer); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-func getImageHash(path string) (string, error) {
-	f, err := os.Open(path)
+func readImage(path string) (image.Image, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer f.Close()
 	log.Debugf("Reading image %s\n", path)
-	image, err := jpeg.Decode(f)
+	config, err := jpeg.DecodeConfig(f)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// Header was read, so failures past it mean that file is damaged rather than not an image
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptImage, err)
 	}
+	if bounds := img.Bounds(); bounds.Dx() != config.Width || bounds.Dy() != config.Height {
+		return nil, fmt.Errorf("%w: decoded %dx%d instead of declared %dx%d", ErrCorruptImage, bounds.Dx(), bounds.Dy(), config.Width, config.Height)
+	}
+	return img, nil
+}
+
+func getImageHash(path string, image image.Image) (string, error) {
 	log.Debugf("Hashing image %s\n", path)
-	hasher := sha1.New()
+	hasher := getHasher()
+	defer hashers.Put(hasher)
 	if err := writeImage(hasher, image); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-func getImageDate(path string) (time.Time, error) {
-	f, err := os.Open(path)
+// dateTags maps names of EXIF dates to functions reading them
+var dateTags = map[string]func(x *exif.Exif) (time.Time, error){
+	"original":  func(x *exif.Exif) (time.Time, error) { return getDateTimeFromTag(exif.DateTimeOriginal, x) },
+	"digitized": func(x *exif.Exif) (time.Time, error) { return getDateTimeFromTag(exif.DateTimeDigitized, x) },
+	"datetime":  func(x *exif.Exif) (time.Time, error) { return getDateTimeFromTag(exif.DateTime, x) },
+	"gps":       getGPSDateTime,
+}
+
+// DefaultDateTags is default priority of EXIF dates used as shooting date
+var DefaultDateTags = []string{"original", "digitized", "datetime"}
+
+// ParseDateTags parses comma separated priority of EXIF dates, dates that are not listed are not used
+func ParseDateTags(value string) ([]string, error) {
+	var tags []string
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if dateTags[name] == nil {
+			return nil, fmt.Errorf("Unknown date tag %s", name)
+		}
+		if listed[name] {
+			return nil, fmt.Errorf("Date tag %s is listed more than once", name)
+		}
+		listed[name] = true
+		tags = append(tags, name)
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("No date tags listed")
+	}
+	return tags, nil
+}
+
+func getImageDate(path string, tags []string) (time.Time, error) {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -61,22 +152,58 @@ func getImageDate(path string) (time.Time, error) {
 	if err != nil {
 		return time.Time{}, err
 	}
-	datetime, err := getOriginalDateTime(x)
+	datetime, err := getOriginalDateTime(x, tags)
 	if err == nil {
 		return datetime, nil
 	}
 	return time.Time{}, err
 }
 
-func getOriginalDateTime(x *exif.Exif) (time.Time, error) {
-	dt, err := getDateTimeFromTag(exif.DateTimeOriginal, x)
+// getOriginalDateTime returns first date found in order of tags, default priority is used when tags are empty
+func getOriginalDateTime(x *exif.Exif, tags []string) (time.Time, error) {
+	if len(tags) == 0 {
+		tags = DefaultDateTags
+	}
+	var dt time.Time
+	err := errors.New("No date tags")
+	for _, name := range tags {
+		if dt, err = dateTags[name](x); err == nil {
+			break
+		}
+	}
+	return dt, err
+}
+
+// getGPSDateTime reads UTC date and time of GPS fix
+func getGPSDateTime(x *exif.Exif) (time.Time, error) {
+	dateTag, err := x.Get(exif.GPSDateStamp)
 	if err != nil {
-		dt, err = getDateTimeFromTag(exif.DateTimeDigitized, x)
+		return time.Time{}, err
+	}
+	dateStr, err := dateTag.StringVal()
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := time.Parse("2006:01:02", strings.TrimRight(dateStr, "\x00"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	timeTag, err := x.Get(exif.GPSTimeStamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var seconds float64
+	for i, unit := range []float64{3600, 60, 1} {
+		num, den, err := timeTag.Rat2(i)
 		if err != nil {
-			dt, err = getDateTimeFromTag(exif.DateTime, x)
+			return time.Time{}, err
 		}
+		if den == 0 {
+			return time.Time{}, errors.New("Invalid GPS time")
+		}
+		seconds += unit * float64(num) / float64(den)
 	}
-	return dt, err
+	return date.Add(time.Duration(seconds * float64(time.Second))).In(time.Local), nil
 }
 
 func getDateTimeFromTag(name exif.FieldName, x *exif.Exif) (time.Time, error) {
@@ -95,7 +222,7 @@ func getDateTimeFromTag(name exif.FieldName, x *exif.Exif) (time.Time, error) {
 }
 
 func getMovieDate(path string) (time.Time, error) {
-	f, err := os.Open(path)
+	f, err := fsys.Open(path)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -108,8 +235,9 @@ func getMovieDate(path string) (time.Time, error) {
 	return time.Time{}, err
 }
 
-func getMediaDate(path string) (time.Time, error) {
-	dateShot, err := getImageDate(path)
+// getMediaDate reads shooting date from EXIF or moov atom, and from file name as last resort when layouts are given
+func getMediaDate(path string, tags []string, layouts []FilenameDateLayout) (time.Time, error) {
+	dateShot, err := getImageDate(path, tags)
 	if err != nil && strings.HasSuffix(strings.ToLower(path), ".mov") {
 		log.Debugf("No exif %s\n", path)
 		dateShot, err = getMovieDate(path)
@@ -117,9 +245,70 @@ func getMediaDate(path string) (time.Time, error) {
 			log.Debugf("No moov %s\n", path)
 		}
 	}
+	if err != nil && len(layouts) > 0 {
+		if date, nameErr := getFilenameDate(path, layouts); nameErr == nil {
+			log.Infof("Using date from file name %s\n", path)
+			return date, nil
+		}
+	}
 	return dateShot, err
 }
 
-func writeImage(writer io.Writer, image image.Image) error {
-	return bmp.Encode(writer, image)
+func writeImage(writer io.Writer, img image.Image) error {
+	if ycbcr, ok := img.(*image.YCbCr); ok {
+		return writeYCbCrImage(writer, ycbcr)
+	}
+	return bmp.Encode(writer, img)
+}
+
+// rowBuffers are scratch buffers for pixel rows of written images
+var rowBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// writeYCbCrImage writes same 24 bit BMP as bmp.Encode, which boxes every pixel of images it has no fast path for,
+// such as decoded JPEG images, so image hashes stay the same
+func writeYCbCrImage(writer io.Writer, img *image.YCbCr) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	// Rows are padded to 4 bytes
+	step := (3*width + 3) &^ 3
+	var header [54]byte
+	header[0], header[1] = 'B', 'M'
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(header)+height*step))
+	binary.LittleEndian.PutUint32(header[10:], uint32(len(header)))
+	binary.LittleEndian.PutUint32(header[14:], 40)
+	binary.LittleEndian.PutUint32(header[18:], uint32(width))
+	binary.LittleEndian.PutUint32(header[22:], uint32(height))
+	binary.LittleEndian.PutUint16(header[26:], 1)
+	binary.LittleEndian.PutUint16(header[28:], 24)
+	binary.LittleEndian.PutUint32(header[34:], uint32(height*step))
+	if _, err := writer.Write(header[:]); err != nil {
+		return err
+	}
+	if width == 0 || height == 0 {
+		return nil
+	}
+	buffer := rowBuffers.Get().(*[]byte)
+	defer rowBuffers.Put(buffer)
+	if cap(*buffer) < step {
+		*buffer = make([]byte, step)
+	}
+	row := (*buffer)[:step]
+	// Padding might be left over from wider image
+	for i := 3 * width; i < step; i++ {
+		row[i] = 0
+	}
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		off := 0
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.YCbCrAt(x, y).RGBA()
+			row[off+2] = byte(r >> 8)
+			row[off+1] = byte(g >> 8)
+			row[off+0] = byte(b >> 8)
+			off += 3
+		}
+		if _, err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
 }
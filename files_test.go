@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/op/go-logging"
+	"golang.org/x/image/bmp"
+)
+
+func benchmarkGetFileHash(b *testing.B, bufferSize int) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	path := filepath.Join(b.TempDir(), "large.bin")
+	if err := ioutil.WriteFile(path, make([]byte, 64<<20), 0666); err != nil {
+		b.Fatal(err)
+	}
+	SetHashBufferSize(bufferSize)
+	defer SetHashBufferSize(DefaultHashBufferSize)
+	b.SetBytes(64 << 20)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := getFileHash(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetFileHash32K hashes with io.Copy default buffer size for comparison
+func BenchmarkGetFileHash32K(b *testing.B) {
+	benchmarkGetFileHash(b, 32<<10)
+}
+
+func BenchmarkGetFileHash1M(b *testing.B) {
+	benchmarkGetFileHash(b, DefaultHashBufferSize)
+}
+
+func BenchmarkGetImageHash(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	img, err := readImage("samples/sample.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := getImageHash("samples/sample.jpg", img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetFileHashSmall(b *testing.B) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := getFileHash("samples/sample.jpg"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteImageMatchesBMP(t *testing.T) {
+	img, err := readImage("samples/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycbcr, ok := img.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("Expected sample to decode into YCbCr image, got %T", img)
+	}
+	// Odd width needs row padding, and sub image has bounds that do not start at origin
+	images := []image.Image{ycbcr, ycbcr.SubImage(image.Rect(3, 5, 40, 21)), ycbcr.SubImage(image.Rect(0, 0, 0, 0))}
+	for _, img := range images {
+		var expected, actual bytes.Buffer
+		if err := bmp.Encode(&expected, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeImage(&actual, img); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+			t.Errorf("Expected image with bounds %v to be written same as by bmp.Encode", img.Bounds())
+		}
+	}
+}
+
+func TestGetFileHashResetsPooledHashers(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{"a.txt": "first", "b.txt": "second"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hasher left with data in pool must not affect next hash
+	dirty := getHasher()
+	dirty.Write([]byte("leftover"))
+	hashers.Put(dirty)
+	for _, test := range []struct{ name, expected string }{{"a.txt", "first"}, {"b.txt", "second"}, {"a.txt", "first"}} {
+		hash, err := getFileHash(filepath.Join(root, test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("%x", sha1.Sum([]byte(test.expected))); hash != expected {
+			t.Errorf("Expected hash %s of %s, got %s", expected, test.name, hash)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File is file opened for reading through Filesystem, it has to be seekable, since image and movie headers are read before their contents
+type File interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// Filesystem is set of file operations used when scanning and moving files, so that they can be backed by something else than local disk, e.g. in-memory files in tests
+// Database, thumbnails, archives and copies are always accessed through os package, so they can not be on remote hosts
+type Filesystem interface {
+	Open(path string) (File, error)
+	Stat(path string) (os.FileInfo, error)
+	// Lstat does not follow symlinks, so that they can be told apart from their targets
+	Lstat(path string) (os.FileInfo, error)
+	// ReadDir returns entries of folder sorted by name, symlinks are described by Lstat
+	ReadDir(path string) ([]os.FileInfo, error)
+	// Walk walks file tree the same way as filepath.Walk
+	Walk(root string, walkFn filepath.WalkFunc) error
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	// Symlink creates newname as symlink to oldname
+	Symlink(oldname, newname string) error
+}
+
+// osFilesystem is Filesystem backed by local disk
+type osFilesystem struct{}
+
+func (osFilesystem) Open(path string) (File, error) {
+	return os.Open(path)
+}
+
+func (osFilesystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (osFilesystem) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (osFilesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+func (osFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+func (osFilesystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFilesystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (osFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFilesystem) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// fsys is Filesystem scanned files are read from and duplicates are moved within, remote paths are served over SFTP
+var fsys Filesystem = newRemoteFilesystem(osFilesystem{})
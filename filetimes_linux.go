@@ -1,6 +1,41 @@
 package main
 
-func getCreationTime(f os.FileInfo) time.Time {
-	var stat = fileinfo.Sys().(*syscall.Stat_t)
-	time.Unix(stat.Btim.Sec, stat.Btim.Nsec)
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// statxUnsupported is set once kernel turns out not to support statx (before Linux 4.11), so that it is not called again
+var statxUnsupported int32
+
+// getCreationTime reads birth time of file with statx, since it is not exposed by Stat
+// Modification time is returned when file does not come from local disk, or kernel or file system does not record birth time
+func getCreationTime(path string, f os.FileInfo) time.Time {
+	stat, ok := f.Sys().(*syscall.Stat_t)
+	if !ok || atomic.LoadInt32(&statxUnsupported) != 0 {
+		// File does not come from local disk, e.g. in-memory file in tests
+		return f.ModTime()
+	}
+	flags := 0
+	if f.Mode()&os.ModeSymlink != 0 {
+		// Symlink records describe symlink itself
+		flags = unix.AT_SYMLINK_NOFOLLOW
+	}
+	var statx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, flags, unix.STATX_BTIME|unix.STATX_INO, &statx); err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			atomic.StoreInt32(&statxUnsupported, 1)
+		}
+		return f.ModTime()
+	}
+	if statx.Mask&unix.STATX_BTIME == 0 || statx.Ino != uint64(stat.Ino) {
+		// File system does not record birth time, or file was replaced since it was stat'ed
+		return f.ModTime()
+	}
+	return time.Unix(statx.Btime.Sec, int64(statx.Btime.Nsec))
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetCreationTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	before := time.Now().Add(-time.Second)
+	if err := ioutil.WriteFile(path, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Modification time is moved to the past, so that it is not mistaken for birth time
+	modified := time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := getCreationTime(path, f)
+	if !created.Equal(modified) && (created.Before(before) || created.After(time.Now())) {
+		t.Errorf("Expected birth time of new file or modification time when it is not recorded, got %s", created)
+	}
+	mem := &memFileInfo{name: "file.txt", modTime: modified}
+	if created := getCreationTime(path, mem); !created.Equal(modified) {
+		t.Errorf("Expected modification time of file that does not come from disk, got %s", created)
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"time"
 )
 
-func getCreationTime(f os.FileInfo) time.Time {
-	stat := f.Sys().(*syscall.Win32FileAttributeData)
+// getCreationTime reads creation time recorded by NTFS, path is not needed since it is part of file attributes
+func getCreationTime(path string, f os.FileInfo) time.Time {
+	stat, ok := f.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		// File does not come from local disk, e.g. in-memory file in tests
+		return f.ModTime()
+	}
 	seconds := stat.CreationTime.Nanoseconds() / 1000000000
 	nanoseconds := stat.CreationTime.Nanoseconds() - seconds*1000000000
 	return time.Unix(seconds, nanoseconds)
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regexpPrefix marks patterns that are regular expressions matched against full path instead of glob patterns
+const regexpPrefix = "re:"
+
+// pathPattern is glob pattern or regular expression matched against scanned paths
+type pathPattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+// matches checks if path matches pattern, glob patterns without separator are matched against base name and other ones against full path
+func (p pathPattern) matches(path string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(path)
+	}
+	if !strings.ContainsRune(p.glob, filepath.Separator) {
+		path = filepath.Base(path)
+	}
+	matched, _ := filepath.Match(p.glob, path)
+	return matched
+}
+
+// PathFilter selects files that are scanned by include and exclude patterns
+type PathFilter struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+// NewPathFilter parses include and exclude patterns, patterns starting with re: are regular expressions and other ones are globs
+func NewPathFilter(include []string, exclude []string) (*PathFilter, error) {
+	filter := &PathFilter{}
+	var err error
+	if filter.include, err = parsePathPatterns(include); err != nil {
+		return nil, err
+	}
+	if filter.exclude, err = parsePathPatterns(exclude); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func parsePathPatterns(patterns []string) ([]pathPattern, error) {
+	var parsed []pathPattern
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, regexpPrefix) {
+			re, err := regexp.Compile(pattern[len(regexpPrefix):])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %s: %w", pattern, err)
+			}
+			parsed = append(parsed, pathPattern{regexp: re})
+			continue
+		}
+		pattern = filepath.FromSlash(pattern)
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %w", pattern, err)
+		}
+		parsed = append(parsed, pathPattern{glob: pattern})
+	}
+	return parsed, nil
+}
+
+// Allows checks if path should be scanned, excluded paths are never scanned and only included files are scanned when there are include patterns
+// Folders are only checked against exclude patterns, so that included files inside them are found
+func (filter *PathFilter) Allows(path string, isDir bool) bool {
+	for _, pattern := range filter.exclude {
+		if pattern.matches(path) {
+			return false
+		}
+	}
+	if isDir || len(filter.include) == 0 {
+		return true
+	}
+	for _, pattern := range filter.include {
+		if pattern.matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPatterns splits comma separated patterns given as flag value
+func SplitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); len(pattern) > 0 {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// ReadPatternsFile reads patterns from file with one pattern per line, empty lines and lines starting with # are skipped
+func ReadPatternsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPathFilter(t *testing.T) {
+	filter, err := NewPathFilter([]string{"*.jpg", "re:/docs/.*\\.pdf$"}, []string{"node_modules", "/lib/private/*", "*.tmp.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		isDir   bool
+		allowed bool
+	}{
+		{"/lib/photo.jpg", false, true},
+		{"/lib/photo.png", false, false},
+		{"/lib/docs/report.pdf", false, true},
+		{"/lib/photo.tmp.jpg", false, false},
+		{"/lib/node_modules", true, false},
+		{"/lib/private/photo.jpg", false, false},
+		{"/lib/private", true, true},
+		{"/lib/other", true, true},
+	}
+	for _, test := range tests {
+		if allowed := filter.Allows(filepath.FromSlash(test.path), test.isDir); allowed != test.allowed {
+			t.Errorf("Expected %s to be allowed %v, got %v", test.path, test.allowed, allowed)
+		}
+	}
+	if _, err := NewPathFilter([]string{"re:("}, nil); err == nil {
+		t.Error("Expected invalid regular expression to fail")
+	}
+}
+
+func TestReadPatternsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := ioutil.WriteFile(path, []byte("# build output\nnode_modules\n\n  *.tmp  \n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ReadPatternsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"node_modules", "*.tmp"}; !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("Expected %v, got %v", expected, patterns)
+	}
+}
+
+func TestScanFilteredFolders(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem := useMemFilesystem(t)
+	for _, path := range []string{"/lib/a.jpg", "/lib/b.txt", "/lib/cache/c.jpg"} {
+		mem.writeFile(path, path, modified)
+	}
+	filter, err := NewPathFilter([]string{"*.jpg"}, []string{"cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{"/lib"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(fh.files) != 1 || fh.files[filepath.FromSlash("/lib/a.jpg")] == nil {
+		t.Errorf("Expected only included file outside excluded folder to be scanned, got %v", fh.files)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// folderNode is folder known from database paths, along with its files and subfolders
+type folderNode struct {
+	path    string
+	parent  *folderNode
+	files   map[string]string
+	folders map[string]*folderNode
+	// Combined hash of names and hashes of everything inside folder, empty until it is calculated
+	hash string
+	// Total size and count of files inside folder and its subfolders
+	size  int64
+	count int
+}
+
+// identicalFolders is group of folders with same names and contents of all files inside them
+type identicalFolders struct {
+	size  int64
+	count int
+	paths []string
+}
+
+// getFolderTree builds folders containing recorded files, files inside archives and symlinks are left out since they can not be removed along with folder
+func getFolderTree(fh *FileHashes) map[string]*folderNode {
+	folders := make(map[string]*folderNode)
+	var getFolder func(path string) *folderNode
+	getFolder = func(path string) *folderNode {
+		if node, ok := folders[path]; ok {
+			return node
+		}
+		node := &folderNode{path: path, files: make(map[string]string), folders: make(map[string]*folderNode)}
+		folders[path] = node
+		if parentPath := filepath.Dir(path); parentPath != path {
+			node.parent = getFolder(parentPath)
+			node.parent.folders[filepath.Base(path)] = node
+		}
+		return node
+	}
+	for path, record := range fh.files {
+		if len(record.FileHash) == 0 || len(record.SymlinkTarget) > 0 || isArchiveEntry(path) {
+			continue
+		}
+		folder := getFolder(filepath.Dir(path))
+		folder.files[filepath.Base(path)] = record.FileHash
+		for node := folder; node != nil; node = node.parent {
+			node.size += record.Size
+			node.count++
+		}
+	}
+	return folders
+}
+
+// getFolderHash hashes sorted names and hashes of files and subfolders, so that folders have same hash only when their trees are identical
+func getFolderHash(node *folderNode) string {
+	if len(node.hash) > 0 {
+		return node.hash
+	}
+	var names []string
+	for name := range node.files {
+		names = append(names, name)
+	}
+	for name := range node.folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hasher := getHasher()
+	defer hashers.Put(hasher)
+	for _, name := range names {
+		// File and folder of same name can not exist at once, so kind is only needed to tell file from folder with same hash
+		if hash, ok := node.files[name]; ok {
+			fmt.Fprintf(hasher, "f\x00%s\x00%s\n", name, hash)
+		} else {
+			fmt.Fprintf(hasher, "d\x00%s\x00%s\n", name, getFolderHash(node.folders[name]))
+		}
+	}
+	node.hash = hex.EncodeToString(hasher.Sum(nil))
+	return node.hash
+}
+
+// hasOnlyIndexedFiles checks on disk that every file inside folder and its subfolders is recorded with hash, files that are hidden, excluded,
+// ignored, empty or symlinked are not recorded, so folder holding them is not identical to its copy even when recorded files are
+// Results are kept in checked by path
+func hasOnlyIndexedFiles(fh *FileHashes, path string, checked map[string]bool) bool {
+	if indexed, ok := checked[path]; ok {
+		return indexed
+	}
+	indexed := true
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		log.Warningf("Failed to list %s: %s\n", path, err)
+		indexed = false
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if entry.IsDir() {
+			if !hasOnlyIndexedFiles(fh, entryPath, checked) {
+				indexed = false
+				break
+			}
+			continue
+		}
+		if record := fh.files[entryPath]; record == nil || len(record.FileHash) == 0 || len(record.SymlinkTarget) > 0 {
+			log.Debugf("Folder %s holds file %s that is not recorded\n", path, entryPath)
+			indexed = false
+			break
+		}
+	}
+	checked[path] = indexed
+	return indexed
+}
+
+// getIdenticalFolders returns groups of folders with identical trees sorted by reclaimable space
+// Subfolders of identical folders are identical too, so group is left out when every folder in it is inside folder that is reported already
+func getIdenticalFolders(fh *FileHashes) []identicalFolders {
+	byHash := make(map[string][]*folderNode)
+	for _, node := range getFolderTree(fh) {
+		if node.count > 0 {
+			hash := getFolderHash(node)
+			byHash[hash] = append(byHash[hash], node)
+		}
+	}
+	// Folders holding files that are not recorded are left out, since they are not identical on disk
+	checked := make(map[string]bool)
+	for hash, nodes := range byHash {
+		if len(nodes) < 2 {
+			continue
+		}
+		var indexed []*folderNode
+		for _, node := range nodes {
+			if hasOnlyIndexedFiles(fh, node.path, checked) {
+				indexed = append(indexed, node)
+			}
+		}
+		byHash[hash] = indexed
+	}
+	var groups []identicalFolders
+	for _, nodes := range byHash {
+		if len(nodes) < 2 {
+			continue
+		}
+		nested := true
+		for _, node := range nodes {
+			if node.parent == nil || len(byHash[getFolderHash(node.parent)]) < 2 {
+				nested = false
+				break
+			}
+		}
+		if nested {
+			continue
+		}
+		group := identicalFolders{size: nodes[0].size, count: nodes[0].count}
+		for _, node := range nodes {
+			group.paths = append(group.paths, node.path)
+		}
+		sort.Strings(group.paths)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		reclaimableI := groups[i].size * int64(len(groups[i].paths)-1)
+		reclaimableJ := groups[j].size * int64(len(groups[j].paths)-1)
+		if reclaimableI != reclaimableJ {
+			return reclaimableI > reclaimableJ
+		}
+		return groups[i].paths[0] < groups[j].paths[0]
+	})
+	return groups
+}
+
+// PrintIdenticalFolders prints groups of folders with same file names and contents along with their total size, so that redundant copies can be removed at once
+func PrintIdenticalFolders(fh *FileHashes) {
+	groups := getIdenticalFolders(fh)
+	reclaimable := int64(0)
+	fmt.Printf("* Identical folders:\n")
+	for _, group := range groups {
+		fmt.Printf("%011d %6d %s\n", group.size, group.count, group.paths[0])
+		for _, path := range group.paths[1:] {
+			fmt.Printf("    = %s\n", path)
+		}
+		reclaimable += group.size * int64(len(group.paths)-1)
+	}
+	fmt.Printf("* %d groups of identical folders, %s reclaimable\n", len(groups), formatSize(reclaimable))
+}
+
+// folderSubset is folder whose files are found in container folder
+type folderSubset struct {
+	path      string
+	container string
+	// Number of distinct contents inside folder and how many of them are found in container, along with their size
+	count     int
+	contained int
+	size      int64
+}
+
+// getFolderContents returns hashes and sizes of distinct contents of files directly inside each folder
+func getFolderContents(fh *FileHashes) map[string]map[string]int64 {
+	contents := make(map[string]map[string]int64)
+	for path, record := range fh.files {
+		if len(record.FileHash) == 0 || len(record.SymlinkTarget) > 0 || isArchiveEntry(path) {
+			continue
+		}
+		folder := filepath.Dir(path)
+		if contents[folder] == nil {
+			contents[folder] = make(map[string]int64)
+		}
+		contents[folder][record.FileHash] = record.Size
+	}
+	return contents
+}
+
+// getSubsetFolders returns folders with at least minOverlap share of their files found in other folder, sorted by overlap and size
+// Only files directly inside folders are compared, files are looked up by hash in index, so names do not have to match
+// Folders with same contents are both subsets of each other, so only one of them is returned
+func getSubsetFolders(fh *FileHashes, minOverlap float64) []folderSubset {
+	contents := getFolderContents(fh)
+	var subsets []folderSubset
+	for folder, hashes := range contents {
+		contained := make(map[string]int)
+		for hash := range hashes {
+			seen := make(map[string]bool)
+			for _, record := range fh.hashes[hash] {
+				// Index also lists records with same image hash, and container may hold several copies of file
+				container := filepath.Dir(record.Path)
+				if record.FileHash != hash || container == folder || seen[container] {
+					continue
+				}
+				if _, ok := contents[container][hash]; ok {
+					seen[container] = true
+					contained[container]++
+				}
+			}
+		}
+		best := folderSubset{path: folder, count: len(hashes)}
+		for container, count := range contained {
+			if count > best.contained || count == best.contained && container < best.container {
+				best.container = container
+				best.contained = count
+			}
+		}
+		if best.contained == 0 || float64(best.contained) < minOverlap*float64(best.count) {
+			continue
+		}
+		if best.contained == best.count && len(contents[best.container]) == best.count && folder < best.container {
+			continue
+		}
+		for hash, size := range hashes {
+			if _, ok := contents[best.container][hash]; ok {
+				best.size += size
+			}
+		}
+		subsets = append(subsets, best)
+	}
+	sort.Slice(subsets, func(i, j int) bool {
+		overlapI := float64(subsets[i].contained) / float64(subsets[i].count)
+		overlapJ := float64(subsets[j].contained) / float64(subsets[j].count)
+		if overlapI != overlapJ {
+			return overlapI > overlapJ
+		}
+		if subsets[i].size != subsets[j].size {
+			return subsets[i].size > subsets[j].size
+		}
+		return subsets[i].path < subsets[j].path
+	})
+	return subsets
+}
+
+// PrintSubsetFolders prints folders with at least minOverlap share of their files found in other folder, along with size of files found there
+// Folders that are entirely contained in other folder are printed first, they can be removed without losing files
+func PrintSubsetFolders(fh *FileHashes, minOverlap float64) {
+	subsets := getSubsetFolders(fh, minOverlap)
+	reclaimable := int64(0)
+	entire := 0
+	fmt.Printf("* Folders contained in other folders:\n")
+	for _, subset := range subsets {
+		fmt.Printf("%011d %3d%% %s (%d of %d files in %s)\n", subset.size, subset.contained*100/subset.count, subset.path, subset.contained, subset.count, subset.container)
+		if subset.contained == subset.count {
+			reclaimable += subset.size
+			entire++
+		}
+	}
+	fmt.Printf("* %d folders entirely contained in other folders, %s reclaimable\n", entire, formatSize(reclaimable))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetIdenticalFolders(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/a/album/1.jpg", "one", modified},
+		{"/a/album/2.jpg", "two", modified},
+		{"/a/album/sub/3.jpg", "three", modified},
+		{"/a/notes.txt", "notes", modified},
+		{"/b/album/1.jpg", "one", modified},
+		{"/b/album/2.jpg", "two", modified},
+		{"/b/album/sub/3.jpg", "three", modified},
+		// Same contents under different names are not identical folders
+		{"/c/album/one.jpg", "one", modified},
+		{"/c/album/2.jpg", "two", modified},
+		{"/c/album/sub/3.jpg", "three", modified},
+	})
+	groups := getIdenticalFolders(fh)
+	expected := []identicalFolders{
+		{size: 11, count: 3, paths: []string{"/a/album", "/b/album"}},
+		// Subfolder of /c/album is identical to ones of other albums, while /c/album itself is not
+		{size: 5, count: 1, paths: []string{"/a/album/sub", "/b/album/sub", "/c/album/sub"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected identical folders %v, got %v", expected, groups)
+	}
+}
+
+func TestGetIdenticalFoldersUnrecordedFiles(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/a/album/1.jpg", "one", modified},
+		{"/a/album/sub/2.jpg", "two", modified},
+		{"/b/album/1.jpg", "one", modified},
+		{"/b/album/sub/2.jpg", "two", modified},
+	})
+	// File that is not recorded, e.g. hidden or excluded one, would be lost if /b/album was removed as copy of /a/album
+	mem.writeFile("/b/album/.notes.txt", "notes", modified)
+	groups := getIdenticalFolders(fh)
+	expected := []identicalFolders{
+		{size: 3, count: 1, paths: []string{"/a/album/sub", "/b/album/sub"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected identical folders %v, got %v", expected, groups)
+	}
+}
+
+func TestGetSubsetFolders(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/a/album/1.jpg", "one", modified},
+		{"/a/album/2.jpg", "two", modified},
+		{"/a/album/3.jpg", "three", modified},
+		{"/a/album/4.jpg", "four", modified},
+		// Entirely contained, names do not have to match
+		{"/b/selection/first.jpg", "one", modified},
+		{"/b/selection/2.jpg", "two", modified},
+		// Half of files are found in /a/album
+		{"/c/mixed/1.jpg", "one", modified},
+		{"/c/mixed/5.jpg", "five", modified},
+		// Only one of identical folders is reported
+		{"/d/copy/6.jpg", "six", modified},
+		{"/e/copy/6.jpg", "six", modified},
+		// Less than minimum overlap
+		{"/f/other/1.jpg", "one", modified},
+		{"/f/other/7.jpg", "seven", modified},
+		{"/f/other/8.jpg", "eight", modified},
+	})
+	subsets := getSubsetFolders(fh, 0.5)
+	expected := []folderSubset{
+		{path: "/b/selection", container: "/a/album", count: 2, contained: 2, size: 6},
+		{path: "/e/copy", container: "/d/copy", count: 1, contained: 1, size: 3},
+		// Half of album is found in its selection too
+		{path: "/a/album", container: "/b/selection", count: 4, contained: 2, size: 6},
+		{path: "/c/mixed", container: "/a/album", count: 2, contained: 1, size: 3},
+	}
+	if !reflect.DeepEqual(subsets, expected) {
+		t.Errorf("Expected subset folders %v, got %v", expected, subsets)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps size suffixes to their multipliers, both decimal and binary suffixes are treated as binary units
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// parseSize parses byte count with optional unit suffix, e.g. 500M or 10GiB
+func parseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(upper, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("Invalid size %s", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// getExistingFreeSpace returns free space available for path, which may not exist yet, by checking its closest existing parent
+func getExistingFreeSpace(path string) (int64, error) {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			free, err := getFreeSpace(path)
+			return int64(free), err
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, fmt.Errorf("No existing folder for %s", path)
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// getFreeSpace returns number of bytes available to current user on file system containing path
+func getFreeSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1024":   1024,
+		"500M":   500 << 20,
+		"10GiB":  10 << 30,
+		"1.5 gb": 3 << 29,
+		"2T":     2 << 40,
+		"100 b":  100,
+		"0":      0,
+		"7KB":    7 << 10,
+	}
+	for value, expected := range tests {
+		size, err := parseSize(value)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", value, err)
+		} else if size != expected {
+			t.Errorf("Expected %s to be %d bytes, got %d", value, expected, size)
+		}
+	}
+	for _, value := range []string{"", "G", "-1G", "ten"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// getFreeSpace returns number of bytes available to current user on volume containing path
+func getFreeSpace(path string) (uint64, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &free, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+func hasHiddenAttribute(f os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func hasHiddenAttribute(f os.FileInfo) bool {
+	stat, ok := f.Sys().(*syscall.Win32FileAttributeData)
+	return ok && stat.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
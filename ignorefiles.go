@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is name of files with patterns of files skipped in folder containing it and its subfolders
+const ignoreFileName = ".cleanerignore"
+
+// ignoreRule is single pattern from ignore file
+type ignoreRule struct {
+	pattern string
+	// Pattern starts with !, so that matching paths are scanned even if they were ignored by previous patterns
+	negate bool
+	// Pattern ends with /, so that it only matches folders
+	dirOnly bool
+	// Pattern contains / before its end, so that it is matched against path relative to folder of ignore file instead of name
+	anchored bool
+}
+
+// parseIgnoreRule parses line of ignore file, returns false for empty lines and comments
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// Escaped leading ! or #
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if _, err := path.Match(line, ""); err != nil || len(line) == 0 {
+		log.Warningf("Skipping invalid ignore pattern %s\n", line)
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// ignoreTree holds rules of ignore files in folders walked so far, folders are always walked before their contents
+type ignoreTree struct {
+	// Rules by folder, folders without ignore file have no rules
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreTree() *ignoreTree {
+	return &ignoreTree{rules: make(map[string][]ignoreRule)}
+}
+
+// load reads ignore file in folder when folder is walked
+func (tree *ignoreTree) load(dir string) error {
+	f, err := fsys.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		tree.rules[dir] = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	tree.rules[dir] = rules
+	return scanner.Err()
+}
+
+// ignored checks if path is ignored by rules of walked folders above it
+// Rules of deeper folders take precedence over rules of folders above them, and last matching rule in the same file wins
+func (tree *ignoreTree) ignored(filePath string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(filePath); ; dir = filepath.Dir(dir) {
+		if _, ok := tree.rules[dir]; !ok {
+			break
+		}
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		relPath, err := filepath.Rel(dirs[i], filePath)
+		if err != nil {
+			continue
+		}
+		relPath = filepath.ToSlash(relPath)
+		for _, rule := range tree.rules[dirs[i]] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			name := path.Base(relPath)
+			if rule.anchored {
+				name = relPath
+			}
+			if matched, _ := path.Match(rule.pattern, name); matched {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestScanHonorsIgnoreFiles(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem := useMemFilesystem(t)
+	files := map[string]string{
+		"/lib/.cleanerignore":              "# build output\n*.tmp\nbuild/\n/top.txt\n",
+		"/lib/top.txt":                     "ignored at top",
+		"/lib/photos/top.txt":              "anchored pattern only applies to top folder",
+		"/lib/photos/a.tmp":                "ignored in subfolder",
+		"/lib/photos/build/b.txt":          "ignored folder",
+		"/lib/photos/build.txt":            "folder pattern does not match files",
+		"/lib/photos/.cleanerignore":       "!keep.tmp\nraw/*.jpg\n",
+		"/lib/photos/keep.tmp":             "negated in subfolder",
+		"/lib/photos/raw/c.jpg":            "anchored to subfolder",
+		"/lib/photos/raw/nested/d.jpg":     "anchored pattern does not cross folders",
+		"/lib/other/keep.tmp":              "negation only applies to its folder",
+		"/lib/other/build/nested/file.txt": "ignored folder anywhere below",
+	}
+	for path, contents := range files {
+		mem.writeFile(path, contents, modified)
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{"/lib"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	var scanned []string
+	for path := range fh.files {
+		scanned = append(scanned, filepath.ToSlash(path))
+	}
+	sort.Strings(scanned)
+	expected := []string{"/lib/photos/build.txt", "/lib/photos/keep.tmp", "/lib/photos/raw/nested/d.jpg", "/lib/photos/top.txt"}
+	if !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("Expected %v to be scanned, got %v", expected, scanned)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+)
+
+// hashPendingImages calculates image hashes that were skipped by lazy scans
+// With LazyImageHashes only files whose size matches size of another recorded file are hashed, all pending files otherwise
+func hashPendingImages(fh *FileHashes, concurrency int) {
+	fh.lock.RLock()
+	sizes := make(map[int64]int)
+	var pending []*FileMetadata
+	for _, record := range fh.files {
+		sizes[record.Size]++
+		if record.ImageHashPending {
+			pending = append(pending, record)
+		}
+	}
+	fh.lock.RUnlock()
+	jobs := make(chan *FileMetadata)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range jobs {
+				hashPendingImage(fh, record)
+			}
+		}()
+	}
+	hashed := 0
+	for _, record := range pending {
+		if fh.options.LazyImageHashes && sizes[record.Size] < 2 {
+			continue
+		}
+		jobs <- record
+		hashed++
+	}
+	close(jobs)
+	wg.Wait()
+	if hashed > 0 {
+		log.Infof("Calculated %d pending image hashes\n", hashed)
+	}
+}
+
+func hashPendingImage(fh *FileHashes, record *FileMetadata) {
+	log.Debugf("Hashing pending image %s\n", record.Path)
+	updated := *record
+	updated.ImageHash, updated.Corrupt = getImageMetadata(record.Path, fh.options.ThumbnailsFolder)
+	updated.ImageHashPending = false
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+	if fh.files[record.Path] != record {
+		// Record was replaced while image was hashed
+		return
+	}
+	removeRecord(fh, record)
+	addRecord(fh, &updated)
+	addFileToDB(fh, &updated)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Policies of moving halves of Live Photos
+const (
+	// LivePhotosOff moves halves of Live Photos like any other file
+	LivePhotosOff = "off"
+	// LivePhotosKeep only moves half of Live Photo when its pair is moved as well
+	LivePhotosKeep = "keep"
+	// LivePhotosMove moves pair of Live Photo half along with it
+	LivePhotosMove = "move"
+)
+
+// DefaultLivePhotoExtensions pairs still images with videos of same name, e.g. IMG_0001.HEIC and IMG_0001.MOV
+const DefaultLivePhotoExtensions = "heic,jpg,jpeg:mov"
+
+// LivePhotoPairing lists extensions of still images and videos that make Live Photo when they have same name in same folder
+type LivePhotoPairing struct {
+	ImageExtensions []string
+	VideoExtensions []string
+}
+
+// ParseLivePhotoExtensions parses comma separated image and video extensions separated by colon, e.g. heic,jpg:mov
+func ParseLivePhotoExtensions(value string) (LivePhotoPairing, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return LivePhotoPairing{}, fmt.Errorf("Invalid Live Photo extensions %s, expected images:videos", value)
+	}
+	pairing := LivePhotoPairing{ImageExtensions: splitExtensions(parts[0]), VideoExtensions: splitExtensions(parts[1])}
+	if len(pairing.ImageExtensions) == 0 || len(pairing.VideoExtensions) == 0 {
+		return LivePhotoPairing{}, fmt.Errorf("Invalid Live Photo extensions %s, both image and video extensions are required", value)
+	}
+	return pairing, nil
+}
+
+// splitExtensions splits comma separated extensions and normalizes them to lower case with leading dot
+func splitExtensions(value string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if len(ext) > 0 {
+			extensions = append(extensions, "."+ext)
+		}
+	}
+	return extensions
+}
+
+// hasExtension checks if path has one of extensions regardless of case
+func hasExtension(path string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, candidate := range extensions {
+		if ext == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// getLivePhotoPair returns existing other half of Live Photo that path belongs to
+func getLivePhotoPair(path string, pairing LivePhotoPairing) (string, bool) {
+	var candidates []string
+	if hasExtension(path, pairing.ImageExtensions) {
+		candidates = pairing.VideoExtensions
+	} else if hasExtension(path, pairing.VideoExtensions) {
+		candidates = pairing.ImageExtensions
+	}
+	return findCompanion(path, candidates)
+}
+
+// findCompanion returns first existing file with same name as path and one of extensions, extensions are tried in lower and upper case
+func findCompanion(path string, extensions []string) (string, bool) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range extensions {
+		for _, candidate := range []string{base + ext, base + strings.ToUpper(ext)} {
+			if f, err := fsys.Stat(candidate); err == nil && !f.IsDir() {
+				return candidate, true
+			}
+		}
+	}
+	return "", false
+}
+
+// getCompanionDestination returns destination of companion file next to destination of file it belongs to, so that they keep same name
+func getCompanionDestination(newPath string, companion string) string {
+	return strings.TrimSuffix(newPath, filepath.Ext(newPath)) + filepath.Ext(companion)
+}
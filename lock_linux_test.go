@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIsLockedError(t *testing.T) {
+	for _, errno := range []error{unix.EACCES, unix.EAGAIN} {
+		if err := (&os.PathError{Op: "open", Path: "/a", Err: errno}); !isLockedError(err) {
+			t.Errorf("Expected %v to be locked error", err)
+		}
+	}
+	if _, err := os.Open("/does/not/exist"); isLockedError(err) {
+		t.Errorf("Expected %v to not be locked error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// rotatingFile is log file that is rotated when it would grow over maxSize, keeping up to keep older files
+// Older files are named after log file with number appended, e.g. cleaner.log.1 is the most recent one
+type rotatingFile struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts older files by one, dropping the oldest one, and starts new log file
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	for i := r.keep; i > 0; i-- {
+		older := fmt.Sprintf("%s.%d", r.path, i)
+		newer := r.path
+		if i > 1 {
+			newer = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		if i == r.keep {
+			if err := os.Remove(older); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(newer, older); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if r.keep == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return r.open()
+}
+
+// jsonEntry is log entry written by jsonBackend
+type jsonEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module"`
+	Message string    `json:"message"`
+}
+
+// jsonBackend writes log entries as JSON objects, one per line, e.g. for log aggregators
+type jsonBackend struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, record *logging.Record) error {
+	line, err := json.Marshal(jsonEntry{Time: record.Time, Level: level.String(), Module: record.Module, Message: strings.TrimSuffix(record.Message(), "\n")})
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, err = b.out.Write(append(line, '\n'))
+	return err
+}
+
+// setLogBackend sends log into out in specified format, text or json
+func setLogBackend(out io.Writer, format string) error {
+	switch format {
+	case "text":
+		logging.SetBackend(logging.NewLogBackend(out, "", stdlog.LstdFlags))
+	case "json":
+		logging.SetBackend(&jsonBackend{out: out})
+	default:
+		return fmt.Errorf("Unknown log format %s", format)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cleaner.log")
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		if contents, err := ioutil.ReadFile(path); err != nil || string(contents) != expected {
+			t.Errorf("Expected %q in %s, got %q: %v", expected, path, contents, err)
+		}
+	}
+	assertNotExists(t, path+".3")
+}
+
+func TestJSONLogBackend(t *testing.T) {
+	var out bytes.Buffer
+	if err := setLogBackend(&out, "json"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLogBackend(os.Stderr, "text")
+	logging.SetLevel(logging.INFO, "cleaner")
+	log.Infof("Processing %s\n", "/photos/a.jpg")
+	entry := jsonEntry{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected JSON entry, got %q: %v", out.String(), err)
+	}
+	if entry.Level != "INFO" || entry.Module != "cleaner" || entry.Message != "Processing /photos/a.jpg" || entry.Time.IsZero() {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if err := setLogBackend(&out, "xml"); err == nil {
+		t.Error("Expected unknown log format to fail")
+	}
+}
@@ -1,16 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	logging "github.com/op/go-logging"
 )
 
 var log = logging.MustGetLogger("cleaner")
 
+// stopProfiling writes profiles, it is also called on fatal errors since os.Exit skips deferred calls
+var stopProfiling = func() {}
+
+func fatal(args ...interface{}) {
+	stopProfiling()
+	log.Fatal(args...)
+}
+
+func fatalf(format string, args ...interface{}) {
+	stopProfiling()
+	log.Fatalf(format, args...)
+}
+
 func main() {
 	var dbFile string
 	var compactDB bool
+	var checkDB bool
 	var folderToScanForDuplicates string
 	var folderToScanForMasters string
 	var verbose bool
@@ -19,19 +41,237 @@ func main() {
 	var searchForDuplicates bool
 	var removePrefix string
 	var applyMove bool
+	var readOnlyMasters bool
+	var renameByDate bool
 	var concurrency int
+	var folderReport bool
+	var groupDepth int
+	var snapshotDB string
+	var masterAge string
+	var preferSmaller bool
+	var masterOrder string
+	var preserveNewestModified bool
+	var timeTolerance time.Duration
+	var protect repeatedFlag
+	var execCommand string
+	var thumbnails bool
+	var htmlReport string
+	var watch bool
+	var watchDups bool
+	var serveAddr string
+	var compareFolders bool
+	var uniqueTo string
+	var exportChecksums string
+	var checksumsRoot string
+	var importChecksums string
+	var listingFormat string
+	var print0 bool
+	var limit int
+	var resumable bool
+	var countOnly bool
+	var rehashTouched bool
+	var scanOnly bool
+	var autoCompact float64
+	var dbRoot string
+	var remap string
+	var excludeHidden bool
+	var sameFileCheck bool
+	var perDirectory bool
+	var maxRuntime time.Duration
+	var cpuProfile string
+	var memProfile string
+	var yes bool
+	var audioMatches bool
+	var skipUnchangedDirs bool
+	var reportEmptyDirs bool
+	var removeEmptyDirs bool
+	var minFree string
+	var scanArchives bool
+	var normalizePaths bool
+	var moveMatches string
+	var dateTagsOrder string
+	var filenameDates bool
+	var filenameDateLayouts string
+	var filesystemDates bool
+	var listMasters bool
+	var ignoreEmpty bool
+	var matchEmpty bool
+	var progressInterval time.Duration
+	var maxOpenTime time.Duration
+	var reportCorrupt bool
+	var canonicalCopy string
+	var otherDBs string
+	var quarantine bool
+	var hashBuffer string
+	var lazyImageHashes bool
+	var moveTemplate string
+	var reportLargest int
+	var dedupePreview bool
+	var errorsReport string
+	var hashSymlinks bool
+	var reportBrokenLinks bool
+	var logFile string
+	var logMaxSize string
+	var logKeep int
+	var logFormat string
+	var reindex bool
+	var simulate bool
+	var newOnly bool
+	var compareHashOnly bool
+	var chunkedHash string
+	var reportPartial string
+	var reportVideos bool
+	var reportLongest int
+	var reportIdenticalFolders bool
+	var subsetFolders bool
+	var subsetMinOverlap float64
+	var videoSizeTolerance float64
+	var verifyDB bool
+	var include string
+	var exclude string
+	var includeFrom string
+	var excludeFrom string
+	var sameExtension bool
+	var autoConcurrency bool
+	var livePhotos string
+	var livePhotoExtensions string
+	var sidecars string
+	var sidecarExtensions string
+	var scriptPath string
+	var scriptShell string
 	flag.StringVar(&dbFile, "db", "cache.txt", "Database file path, default value is cache.txt")
 	flag.BoolVar(&compactDB, "compact", false, "Compact database (remove deleted and changed records)")
+	flag.BoolVar(&checkDB, "fsck", false, "Verify database hash index and rebuild it if inconsistent")
 	flag.StringVar(&folderToScanForDuplicates, "duplicates", "", "Search duplicates in specified folder from database, implies -dups")
 	flag.StringVar(&folderToScanForMasters, "masters", "", "Search duplicates with masters in specified folder from database (use same path in -duplicates to only look inside specified path), implies -dups")
 	flag.StringVar(&moveDuplicatesTo, "move", "", "Move duplicates into specified folder preserving their relative paths, does not move files without -apply, implies -dups")
 	flag.StringVar(&removePrefix, "prefix", "", "Prefix to remove when moving duplicates")
 	flag.BoolVar(&searchForDuplicates, "dups", false, "Scan for duplicates")
-	flag.BoolVar(&applyMove, "apply", false, "Move duplicate files into destination directory")
+	flag.Var(&protect, "protect", "Never treat files in specified folder as duplicates, they are always picked as masters and never moved, can be passed more than once")
+	flag.BoolVar(&readOnlyMasters, "readonly-masters", false, "Fail if any file inside -masters folder would be moved or overwritten")
+	flag.BoolVar(&renameByDate, "rename-by-date", false, "Name moved duplicates after their shooting date (e.g. 2017-06-03_13-02-08.jpg) when it is known, duplicates shot at same second get numbered suffix (e.g. 2017-06-03_13-02-08_1.jpg)")
+	flag.BoolVar(&applyMove, "apply", false, "Move duplicate files into destination directory, run -exec commands and copy files with -canonical-copy")
 	flag.BoolVar(&silent, "silent", false, "Supress non-error logging")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
-	flag.IntVar(&concurrency, "concurrency", 2, "Parser concurrency, default is 2.")
+	flag.IntVar(&concurrency, "concurrency", 2, "Parser and duplicate search concurrency, default is 2.")
+	flag.BoolVar(&folderReport, "folder-report", false, "Print reclaimable space per folder sorted by size, implies -dups")
+	flag.IntVar(&groupDepth, "group-depth", 1, "Number of path elements below duplicates folder (or volume root) used to group -folder-report, default is 1")
+	flag.StringVar(&snapshotDB, "diff-db", "", "Only report duplicate groups with files added or changed since specified database snapshot, files missing from snapshot are added when first seen after its latest record, implies -dups")
+	flag.StringVar(&masterAge, "master-age", "oldest", "Prefer oldest or newest files as masters when comparing shooting, modification and creation dates, default is oldest")
+	flag.BoolVar(&preferSmaller, "prefer-smaller", false, "Prefer smaller files as masters instead of larger ones")
+	flag.BoolVar(&preserveNewestModified, "preserve-newest-modified", false, "Prefer files with latest modification time as masters (e.g. most recently synced copy) regardless of -master-age")
+	flag.DurationVar(&timeTolerance, "time-tolerance", 2*time.Second, "Treat shooting, modification and creation times that differ by at most specified duration as equal when picking masters, so that next rule decides")
+	flag.StringVar(&masterOrder, "master-order", strings.Join(DefaultMasterOrder, ","), "Comma separated order of master rules applied after folder rules, unlisted rules are applied afterwards")
+	flag.StringVar(&execCommand, "exec", "", "Run command for each duplicate replacing {master} and {duplicate} with file paths, commands are only printed without -apply, implies -dups")
+	flag.BoolVar(&thumbnails, "thumbnails", false, "Generate thumbnails for scanned images and cache them next to database")
+	flag.StringVar(&htmlReport, "html", "", "Write HTML report with thumbnails of duplicates to specified file, implies -dups")
+	flag.BoolVar(&watch, "watch", false, "Keep watching scanned folders after initial scan and update database as files change")
+	flag.BoolVar(&watchDups, "watch-dups", false, "Print duplicates of new and changed files in -watch mode")
+	flag.StringVar(&serveAddr, "serve", "", "Serve HTTP API for scans, duplicate queries and moves on specified address (e.g. localhost:8080)")
+	flag.BoolVar(&compareFolders, "compare", false, "Scan two folders given as arguments and report which files from second folder already exist in first one")
+	flag.StringVar(&uniqueTo, "unique-to", "", "List files in specified folder that have no copies (including image matches) anywhere else in database")
+	flag.StringVar(&exportChecksums, "export-checksums", "", "Write file hashes to specified manifest in sha1sum format")
+	flag.StringVar(&importChecksums, "import-checksums", "", "Add file hashes from specified sha1sum manifest to database without reading files")
+	flag.StringVar(&checksumsRoot, "checksums-root", "", "Folder that relative paths in checksums manifests are relative to, absolute paths are exported when not specified and current folder is used for import")
+	flag.StringVar(&listingFormat, "format", "default", "Format of duplicates listing: default, tabbed (kind, path and master separated by tabs), grouped (blank line separated groups with master first and match type before each path), null (only duplicate paths terminated by NUL for xargs -0) or none")
+	flag.BoolVar(&print0, "print0", false, "Print only duplicate paths terminated by NUL for piping to xargs -0, same as -format null, implies -dups")
+	flag.IntVar(&limit, "limit", 0, "Stop after finding specified number of duplicate groups for quick preview, groups are an arbitrary subset of all duplicates")
+	flag.BoolVar(&resumable, "resumable", false, "Save duplicate search progress next to database and resume interrupted search, groups found before interruption are not reported again")
+	flag.BoolVar(&countOnly, "count", false, "Print number of duplicate groups, duplicates and reclaimable bytes, implies -dups")
+	flag.BoolVar(&rehashTouched, "rehash-touched", false, "Rehash files that only had timestamps changed and keep their metadata when contents are the same")
+	flag.BoolVar(&scanOnly, "scan-only", false, "Only scan folders given as arguments and update database, fail if any duplicate search, move or report is requested")
+	flag.Float64Var(&autoCompact, "auto-compact", 0, "Compact database after scan when outdated records exceed specified percentage of current records (e.g. 25), disabled when 0")
+	flag.StringVar(&dbRoot, "db-root", "", "Store paths under specified folder relative to it in database, so that database can be used when folder is moved; use with -compact to convert existing absolute paths")
+	flag.StringVar(&remap, "remap", "", "Replace path prefix of database records in old=new format (e.g. /mnt/nas/photos=/Volumes/photos) when database was built where files were mounted elsewhere")
+	flag.BoolVar(&excludeHidden, "exclude-hidden", false, "Skip files and folders whose names start with dot or that have hidden attribute when scanning")
+	flag.BoolVar(&sameFileCheck, "dereference-check", false, "Do not report files that are same physical file (same device and inode) reached through another mount point or hard link as duplicates")
+	flag.BoolVar(&perDirectory, "keep-one-per-directory", false, "Only treat files in same directory as duplicates, so that one copy of each file is kept in every directory, implies -dups")
+	flag.DurationVar(&maxRuntime, "max-runtime", 0, "Stop scanning after specified duration (e.g. 2h), files that were not scanned yet are scanned on next run, unlimited when 0")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "Write CPU profile to specified file")
+	flag.StringVar(&memProfile, "memprofile", "", "Write memory profile to specified file before exit")
+	flag.BoolVar(&yes, "yes", false, "Do not ask for confirmation before moving duplicates, removing empty directories or running -exec commands with -apply, required when input is not a terminal and to apply moves requested over -serve")
+	flag.BoolVar(&audioMatches, "audio", false, "Fingerprint audio and video files with fpcalc (Chromaprint) when scanning and list files with similar audio, audio matches are never moved, implies -dups")
+	flag.BoolVar(&skipUnchangedDirs, "rescan-changed-only", false, "Only check files in directories that had files added, removed or renamed since previous scan, files modified in place are not detected")
+	flag.BoolVar(&reportEmptyDirs, "report-empty-dirs", false, "List directories left empty after moving duplicates with -move")
+	flag.BoolVar(&removeEmptyDirs, "remove-empty-dirs", false, "Remove directories left empty after moving duplicates with -move and -apply, directories that were empty before are kept; without -move remove all directories without files inside folders given as arguments, directories are only printed without -apply")
+	flag.StringVar(&minFree, "min-free", "", "Stop moving duplicates before free space at -move destination drops below specified size (e.g. 10G or 500MiB)")
+	flag.BoolVar(&scanArchives, "scan-archives", false, "Scan files inside zip archives, so that archived copies are reported as duplicates; files inside archives are never moved")
+	flag.BoolVar(&normalizePaths, "normalize-paths", false, "Rewrite database with normalized paths (without redundant separators, . and .. elements), same as -compact")
+	flag.StringVar(&moveMatches, "move-matches", "pixel", "Least trusted match type moved with -move: strict (byte-identical files only) or pixel (also images with identical pixels but different metadata), default is pixel")
+	flag.StringVar(&dateTagsOrder, "date-tags", strings.Join(DefaultDateTags, ","), "Comma separated priority of EXIF dates used as shooting date: original, digitized, datetime and gps (UTC time of GPS fix), unlisted dates are not used")
+	flag.BoolVar(&filenameDates, "filename-dates", false, "Use date from file name (e.g. IMG_20230704_123000.jpg) as shooting date when file has no EXIF or moov date")
+	flag.StringVar(&filenameDateLayouts, "filename-date-layouts", strings.Join(DefaultFilenameDateLayouts, ","), "Comma separated Go time layouts of dates in file names tried in order with -filename-dates")
+	flag.BoolVar(&filesystemDates, "trust-filesystem-dates", false, "Use earlier of file creation and modification time as shooting date when file has no EXIF, moov or file name date")
+	flag.BoolVar(&listMasters, "list-masters", false, "Only print files that are kept after moving duplicates (masters, unique files and duplicates not moved per -move-matches) instead of duplicates, implies -dups")
+	flag.BoolVar(&ignoreEmpty, "ignore-empty", false, "Do not record empty files, so that they are not listed by -list-masters, -unique-to or -compare; empty files are not reported as duplicates without -match-empty either way")
+	flag.BoolVar(&matchEmpty, "match-empty", false, "Report empty files as duplicates of each other, they are skipped by default since all of them have same hash")
+	flag.DurationVar(&progressInterval, "progress-interval", 0, "Log summary of scan progress (files done, rate and ETA) at specified interval (e.g. 1m) instead of every processed file, per file lines are still logged with -verbose")
+	flag.BoolVar(&reportCorrupt, "report-corrupt", false, "Print images that could not be fully decoded (e.g. truncated by interrupted download) or whose size does not match their header")
+	flag.StringVar(&canonicalCopy, "canonical-copy", "", "Copy one file of each content (masters and unique files) into specified folder preserving relative paths and leaving originals in place, copies are verified by hash and ones left by previous run are skipped, does not copy files without -apply, implies -dups")
+	flag.StringVar(&otherDBs, "find-in-dbs", "", "Comma separated databases (e.g. caches of offline drives) to read without modifying or merging them, files with copies in them are printed along with database and path of each copy, only files inside -duplicates folder are checked when it is specified")
+	flag.BoolVar(&quarantine, "quarantine", false, "Leave symlink to master in place of each duplicate moved with -move, so that paths keep working while original bytes are kept in destination folder")
+	flag.DurationVar(&maxOpenTime, "max-open-time", 0, "Abandon file that takes longer than specified duration (e.g. 30s) to parse and record it as failed, so that scan of stale network mount does not hang, files are parsed without timeout by default")
+	flag.StringVar(&hashBuffer, "hash-buffer", "1M", "Size of buffer files are read with while hashing (e.g. 256K or 4M), larger buffer speeds up hashing of large files on fast storage")
+	flag.BoolVar(&lazyImageHashes, "only-duplicated-hashes", false, "Only calculate image hashes of files whose size matches size of another file, which speeds up scans, but misses image matches of different size (e.g. with edited metadata)")
+	flag.StringVar(&moveTemplate, "move-template", "", "Template of paths inside -move or -canonical-copy folder, e.g. {year}/{month}/{basename}, with placeholders {year}, {month} and {day} of shooting date (unknown when it is not known), {dir} (relative folder), {basename}, {name} (without extension), {ext} and {hash}")
+	flag.BoolVar(&dedupePreview, "dedupe-preview", false, "Print which file of each duplicate group is kept and why it was picked over each of duplicates, implies -dups")
+	flag.IntVar(&reportLargest, "report-largest", 0, "Print specified number of largest duplicates with their masters and reclaimable space, implies -dups")
+	flag.StringVar(&errorsReport, "errors-report", "", "Write files that could not be read while scanning (e.g. locked by other processes) into specified file with reason separated by tab, they are retried on next scan")
+	flag.BoolVar(&hashSymlinks, "hash-symlinks", false, "Record symlinks to files as references to their targets, they are never reported as duplicates of their targets; symlinks are skipped by default")
+	flag.BoolVar(&reportBrokenLinks, "report-broken-links", false, "Print symlinks whose targets do not exist found while scanning folders, they are never hashed")
+	flag.StringVar(&logFile, "log-file", "", "Write log into specified file instead of standard error, file is rotated when it grows over -log-max-size")
+	flag.StringVar(&logMaxSize, "log-max-size", "10M", "Size of -log-file after which it is rotated (e.g. 1M or 100MiB), never rotated when 0")
+	flag.IntVar(&logKeep, "log-keep", 5, "Number of rotated log files to keep next to -log-file, named with number appended (e.g. cleaner.log.1)")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of log entries: text or json (one object per line with time, level, module and message)")
+	flag.BoolVar(&reindex, "reindex", false, "Rebuild database from scratch by hashing files at all recorded paths and in specified folders again, records of missing files are dropped")
+	flag.BoolVar(&newOnly, "new-only", false, "Only parse files that are not recorded in database yet and trust existing records without checking files, changed and removed files are not detected")
+	flag.BoolVar(&simulate, "simulate", false, "Search duplicates among records cached in database without checking files or scanning, so that master rules can be tuned quickly; nothing is moved, copied or run")
+	flag.BoolVar(&compareHashOnly, "compare-hash-only", false, "Only report byte-identical files as duplicates, pixel matches are ignored without rescanning, implies -dups")
+	flag.StringVar(&chunkedHash, "chunked-hash", "", "Also hash chunks of specified size (e.g. 4M) of scanned files, so that files sharing part of contents can be found with -report-partial")
+	flag.BoolVar(&reportVideos, "report-similar-videos", false, "Print groups of videos with same duration and similar size as likely duplicates for manual review, they are never moved")
+	flag.IntVar(&reportLongest, "report-longest", 0, "Print specified number of longest videos with their duration and size")
+	flag.BoolVar(&reportIdenticalFolders, "report-identical-folders", false, "Print groups of folders with same file names and contents in all their subfolders, along with their total size, folders holding files that are not recorded in database are left out")
+	flag.BoolVar(&subsetFolders, "subset-folders", false, "Print folders whose files are found in other folder, along with share of files found there")
+	flag.Float64Var(&subsetMinOverlap, "subset-min-overlap", 50, "Minimum share in percent of files of folder found in other folder for it to be printed by -subset-folders, 100 prints only folders entirely contained in other folder")
+	flag.Float64Var(&videoSizeTolerance, "video-size-tolerance", 5, "Maximum difference in percent between sizes of videos grouped by -report-similar-videos")
+	flag.StringVar(&reportPartial, "report-partial", "", "Print pairs of different files sharing at least specified size (e.g. 100M) of contents, e.g. truncated copies, only files scanned with -chunked-hash are compared")
+	flag.BoolVar(&verifyDB, "verify-db", false, "Only check that every recorded file exists with recorded size and modification time without reading files or modifying database, and print records that do not match")
+	flag.StringVar(&include, "include", "", "Comma separated patterns of files to scan (e.g. *.jpg,*.mov), other files are skipped; globs without separator match file names, globs with it match full paths and patterns starting with re: are regular expressions matched against full paths")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated patterns of files and folders to skip when scanning (e.g. node_modules,*.tmp), in same format as -include, exclude patterns take precedence")
+	flag.StringVar(&includeFrom, "include-from", "", "Read -include patterns from specified file with one pattern per line, lines starting with # are ignored")
+	flag.StringVar(&excludeFrom, "exclude-from", "", "Read -exclude patterns from specified file with one pattern per line, lines starting with # are ignored")
+	flag.BoolVar(&sameExtension, "same-extension-only", false, "Only report files with same extension (regardless of case) as duplicates, so that e.g. copies in different formats are kept, implies -dups")
+	flag.BoolVar(&autoConcurrency, "auto-concurrency", false, "Pick scan concurrency by detected storage of scanned folders (solid state drive, rotational disk or network share), -concurrency takes precedence when it is passed")
+	flag.StringVar(&livePhotos, "live-photos", LivePhotosKeep, "Handling of Live Photos (image and video with same name in same folder) moved with -move: keep (do not move half of Live Photo unless its pair is moved too), move (move pair along) or off (move halves like other files)")
+	flag.StringVar(&livePhotoExtensions, "live-photo-extensions", DefaultLivePhotoExtensions, "Comma separated extensions of images and videos paired as Live Photos separated by colon")
+	flag.StringVar(&sidecars, "sidecars", SidecarsMove, "Handling of sidecar files (e.g. IMG_0001.xmp or IMG_0001.JPG.xmp) of media moved with -move: move (move sidecar along), leave (leave it in place) or delete")
+	flag.StringVar(&sidecarExtensions, "sidecar-extensions", DefaultSidecarExtensions, "Comma separated extensions of sidecar files")
+	flag.StringVar(&scriptPath, "script", "", "Write commands that move duplicates with -move into specified script file instead of moving them")
+	flag.StringVar(&scriptShell, "script-shell", defaultScriptShell(), "Shell of script written with -script: sh or powershell")
 	flag.Parse()
+	if len(logFile) > 0 || logFormat != "text" {
+		// Backend has to be set before level, since setting backend resets levels
+		var out io.Writer = os.Stderr
+		if len(logFile) > 0 {
+			path, err := ExpandHome(logFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			maxSize, err := parseSize(logMaxSize)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if logKeep < 0 {
+				log.Fatal("-log-keep can not be negative")
+			}
+			if out, err = openRotatingFile(path, maxSize, logKeep); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := setLogBackend(out, logFormat); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if silent {
 		logging.SetLevel(logging.WARNING, "cleaner")
 	} else if verbose {
@@ -39,31 +279,526 @@ func main() {
 	} else {
 		logging.SetLevel(logging.INFO, "cleaner")
 	}
-	fh, err := ReadDB(dbFile, compactDB)
+	// Expand ~ in path flags and braces and globs in folder arguments, since they are not expanded when not started from shell
+	for _, path := range []*string{&dbFile, &folderToScanForDuplicates, &folderToScanForMasters, &moveDuplicatesTo, &canonicalCopy, &removePrefix, &snapshotDB, &errorsReport, &htmlReport, &uniqueTo, &exportChecksums, &importChecksums, &checksumsRoot, &dbRoot, &includeFrom, &excludeFrom, &cpuProfile, &memProfile, &scriptPath} {
+		expanded, err := ExpandHome(*path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*path = expanded
+	}
+	for i := range protect {
+		expanded, err := ExpandHome(protect[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+		protect[i] = expanded
+	}
+	folders, err := ExpandPaths(flag.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
-	if len(flag.Args()) > 0 {
-		if err := ScanFolders(flag.Args(), fh, concurrency); err != nil {
-			log.Fatal(err)
+	stop, err := StartProfiling(cpuProfile, memProfile)
+	if err != nil {
+		fatal(err)
+	}
+	stopProfiling = stop
+	defer stopProfiling()
+	if watch && len(folders) == 0 {
+		fatal("-watch requires folders to scan")
+	}
+	for _, folder := range folders {
+		if watch && isRemotePath(folder) {
+			fatalf("-watch can not watch remote folder %s", folder)
+		}
+	}
+	if compareFolders && len(folders) != 2 {
+		fatal("-compare requires two folders")
+	}
+	if watch && len(serveAddr) > 0 {
+		fatal("-watch and -serve can not be used together")
+	}
+	if ignoreEmpty && matchEmpty {
+		fatal("-ignore-empty and -match-empty can not be used together")
+	}
+	if reportBrokenLinks && len(folders) == 0 {
+		fatal("-report-broken-links requires folders to scan")
+	}
+	if quarantine && len(moveDuplicatesTo) == 0 && len(serveAddr) == 0 {
+		fatal("-quarantine requires -move or -serve")
+	}
+	if len(scriptPath) > 0 && len(moveDuplicatesTo) == 0 {
+		fatal("-script requires -move")
+	}
+	if len(scriptPath) > 0 && applyMove {
+		fatal("-script can not be used with -apply")
+	}
+	if readOnlyMasters && len(folderToScanForMasters) == 0 {
+		fatal("-readonly-masters requires -masters")
+	}
+	if simulate && (len(folders) > 0 || applyMove || removeEmptyDirs || compactDB || normalizePaths || autoCompact > 0 || checkDB || reindex || scanOnly || len(importChecksums) > 0 || watch || len(serveAddr) > 0) {
+		fatal("-simulate can not be used with -apply, folders to scan or options that modify database")
+	}
+	if newOnly && (applyMove || simulate || verifyDB || reindex) {
+		fatal("-new-only can not be used with -apply, -simulate, -verify-db or -reindex")
+	}
+	if verifyDB && (simulate || len(folders) > 0 || compactDB || normalizePaths || autoCompact > 0 || checkDB || reindex || scanOnly || len(importChecksums) > 0 || watch || len(serveAddr) > 0) {
+		fatal("-verify-db can not be used with -simulate, folders to scan or options that modify database")
+	}
+	if scanOnly {
+		if len(folders) == 0 && !reindex {
+			fatal("-scan-only requires folders to scan")
+		}
+		if searchForDuplicates || compareHashOnly || sameExtension || perDirectory || audioMatches || listMasters || print0 || countOnly || len(folderToScanForDuplicates) > 0 || len(folderToScanForMasters) > 0 || len(moveDuplicatesTo) > 0 || len(canonicalCopy) > 0 || folderReport || reportLargest > 0 || dedupePreview || len(snapshotDB) > 0 || len(execCommand) > 0 || len(htmlReport) > 0 || compareFolders || len(uniqueTo) > 0 || len(otherDBs) > 0 || watch || len(serveAddr) > 0 {
+			fatal("-scan-only can not be used with duplicate search, move, reports, -watch or -serve")
+		}
+	}
+	if removeEmptyDirs && len(moveDuplicatesTo) == 0 && len(serveAddr) == 0 {
+		// Standalone maintenance mode that does not need database
+		if len(folders) == 0 {
+			fatal("-remove-empty-dirs requires -move or folders to clean up")
+		}
+		if applyMove {
+			// Directories are found without removing them first, so that removal can be confirmed
+			found, err := RemoveEmptyDirs(folders, false)
+			if err != nil {
+				fatal(err)
+			}
+			if found == 0 {
+				fmt.Printf("* Removed 0 empty directories\n")
+				return
+			}
+			if err := confirmAction(fmt.Sprintf("About to remove %d empty directories, proceed?", found), yes); err != nil {
+				fatal(err)
+			}
+		}
+		removed, err := RemoveEmptyDirs(folders, applyMove)
+		if err != nil {
+			fatal(err)
 		}
+		if applyMove {
+			fmt.Printf("* Removed %d empty directories\n", removed)
+		} else {
+			fmt.Printf("* Found %d empty directories, use -apply to remove them\n", removed)
+		}
+		return
+	}
+	if print0 {
+		if listingFormat != "default" && listingFormat != "null" {
+			fatal("-print0 can not be used with -format")
+		}
+		if folderReport || reportLargest > 0 || dedupePreview || len(snapshotDB) > 0 || compareFolders || len(uniqueTo) > 0 || len(otherDBs) > 0 || len(moveDuplicatesTo) > 0 || len(canonicalCopy) > 0 || len(execCommand) > 0 || countOnly {
+			fatal("-print0 can not be used with options that print to standard output")
+		}
+		listingFormat = "null"
+	}
+	listing, ok := ListingFormats[listingFormat]
+	if !ok {
+		fatalf("Unknown -format value %s", listingFormat)
+	}
+	order, err := ParseMasterOrder(masterOrder)
+	if err != nil {
+		fatal(err)
+	}
+	if timeTolerance < 0 {
+		fatal("-time-tolerance has to be positive")
+	}
+	protected, err := NewProtectedPaths(protect)
+	if err != nil {
+		fatal(err)
+	}
+	policy := MasterPolicy{PreferSmaller: preferSmaller, PreferNewestModified: preserveNewestModified, Order: order, Protected: protected, TimeTolerance: timeTolerance}
+	switch masterAge {
+	case "oldest":
+	case "newest":
+		policy.PreferNewest = true
+	default:
+		fatalf("Unknown -master-age value %s", masterAge)
+	}
+	strictMoves := false
+	switch moveMatches {
+	case "strict":
+		strictMoves = true
+	case "pixel":
+	default:
+		fatalf("Unknown -move-matches value %s", moveMatches)
+	}
+	switch livePhotos {
+	case LivePhotosKeep, LivePhotosMove, LivePhotosOff:
+	default:
+		fatalf("Unknown -live-photos value %s", livePhotos)
+	}
+	livePhotoPairing, err := ParseLivePhotoExtensions(livePhotoExtensions)
+	if err != nil {
+		fatal(err)
+	}
+	switch sidecars {
+	case SidecarsMove, SidecarsLeave, SidecarsDelete:
+	default:
+		fatalf("Unknown -sidecars value %s", sidecars)
+	}
+	if subsetMinOverlap <= 0 || subsetMinOverlap > 100 {
+		fatal("-subset-min-overlap has to be between 0 and 100")
+	}
+	if reportLongest < 0 {
+		fatal("-report-longest has to be positive")
 	}
-	if searchForDuplicates || len(folderToScanForDuplicates) > 0 || len(folderToScanForMasters) > 0 || len(moveDuplicatesTo) > 0 {
-		dups, err := FindDuplicates(folderToScanForDuplicates, folderToScanForMasters, fh)
+	if reportLargest < 0 {
+		fatal("-report-largest has to be positive")
+	}
+	if err := ValidateMoveTemplate(moveTemplate); err != nil {
+		fatal(err)
+	}
+	if maxOpenTime < 0 {
+		fatal("-max-open-time has to be positive")
+	}
+	bufferSize, err := parseSize(hashBuffer)
+	if err != nil {
+		fatal(err)
+	}
+	if bufferSize <= 0 {
+		fatal("-hash-buffer has to be positive")
+	}
+	SetHashBufferSize(int(bufferSize))
+	var minFreeSpace int64
+	if len(minFree) > 0 {
+		if minFreeSpace, err = parseSize(minFree); err != nil {
+			fatal(err)
+		}
+	}
+	dateTags, err := ParseDateTags(dateTagsOrder)
+	if err != nil {
+		fatal(err)
+	}
+	var layouts []FilenameDateLayout
+	if filenameDates {
+		if layouts, err = ParseFilenameDateLayouts(filenameDateLayouts); err != nil {
+			fatal(err)
+		}
+	}
+	var chunkSize int64
+	if len(chunkedHash) > 0 {
+		if chunkSize, err = parseSize(chunkedHash); err != nil {
+			fatal(err)
+		}
+		if chunkSize <= 0 {
+			fatal("-chunked-hash has to be positive")
+		}
+	}
+	if videoSizeTolerance < 0 {
+		fatal("-video-size-tolerance has to be positive")
+	}
+	var minPartialSize int64
+	if len(reportPartial) > 0 {
+		if minPartialSize, err = parseSize(reportPartial); err != nil {
+			fatal(err)
+		}
+	}
+	includePatterns, excludePatterns := SplitPatterns(include), SplitPatterns(exclude)
+	if len(includeFrom) > 0 {
+		patterns, err := ReadPatternsFile(includeFrom)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		if len(moveDuplicatesTo) > 0 && len(dups) > 0 {
-			moved, err := MoveDuplicates(moveDuplicatesTo, removePrefix, dups, fh, applyMove)
+		includePatterns = append(includePatterns, patterns...)
+	}
+	if len(excludeFrom) > 0 {
+		patterns, err := ReadPatternsFile(excludeFrom)
+		if err != nil {
+			fatal(err)
+		}
+		excludePatterns = append(excludePatterns, patterns...)
+	}
+	parseOpts := ParseOptions{RehashTouched: rehashTouched, ExcludeHidden: excludeHidden, AudioFingerprints: audioMatches, SkipUnchangedDirs: skipUnchangedDirs, ScanArchives: scanArchives, DateTags: dateTags, FilenameDates: layouts, FilesystemDates: filesystemDates, IgnoreEmpty: ignoreEmpty, MatchEmpty: matchEmpty, ProgressInterval: progressInterval, LazyImageHashes: lazyImageHashes, HashSymlinks: hashSymlinks, ChunkSize: chunkSize, FileTimeout: maxOpenTime, NewOnly: newOnly}
+	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		if parseOpts.Filter, err = NewPathFilter(includePatterns, excludePatterns); err != nil {
+			fatal(err)
+		}
+	}
+	if len(remap) > 0 {
+		parts := strings.SplitN(remap, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			fatalf("Invalid -remap value %s, expected old=new", remap)
+		}
+		parseOpts.RemapFrom = filepath.Clean(parts[0])
+		parseOpts.RemapTo, err = filepath.Abs(parts[1])
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if len(dbRoot) > 0 {
+		parseOpts.DBRoot, err = filepath.Abs(dbRoot)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if thumbnails {
+		parseOpts.ThumbnailsFolder = GetThumbnailsFolder(dbFile)
+	}
+	// Paths are normalized when database is read, so compaction writes them back normalized
+	var fh *FileHashes
+	if simulate || verifyDB {
+		// Records are checked by -verify-db itself
+		fh, err = ReadSimulatedDB(dbFile, parseOpts)
+	} else {
+		fh, err = ReadDB(dbFile, compactDB || normalizePaths, parseOpts)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	defer CloseDB(fh)
+	if verifyDB {
+		PrintDBDiscrepancies(fh)
+		return
+	}
+	if checkDB {
+		CheckIndex(fh)
+	}
+	if len(importChecksums) > 0 {
+		if err := ImportChecksums(importChecksums, checksumsRoot, fh); err != nil {
+			fatal(err)
+		}
+	}
+	scanConcurrency := concurrency
+	if autoConcurrency {
+		explicit := false
+		flag.Visit(func(f *flag.Flag) {
+			explicit = explicit || f.Name == "concurrency"
+		})
+		if explicit {
+			log.Infof("Using scan concurrency %d set with -concurrency\n", concurrency)
+		} else {
+			var reason string
+			scanConcurrency, reason = AutoConcurrency(folders, concurrency)
+			log.Infof("Using scan concurrency %d, %s\n", scanConcurrency, reason)
+		}
+	}
+	if reindex {
+		before, after, err := ReindexDB(fh, folders, scanConcurrency)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("* Reindexed database, %d records before and %d after\n", before, after)
+	} else if len(folders) > 0 {
+		ctx := context.Background()
+		if maxRuntime > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxRuntime)
+			defer cancel()
+		}
+		err := ScanFoldersContext(ctx, folders, fh, scanConcurrency)
+		if len(errorsReport) > 0 {
+			if err := WriteErrorsReport(errorsReport); err != nil {
+				fatal(err)
+			}
+		}
+		if reportBrokenLinks {
+			PrintBrokenLinks()
+		}
+		if err == context.DeadlineExceeded {
+			fmt.Printf("* Scan stopped after %s, parsed %d files (%d bytes hashed), run again to continue\n", maxRuntime, atomic.LoadInt64(&counters.filesParsed), atomic.LoadInt64(&counters.bytesHashed))
+			return
+		} else if err != nil {
+			fatal(err)
+		}
+	}
+	if autoCompact > 0 {
+		if err := AutoCompactDB(fh, autoCompact); err != nil {
+			fatal(err)
+		}
+	}
+	if scanOnly {
+		fmt.Printf("* Parsed %d files (%d bytes hashed, %d errors), database has %d files\n", atomic.LoadInt64(&counters.filesParsed), atomic.LoadInt64(&counters.bytesHashed), atomic.LoadInt64(&counters.parseErrors), len(fh.files))
+		return
+	}
+	// Moves requested with -serve use same options, only destination and what to move are taken from requests
+	moveOpts := MoveOptions{Destination: moveDuplicatesTo, RemovePrefix: removePrefix, RenameByDate: renameByDate, Template: moveTemplate, Apply: applyMove, ReportEmptyDirs: reportEmptyDirs, RemoveEmptyDirs: removeEmptyDirs}
+	if readOnlyMasters {
+		moveOpts.ReadOnlyFolder = folderToScanForMasters
+	}
+	moveOpts.Protected = protected
+	moveOpts.MinFreeSpace = minFreeSpace
+	moveOpts.StrictOnly = strictMoves
+	moveOpts.Quarantine = quarantine
+	moveOpts.LivePhotos = livePhotos
+	moveOpts.LivePhotoPairing = livePhotoPairing
+	moveOpts.Sidecars = sidecars
+	moveOpts.SidecarExtensions = splitExtensions(sidecarExtensions)
+	if compareFolders {
+		if err := CompareFolders(folders[0], folders[1], fh); err != nil {
+			fatal(err)
+		}
+	}
+	if len(exportChecksums) > 0 {
+		if err := ExportChecksums(exportChecksums, checksumsRoot, fh); err != nil {
+			fatal(err)
+		}
+	}
+	if len(uniqueTo) > 0 {
+		if err := PrintUniqueFiles(uniqueTo, fh); err != nil {
+			fatal(err)
+		}
+	}
+	if reportCorrupt {
+		PrintCorruptFiles(fh)
+	}
+	if len(reportPartial) > 0 {
+		PrintPartialMatches(fh, minPartialSize)
+	}
+	if reportVideos {
+		PrintVideoMatches(fh, videoSizeTolerance/100)
+	}
+	if reportLongest > 0 {
+		PrintLongestVideos(fh, reportLongest)
+	}
+	if reportIdenticalFolders {
+		PrintIdenticalFolders(fh)
+	}
+	if subsetFolders {
+		PrintSubsetFolders(fh, subsetMinOverlap/100)
+	}
+	if len(otherDBs) > 0 {
+		var dbPaths []string
+		for _, path := range strings.Split(otherDBs, ",") {
+			path, err := ExpandHome(strings.TrimSpace(path))
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
-			if moved {
-				err = CompactDB(fh)
+			dbPaths = append(dbPaths, path)
+		}
+		federation, err := ReadFederation(dbPaths, parseOpts.DBRoot)
+		if err != nil {
+			fatal(err)
+		}
+		if err := PrintFederatedMatches(folderToScanForDuplicates, fh, federation); err != nil {
+			fatal(err)
+		}
+	}
+	if searchForDuplicates || compareHashOnly || sameExtension || perDirectory || audioMatches || listMasters || print0 || countOnly || len(folderToScanForDuplicates) > 0 || len(folderToScanForMasters) > 0 || len(moveDuplicatesTo) > 0 || len(canonicalCopy) > 0 || folderReport || reportLargest > 0 || dedupePreview || len(snapshotDB) > 0 || len(execCommand) > 0 || len(htmlReport) > 0 {
+		// Only keep found duplicates in memory when they are needed after search
+		needsDups := listMasters || len(moveDuplicatesTo) > 0 || len(canonicalCopy) > 0 || folderReport || reportLargest > 0 || dedupePreview || len(snapshotDB) > 0 || len(execCommand) > 0 || len(htmlReport) > 0
+		stats := DuplicateStats{}
+		var reasons map[*FileMetadata]string
+		if dedupePreview {
+			reasons = make(map[*FileMetadata]string)
+		}
+		searchListing := listing
+		if listMasters {
+			// Kept files are printed instead of duplicates
+			searchListing = ListingFormats["none"]
+		}
+		dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: folderToScanForDuplicates, MastersFolder: folderToScanForMasters, Policy: policy, Listing: searchListing, Limit: limit, Resumable: resumable, CountOnly: !needsDups, Stats: &stats, SameFileCheck: sameFileCheck, PerDirectory: perDirectory, AudioMatches: audioMatches, FileHashOnly: compareHashOnly, SameExtension: sameExtension, Concurrency: concurrency, Reasons: reasons}, fh)
+		if err != nil {
+			fatal(err)
+		}
+		if listMasters {
+			if err := PrintKeptFiles(dups, folderToScanForDuplicates, fh, listing, strictMoves); err != nil {
+				fatal(err)
+			}
+		}
+		if countOnly {
+			fmt.Printf("* %d duplicate groups, %d duplicates, %d bytes reclaimable\n", stats.Groups, stats.Duplicates, stats.Reclaimable)
+		}
+		if len(snapshotDB) > 0 {
+			snapshot, err := ReadSnapshotDB(snapshotDB, parseOpts.DBRoot)
+			if err != nil {
+				fatal(err)
+			}
+			dups = FilterNewDuplicates(dups, snapshot)
+			PrintDuplicateGroups(fmt.Sprintf("New duplicates since %s", snapshotDB), dups)
+		}
+		if dedupePreview {
+			PrintDedupePreview(dups, reasons)
+		}
+		if reportLargest > 0 {
+			PrintLargestDuplicates(dups, reportLargest)
+		}
+		if folderReport {
+			if err := PrintFolderReport(dups, folderToScanForDuplicates, groupDepth); err != nil {
+				fatal(err)
+			}
+		}
+		if len(htmlReport) > 0 {
+			if err := WriteHTMLReport(htmlReport, GetThumbnailsFolder(dbFile), dups); err != nil {
+				fatal(err)
+			}
+		}
+		if len(execCommand) > 0 {
+			if applyMove && len(dups) > 0 {
+				count, _ := countDuplicates(dups)
+				if err := confirmAction(fmt.Sprintf("About to run %q for %d duplicates, proceed?", execCommand, count), yes); err != nil {
+					fatal(err)
+				}
+			}
+			if err := ExecDuplicates(execCommand, dups, applyMove); err != nil {
+				fatal(err)
+			}
+		}
+		if len(canonicalCopy) > 0 {
+			opts := MoveOptions{Destination: canonicalCopy, RemovePrefix: removePrefix, RenameByDate: renameByDate, Template: moveTemplate, Apply: applyMove, StrictOnly: strictMoves}
+			copied, err := CopyMasters(opts, dups, folderToScanForDuplicates, fh)
+			if err != nil {
+				fatal(err)
+			}
+			if applyMove {
+				fmt.Printf("* Copied %d files to %s\n", copied, canonicalCopy)
+			} else {
+				fmt.Printf("* Would copy %d files to %s, use -apply to copy them\n", copied, canonicalCopy)
+			}
+		}
+		if len(moveDuplicatesTo) > 0 && len(dups) > 0 {
+			opts := moveOpts
+			if applyMove {
+				count, size, deletions, err := countMoves(opts, dups)
 				if err != nil {
-					log.Fatal(err)
+					fatal(err)
+				}
+				prompt := fmt.Sprintf("About to move %d files totaling %s to %s", count, formatSize(size), moveDuplicatesTo)
+				if deletions > 0 {
+					prompt += fmt.Sprintf(" and delete %d sidecars", deletions)
+				}
+				if err := confirmAction(prompt+", proceed?", yes); err != nil {
+					fatal(err)
+				}
+			}
+			var scriptFile *os.File
+			if len(scriptPath) > 0 {
+				if scriptFile, err = os.Create(scriptPath); err != nil {
+					fatal(err)
+				}
+				if opts.Script, err = NewMoveScript(scriptFile, scriptShell); err != nil {
+					fatal(err)
 				}
 			}
+			moved, err := MoveDuplicates(opts, dups, fh)
+			if scriptFile != nil {
+				if err := opts.Script.Flush(); err != nil {
+					fatal(err)
+				}
+				if err := scriptFile.Close(); err != nil {
+					fatal(err)
+				}
+				fmt.Printf("* Wrote move commands to %s\n", scriptPath)
+			}
+			if moved {
+				// Save files that were moved before any error
+				if err := CompactDB(fh); err != nil {
+					fatal(err)
+				}
+			}
+			if err != nil {
+				fatal(err)
+			}
+		}
+	}
+	if watch {
+		if err := WatchFolders(folders, fh, scanConcurrency, watchDups); err != nil {
+			fatal(err)
+		}
+	}
+	if len(serveAddr) > 0 {
+		if err := Serve(serveAddr, fh, concurrency, policy, moveOpts, yes); err != nil {
+			fatal(err)
 		}
 	}
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+// memFilesystem is Filesystem that keeps files in memory, so that scan and move logic can be tested without touching disk
+type memFilesystem struct {
+	lock  sync.Mutex
+	files map[string]*memFileInfo
+	// Folders that act as separate volumes, files can not be renamed between them
+	volumes []string
+}
+
+// memFileInfo describes file or folder in memFilesystem along with file contents
+type memFileInfo struct {
+	name    string
+	data    []byte
+	modTime time.Time
+	dir     bool
+	// Path that symlink points to, empty for other files
+	link string
+}
+
+func (f *memFileInfo) Name() string       { return f.name }
+func (f *memFileInfo) Size() int64        { return int64(len(f.data)) }
+func (f *memFileInfo) ModTime() time.Time { return f.modTime }
+func (f *memFileInfo) IsDir() bool        { return f.dir }
+func (f *memFileInfo) Sys() interface{}   { return nil }
+
+func (f *memFileInfo) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0777
+	}
+	if len(f.link) > 0 {
+		return os.ModeSymlink | 0777
+	}
+	return 0666
+}
+
+// memFile is file opened for reading from memFilesystem
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
+
+func newMemFilesystem() *memFilesystem {
+	return &memFilesystem{files: make(map[string]*memFileInfo)}
+}
+
+// useMemFilesystem replaces filesystem used by scan and move with in-memory one until test ends
+func useMemFilesystem(t *testing.T) *memFilesystem {
+	mem := newMemFilesystem()
+	previous := fsys
+	fsys = mem
+	t.Cleanup(func() { fsys = previous })
+	return mem
+}
+
+// writeFile creates file with its parent folders
+func (m *memFilesystem) writeFile(path string, data string, modTime time.Time) {
+	path = filepath.Clean(path)
+	m.MkdirAll(filepath.Dir(path), 0777)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.files[path] = &memFileInfo{name: filepath.Base(path), data: []byte(data), modTime: modTime}
+}
+
+// memTestFile is file created by makeMemTestFiles
+type memTestFile struct {
+	path     string
+	contents string
+	modified time.Time
+}
+
+// makeMemTestFiles creates files in new in-memory filesystem and returns database with their records, database itself is kept on disk
+func makeMemTestFiles(t *testing.T, files []memTestFile) (*memFilesystem, *FileHashes) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	mem := useMemFilesystem(t)
+	for _, file := range files {
+		mem.writeFile(file.path, file.contents, file.modified)
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{string(filepath.Separator)}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	return mem, fh
+}
+
+func (m *memFilesystem) get(op string, path string) (*memFileInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f := m.files[filepath.Clean(path)]
+	if f == nil {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (m *memFilesystem) Open(path string) (File, error) {
+	f, err := m.get("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return memFile{bytes.NewReader(f.data)}, nil
+}
+
+func (m *memFilesystem) Stat(path string) (os.FileInfo, error) {
+	f, err := m.get("stat", path)
+	if err == nil && len(f.link) > 0 {
+		return m.Stat(f.link)
+	}
+	return f, err
+}
+
+func (m *memFilesystem) Lstat(path string) (os.FileInfo, error) {
+	return m.get("lstat", path)
+}
+
+// children returns sorted names of files and folders directly inside folder
+func (m *memFilesystem) children(dir string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var names []string
+	for path := range m.files {
+		if path != dir && filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *memFilesystem) ReadDir(path string) ([]os.FileInfo, error) {
+	if _, err := m.get("readdir", path); err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, name := range m.children(filepath.Clean(path)) {
+		entry, err := m.Lstat(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m *memFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	f, err := m.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = m.walk(filepath.Clean(root), f, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (m *memFilesystem) walk(path string, f os.FileInfo, walkFn filepath.WalkFunc) error {
+	if err := walkFn(path, f, nil); err != nil || !f.IsDir() {
+		return err
+	}
+	for _, name := range m.children(path) {
+		childPath := filepath.Join(path, name)
+		child, err := m.Lstat(childPath)
+		if err != nil {
+			if err := walkFn(childPath, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := m.walk(childPath, child, walkFn); err != nil && (err != filepath.SkipDir || !child.IsDir()) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getVolume returns volume that path belongs to, or empty string when it is not inside any of them
+func (m *memFilesystem) getVolume(path string) string {
+	for _, volume := range m.volumes {
+		if path == volume || strings.HasPrefix(path, volume+string(filepath.Separator)) {
+			return volume
+		}
+	}
+	return ""
+}
+
+func (m *memFilesystem) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.getVolume(oldpath) != m.getVolume(newpath) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	f := m.files[oldpath]
+	if f == nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if parent := m.files[filepath.Dir(newpath)]; parent == nil || !parent.dir {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	for path, child := range m.files {
+		if path == oldpath || strings.HasPrefix(path, oldpath+string(filepath.Separator)) {
+			delete(m.files, path)
+			path = newpath + path[len(oldpath):]
+			if path == newpath {
+				child.name = filepath.Base(newpath)
+			}
+			m.files[path] = child
+		}
+	}
+	return nil
+}
+
+func (m *memFilesystem) Remove(path string) error {
+	path = filepath.Clean(path)
+	if len(m.children(path)) > 0 {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrExist}
+	}
+	if _, err := m.get("remove", path); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.files, path)
+	return nil
+}
+
+func (m *memFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for {
+		if f := m.files[path]; f != nil {
+			if !f.dir {
+				return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+			}
+			return nil
+		}
+		m.files[path] = &memFileInfo{name: filepath.Base(path), dir: true}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
+
+func (m *memFilesystem) Symlink(oldname, newname string) error {
+	newname = filepath.Clean(newname)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.files[newname] != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	m.files[newname] = &memFileInfo{name: filepath.Base(newname), link: oldname}
+	return nil
+}
+
+func TestMemFilesystemScan(t *testing.T) {
+	mem := useMemFilesystem(t)
+	modTime := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem.writeFile("/library/a/photo.txt", "contents", modTime)
+	mem.writeFile("/library/b/photo.txt", "contents", modTime)
+	mem.writeFile("/library/b/other.txt", "other", modTime)
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{"/library"}, fh, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(fh.files) != 3 {
+		t.Fatalf("Expected 3 files to be scanned, got %d", len(fh.files))
+	}
+	a, b := fh.files[filepath.FromSlash("/library/a/photo.txt")], fh.files[filepath.FromSlash("/library/b/photo.txt")]
+	if a == nil || b == nil || a.FileHash != b.FileHash || !a.Modified.Equal(modTime) {
+		t.Errorf("Expected in-memory files to be hashed, got %+v and %+v", a, b)
+	}
+	if _, err := os.Stat(filepath.FromSlash("/library/a/photo.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected scan to not touch disk, got %v", err)
+	}
+}
+
+func TestMemFilesystemQuarantineAndEmptyDirs(t *testing.T) {
+	modTime := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/a.txt", "same", modTime},
+		{"/lib/incoming/trip/a.txt", "same", modTime},
+	})
+	mem.MkdirAll("/lib/incoming/old/empty", 0777)
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: "/quarantine", RemovePrefix: "/lib", Quarantine: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("/quarantine/incoming/trip/a.txt"); err != nil {
+		t.Errorf("Expected duplicate to be moved: %v", err)
+	}
+	if placeholder, err := mem.Lstat("/lib/incoming/trip/a.txt"); err != nil || placeholder.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected symlink to master in place of duplicate, got %v: %v", placeholder, err)
+	}
+	// Placeholder is listed by walk over unchanged directories, but not recorded
+	fh.options.SkipUnchangedDirs = true
+	if err := ScanFolders([]string{"/lib"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if record := fh.files["/lib/incoming/trip/a.txt"]; record != nil {
+		t.Errorf("Expected symlink to not be recorded, got %+v", record)
+	}
+	removed, err := RemoveEmptyDirs([]string{"/lib"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("/lib/incoming/old"); removed != 2 || err == nil {
+		t.Errorf("Expected 2 empty directories to be removed, got %d: %v", removed, err)
+	}
+	if _, err := mem.Stat("/lib/incoming/trip"); err != nil {
+		t.Errorf("Expected directory with placeholder to be kept: %v", err)
+	}
+}
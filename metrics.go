@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// scanCounters are updated atomically by parser workers
+type scanCounters struct {
+	filesParsed int64
+	bytesHashed int64
+	parseErrors int64
+	// Files queued for parsing by folder walk
+	filesQueued int64
+	// Files with same size and contents, but different timestamps
+	touchedFiles int64
+	// Files with same size, but different contents
+	editedFiles int64
+}
+
+var counters scanCounters
+
+func writeMetric(w http.ResponseWriter, name string, metricType string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, metricType, name, value)
+}
+
+// handleMetrics writes scan and database metrics in Prometheus text format
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.fh.lock.RLock()
+	records := len(s.fh.files)
+	s.fh.lock.RUnlock()
+	dbSize := int64(0)
+	if f, err := os.Stat(s.fh.dbPath); err == nil {
+		dbSize = f.Size()
+	}
+	running := int64(0)
+	if s.getStatus().Running {
+		running = 1
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "cleaner_files_parsed_total", "counter", "Number of files parsed.", atomic.LoadInt64(&counters.filesParsed))
+	writeMetric(w, "cleaner_bytes_hashed_total", "counter", "Number of bytes hashed.", atomic.LoadInt64(&counters.bytesHashed))
+	writeMetric(w, "cleaner_parse_errors_total", "counter", "Number of files that failed to parse.", atomic.LoadInt64(&counters.parseErrors))
+	writeMetric(w, "cleaner_scan_running", "gauge", "Whether scan is running.", running)
+	writeMetric(w, "cleaner_db_records", "gauge", "Number of file records in database.", int64(records))
+	writeMetric(w, "cleaner_db_size_bytes", "gauge", "Size of database file.", dbSize)
+	writeMetric(w, "cleaner_duplicates", "gauge", "Number of duplicates found by last search.", atomic.LoadInt64(&s.duplicates))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandHome replaces leading ~ in path with home folder of current user
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + path[1:], nil
+}
+
+// ExpandPaths expands paths given as arguments when they are not expanded by shell, e.g. when started by service
+// Leading ~ is expanded first, then braces (e.g. photos/{2019,2020}) and then glob patterns (e.g. photos/20*)
+// Paths that exist as is are never expanded further, so literal names with braces or glob characters keep working
+func ExpandPaths(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if isRemotePath(path) {
+			// Remote folders are only cleaned, so that sftp://user@host/path becomes sftp:/user@host/path like in database
+			expanded = append(expanded, filepath.Clean(path))
+			continue
+		} else if isURL(path) {
+			return nil, fmt.Errorf("%w: %s", ErrRemotePath, path)
+		}
+		path, err := ExpandHome(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			expanded = append(expanded, path)
+			continue
+		}
+		for _, pattern := range expandBraces(path) {
+			if !strings.ContainsAny(pattern, "*?[") {
+				expanded = append(expanded, pattern)
+				continue
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				// Keep pattern as is, so that missing path is reported when it is scanned
+				log.Warningf("No paths match %s\n", pattern)
+				expanded = append(expanded, pattern)
+			}
+			expanded = append(expanded, matches...)
+		}
+	}
+	return expanded, nil
+}
+
+// remoteScheme is scheme of URLs of remote folders that are accessed over SFTP, e.g. sftp://user@host/path
+const remoteScheme = "sftp:"
+
+// isURL checks if path is URL with scheme, e.g. ftp://host/path, rather than local path
+func isURL(path string) bool {
+	i := strings.Index(path, "://")
+	// Single letter scheme would be drive letter on Windows
+	return i > 1 && !strings.ContainsAny(path[:i], `/\`)
+}
+
+// isRemotePath checks if path is on remote host, cleaning collapses double slash, so sftp:/user@host/path is remote as well
+func isRemotePath(path string) bool {
+	return len(path) > len(remoteScheme) && strings.HasPrefix(path, remoteScheme) && os.IsPathSeparator(path[len(remoteScheme)])
+}
+
+// splitRemotePath splits remote path into host, which may include user and port, and absolute path on host
+func splitRemotePath(path string) (string, string, bool) {
+	if !isRemotePath(path) {
+		return "", "", false
+	}
+	rest := strings.TrimLeft(filepath.ToSlash(path[len(remoteScheme):]), "/")
+	host, remotePath := rest, "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host, remotePath = rest[:i], rest[i:]
+	}
+	return host, remotePath, len(host) > 0
+}
+
+// absPath returns absolute path like filepath.Abs, remote paths are only cleaned since they are absolute on their host
+func absPath(path string) (string, error) {
+	if isRemotePath(path) {
+		return filepath.Clean(path), nil
+	}
+	return filepath.Abs(path)
+}
+
+// expandBraces expands first brace group with comma separated alternatives recursively, unbalanced braces are kept as is
+func expandBraces(pattern string) []string {
+	start := strings.Index(pattern, "{")
+	if start < 0 {
+		return []string{pattern}
+	}
+	depth := 0
+	var alternatives []string
+	last := start + 1
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(alternatives) == 0 {
+				// Braces without alternatives are not expanded
+				var result []string
+				for _, suffix := range expandBraces(pattern[i+1:]) {
+					result = append(result, pattern[:i+1]+suffix)
+				}
+				return result
+			}
+			alternatives = append(alternatives, pattern[last:i])
+			var result []string
+			for _, alternative := range alternatives {
+				result = append(result, expandBraces(pattern[:start]+alternative+pattern[i+1:])...)
+			}
+			return result
+		}
+	}
+	return []string{pattern}
+}
+
+// normalizePath cleans path used as database key, so that paths differing only in redundant separators, . and .. elements
+// or separator style are recorded as same file, path inside archive is kept as is
+func normalizePath(path string) string {
+	if archivePath, entry, ok := splitArchivePath(path); ok {
+		return normalizePath(archivePath) + archiveSeparator + entry
+	}
+	return filepath.Clean(filepath.FromSlash(path))
+}
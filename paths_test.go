@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := map[string][]string{
+		"photos":                {"photos"},
+		"photos/{2019,2020}":    {"photos/2019", "photos/2020"},
+		"{a,b}/{c,d}":           {"a/c", "a/d", "b/c", "b/d"},
+		"photos/{2019,{01,02}}": {"photos/2019", "photos/01", "photos/02"},
+		"photos/{2019}":         {"photos/{2019}"},
+		"photos/{2019,2020":     {"photos/{2019,2020"},
+	}
+	for pattern, expected := range tests {
+		if result := expandBraces(pattern); !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %s to expand to %v, got %v", pattern, expected, result)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"2019", "2020", "{literal}"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := ExpandPaths([]string{filepath.Join(root, "20*"), filepath.Join(root, "{literal}"), "~/photos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(root, "2019"), filepath.Join(root, "2020"), filepath.Join(root, "{literal}"), home + "/photos"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+	if _, err := ExpandPaths([]string{root, "ftp://user@host/archive"}); !errors.Is(err, ErrRemotePath) {
+		t.Errorf("Expected remote path error, got %v", err)
+	}
+	paths, err = ExpandPaths([]string{"sftp://user@host:2222/archive/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, remotePath, ok := splitRemotePath(paths[0]); !ok || host != "user@host:2222" || remotePath != "/archive" {
+		t.Errorf("Expected remote path on user@host:2222 at /archive, got %s (%s, %s, %v)", paths[0], host, remotePath, ok)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := map[string]string{
+		"/photos//2019/./a.jpg":            filepath.FromSlash("/photos/2019/a.jpg"),
+		"/photos/2019/../2020/a.jpg":       filepath.FromSlash("/photos/2020/a.jpg"),
+		"/photos/2019/":                    filepath.FromSlash("/photos/2019"),
+		"/backups//2019.zip!/photos/a.jpg": filepath.FromSlash("/backups/2019.zip") + "!/photos/a.jpg",
+	}
+	for path, expected := range tests {
+		if result := normalizePath(path); result != expected {
+			t.Errorf("Expected %s to be normalized to %s, got %s", path, expected, result)
+		}
+	}
+	record := &FileMetadata{Path: "/photos/./2019//a.jpg"}
+	if updated, err := updateToAbsolutePath(record, ""); err != nil || !updated || record.Path != filepath.FromSlash("/photos/2019/a.jpg") {
+		t.Errorf("Expected record path to be normalized, got %s (updated: %v, error: %v)", record.Path, updated, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// StartProfiling starts writing CPU profile and prepares heap profile, profiles are written by returned stop function
+// Either path can be empty to skip that profile
+func StartProfiling(cpuProfile string, memProfile string) (func(), error) {
+	var cpuFile *os.File
+	if len(cpuProfile) > 0 {
+		var err error
+		cpuFile, err = os.Create(cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, err
+		}
+	}
+	stopped := false
+	return func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				log.Errorf("Failed to write CPU profile: %s\n", err)
+			}
+		}
+		if len(memProfile) > 0 {
+			if err := writeHeapProfile(memProfile); err != nil {
+				log.Errorf("Failed to write memory profile: %s\n", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Collect garbage first, so that profile reflects live objects
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
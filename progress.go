@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// scanProgress keeps counters at start of scan, so that progress of each scan is reported separately
+type scanProgress struct {
+	start  time.Time
+	queued int64
+	done   int64
+}
+
+func newScanProgress() *scanProgress {
+	return &scanProgress{start: time.Now(), queued: atomic.LoadInt64(&counters.filesQueued), done: getFilesDone()}
+}
+
+// log logs number of processed files, rate and estimated remaining time every interval until context is done
+// Remaining time is estimated for files found so far, so it grows while folders are still walked
+func (p *scanProgress) log(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			logProgressLine(atomic.LoadInt64(&counters.filesQueued)-p.queued, getFilesDone()-p.done, now.Sub(p.start))
+		}
+	}
+}
+
+// getFilesDone returns number of files parsed or failed to parse
+func getFilesDone() int64 {
+	return atomic.LoadInt64(&counters.filesParsed) + atomic.LoadInt64(&counters.parseErrors)
+}
+
+func logProgressLine(queued int64, done int64, elapsed time.Duration) {
+	rate := float64(done) / elapsed.Seconds()
+	if rate <= 0 {
+		log.Infof("Processed %d of %d files, no files done in %s\n", done, queued, elapsed.Round(time.Second))
+		return
+	}
+	eta := time.Duration(float64(queued-done) / rate * float64(time.Second))
+	log.Infof("Processed %d of %d files (%.1f files/s), ETA %s\n", done, queued, rate, eta.Round(time.Second))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ProtectedPaths lists absolute files and folders whose files are always masters, so that they are never moved or deleted
+type ProtectedPaths []string
+
+// NewProtectedPaths converts protected paths to absolute ones
+func NewProtectedPaths(paths []string) (ProtectedPaths, error) {
+	var protected ProtectedPaths
+	for _, path := range paths {
+		abs, err := absPath(path)
+		if err != nil {
+			return nil, err
+		}
+		protected = append(protected, abs)
+	}
+	return protected, nil
+}
+
+// Contains checks if path is protected path itself or inside of protected folder
+func (protected ProtectedPaths) Contains(path string) bool {
+	for _, prefix := range protected {
+		if path == prefix || strings.HasPrefix(path, fmt.Sprintf("%s%c", strings.TrimSuffix(prefix, string(filepath.Separator)), filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeatedFlag collects values of flag that can be passed more than once
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type folderStats struct {
+	path        string
+	count       int
+	reclaimable int64
+}
+
+// PrintFolderReport prints reclaimable space and duplicate counts per folder sorted by reclaimable space
+// Folders are grouped by first groupDepth path elements relative to root, or to volume root if root is not specified
+func PrintFolderReport(dups map[*FileMetadata][]*FileMetadata, root string, groupDepth int) error {
+	if len(root) > 0 {
+		var err error
+		root, err = absPath(root)
+		if err != nil {
+			return err
+		}
+	}
+	folders := make(map[string]*folderStats)
+	for _, list := range dups {
+		for _, dup := range list {
+			folder := getGroupFolder(dup.Path, root, groupDepth)
+			stats := folders[folder]
+			if stats == nil {
+				stats = &folderStats{path: folder}
+				folders[folder] = stats
+			}
+			stats.count++
+			stats.reclaimable += dup.Size
+		}
+	}
+	sorted := make([]*folderStats, 0, len(folders))
+	for _, stats := range folders {
+		sorted = append(sorted, stats)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].reclaimable != sorted[j].reclaimable {
+			return sorted[i].reclaimable > sorted[j].reclaimable
+		}
+		return sorted[i].path < sorted[j].path
+	})
+	fmt.Printf("* Reclaimable space by folder:\n")
+	for _, stats := range sorted {
+		fmt.Printf("%011d %6d %s\n", stats.reclaimable, stats.count, stats.path)
+	}
+	return nil
+}
+
+// largestDuplicate is duplicate along with master it was found for
+type largestDuplicate struct {
+	dup    *FileMetadata
+	master *FileMetadata
+}
+
+// getLargestDuplicates returns up to limit duplicates sorted by size from largest, all of them when limit is not positive
+func getLargestDuplicates(dups map[*FileMetadata][]*FileMetadata, limit int) []largestDuplicate {
+	var sorted []largestDuplicate
+	for master, list := range dups {
+		for _, dup := range list {
+			sorted = append(sorted, largestDuplicate{dup: dup, master: master})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].dup.Size != sorted[j].dup.Size {
+			return sorted[i].dup.Size > sorted[j].dup.Size
+		}
+		return sorted[i].dup.Path < sorted[j].dup.Path
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// PrintLargestDuplicates prints up to limit largest duplicates along with their masters and space reclaimable by removing them
+func PrintLargestDuplicates(dups map[*FileMetadata][]*FileMetadata, limit int) {
+	largest := getLargestDuplicates(dups, limit)
+	reclaimable := int64(0)
+	fmt.Printf("* Largest duplicates:\n")
+	for _, entry := range largest {
+		fmt.Printf("%011d %s (master %s)\n", entry.dup.Size, entry.dup.Path, entry.master.Path)
+		reclaimable += entry.dup.Size
+	}
+	fmt.Printf("* %d largest duplicates, %s reclaimable\n", len(largest), formatSize(reclaimable))
+}
+
+// getGroupFolder returns folder containing path truncated to groupDepth elements below root
+func getGroupFolder(path string, root string, groupDepth int) string {
+	if len(root) == 0 || !strings.HasPrefix(path, fmt.Sprintf("%s%c", root, filepath.Separator)) {
+		root = fmt.Sprintf("%s%c", filepath.VolumeName(path), filepath.Separator)
+	}
+	relDir, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || relDir == "." {
+		return root
+	}
+	parts := strings.Split(relDir, string(filepath.Separator))
+	if groupDepth > 0 && len(parts) > groupDepth {
+		parts = parts[:groupDepth]
+	}
+	return filepath.Join(root, filepath.Join(parts...))
+}
+
+// FilterNewDuplicates keeps only duplicate groups that have files added or changed since snapshot
+// Files missing from snapshot are only treated as added when they were first seen after latest record of snapshot,
+// so that files which were already recorded at that time, e.g. in folders that snapshot did not cover, are not reported
+func FilterNewDuplicates(dups map[*FileMetadata][]*FileMetadata, snapshot *FileHashes) map[*FileMetadata][]*FileMetadata {
+	taken := getSnapshotTime(snapshot)
+	result := make(map[*FileMetadata][]*FileMetadata)
+	for master, list := range dups {
+		isNew := isNewRecord(master, snapshot, taken)
+		for _, dup := range list {
+			isNew = isNew || isNewRecord(dup, snapshot, taken)
+		}
+		if isNew {
+			result[master] = list
+		}
+	}
+	return result
+}
+
+// getSnapshotTime returns latest time when record of snapshot was first seen, zero if none was
+func getSnapshotTime(snapshot *FileHashes) time.Time {
+	var taken time.Time
+	for _, record := range snapshot.files {
+		if record.FirstSeen.After(taken) {
+			taken = record.FirstSeen
+		}
+	}
+	return taken
+}
+
+func isNewRecord(record *FileMetadata, snapshot *FileHashes, taken time.Time) bool {
+	if old := snapshot.files[record.Path]; old != nil {
+		return old.FileHash != record.FileHash
+	}
+	// Records created before first seen time was tracked can't be dated, so they are treated as added
+	return record.FirstSeen.IsZero() || record.FirstSeen.After(taken)
+}
+
+// PrintDuplicateGroups prints duplicate groups sorted by master path
+func PrintDuplicateGroups(title string, dups map[*FileMetadata][]*FileMetadata) {
+	masters := make([]*FileMetadata, 0, len(dups))
+	for master := range dups {
+		masters = append(masters, master)
+	}
+	sort.Slice(masters, func(i, j int) bool { return masters[i].Path < masters[j].Path })
+	fmt.Printf("* %s:\n", title)
+	for _, master := range masters {
+		fmt.Printf("* Duplicates for: %s\n", master.Path)
+		for _, dup := range dups[master] {
+			fmt.Printf("    %s\n", dup.Path)
+		}
+	}
+}
+
+// PrintDedupePreview prints which file of each group is kept and why it was picked over each of duplicates
+func PrintDedupePreview(dups map[*FileMetadata][]*FileMetadata, reasons map[*FileMetadata]string) {
+	fmt.Printf("* Files kept and why:\n")
+	for _, group := range getDuplicateGroups(dups, reasons) {
+		fmt.Printf("* Keeping %s\n", group.Master.Path)
+		for i, dup := range group.Duplicates {
+			fmt.Printf("    over %s, it %s\n", dup.Path, group.Reasons[i])
+		}
+	}
+}
+
+// CompareFolders prints files from folderB that have identical copies in folderA along with their locations,
+// followed by files from folderB that are not present in folderA
+func CompareFolders(folderA string, folderB string, fh *FileHashes) error {
+	folderA, err := absPath(folderA)
+	if err != nil {
+		return err
+	}
+	folderB, err = absPath(folderB)
+	if err != nil {
+		return err
+	}
+	prefixA := fmt.Sprintf("%s%c", folderA, filepath.Separator)
+	prefixB := fmt.Sprintf("%s%c", folderB, filepath.Separator)
+	var paths []string
+	for path := range fh.files {
+		if strings.HasPrefix(path, prefixB) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	var unique []string
+	fmt.Printf("* Already in %s:\n", folderA)
+	for _, path := range paths {
+		record := fh.files[path]
+		var copies []string
+		for _, other := range fh.hashes[record.FileHash] {
+			if other.FileHash == record.FileHash && strings.HasPrefix(other.Path, prefixA) && other != record {
+				copies = append(copies, other.Path)
+			}
+		}
+		if len(copies) == 0 {
+			unique = append(unique, path)
+			continue
+		}
+		sort.Strings(copies)
+		fmt.Printf("    %s\n", path)
+		for _, location := range copies {
+			fmt.Printf("      = %s\n", location)
+		}
+	}
+	fmt.Printf("* Unique to %s:\n", folderB)
+	for _, path := range unique {
+		fmt.Printf("    %s\n", path)
+	}
+	return nil
+}
+
+// PrintUniqueFiles prints files from folder whose file or image hash is not found anywhere outside of folder
+func PrintUniqueFiles(folder string, fh *FileHashes) error {
+	folder, err := absPath(folder)
+	if err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf("%s%c", folder, filepath.Separator)
+	var unique []string
+	for path, record := range fh.files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if hasCopyOutside(fh.hashes[record.FileHash], prefix) || (len(record.ImageHash) > 0 && hasCopyOutside(fh.hashes[record.ImageHash], prefix)) {
+			continue
+		}
+		unique = append(unique, path)
+	}
+	sort.Strings(unique)
+	fmt.Printf("* Unique to %s:\n", folder)
+	for _, path := range unique {
+		fmt.Printf("    %s\n", path)
+	}
+	return nil
+}
+
+// PrintCorruptFiles prints files recorded as possibly corrupt images along with reason, e.g. to download them again
+func PrintCorruptFiles(fh *FileHashes) {
+	var corrupt []string
+	for path, record := range fh.files {
+		if len(record.Corrupt) > 0 {
+			corrupt = append(corrupt, path)
+		}
+	}
+	sort.Strings(corrupt)
+	fmt.Printf("* Possibly corrupt files:\n")
+	for _, path := range corrupt {
+		fmt.Printf("    %s (%s)\n", path, fh.files[path].Corrupt)
+	}
+}
+
+// getLongestVideos returns up to limit videos with known duration sorted by duration from longest, all of them when limit is not positive
+func getLongestVideos(fh *FileHashes, limit int) []*FileMetadata {
+	var sorted []*FileMetadata
+	for _, record := range fh.files {
+		if record.Duration > 0 {
+			sorted = append(sorted, record)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Duration != sorted[j].Duration {
+			return sorted[i].Duration > sorted[j].Duration
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// formatDuration formats duration in seconds at millisecond precision, e.g. 1h2m3.5s
+func formatDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// PrintLongestVideos prints up to limit longest videos with their duration and size
+func PrintLongestVideos(fh *FileHashes, limit int) {
+	longest := getLongestVideos(fh, limit)
+	total := 0.0
+	fmt.Printf("* Longest videos:\n")
+	for _, record := range longest {
+		fmt.Printf("%011d %s (%s)\n", record.Size, record.Path, formatDuration(record.Duration))
+		total += record.Duration
+	}
+	fmt.Printf("* %d longest videos, %s total\n", len(longest), formatDuration(total))
+}
+
+func hasCopyOutside(records []*FileMetadata, prefix string) bool {
+	for _, record := range records {
+		if !strings.HasPrefix(record.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintKeptFiles prints files that remain after moving duplicates: masters, unique files and duplicates that would not be moved
+// Only files inside folder are printed when it is specified, files inside archives are never printed
+func PrintKeptFiles(dups map[*FileMetadata][]*FileMetadata, folder string, fh *FileHashes, listing ListingFormat, strictOnly bool) error {
+	kept, err := getKeptPaths(dups, folder, fh, strictOnly)
+	if err != nil {
+		return err
+	}
+	for _, path := range kept {
+		listing.print(listing.Kept, path, path, "")
+	}
+	return nil
+}
+
+// getKeptPaths returns sorted paths of files that remain after moving duplicates, see PrintKeptFiles
+func getKeptPaths(dups map[*FileMetadata][]*FileMetadata, folder string, fh *FileHashes, strictOnly bool) ([]string, error) {
+	prefix := ""
+	if len(folder) > 0 {
+		folder, err := absPath(folder)
+		if err != nil {
+			return nil, err
+		}
+		prefix = fmt.Sprintf("%s%c", folder, filepath.Separator)
+	}
+	moved := make(map[*FileMetadata]bool)
+	for master, list := range dups {
+		for _, dup := range list {
+			if !strictOnly || getMatchType(master, dup) == StrictMatch {
+				moved[dup] = true
+			}
+		}
+	}
+	var kept []string
+	for path, record := range fh.files {
+		if !moved[record] && strings.HasPrefix(path, prefix) && !isArchiveEntry(path) {
+			kept = append(kept, path)
+		}
+	}
+	sort.Strings(kept)
+	return kept, nil
+}
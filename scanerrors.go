@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// scanFailure is file that could not be parsed, it is not recorded, so it is retried on next scan
+type scanFailure struct {
+	path string
+	err  error
+	// File was held by another process
+	locked bool
+}
+
+// scanFailures are recorded by parser workers, so that they can be reported after scan
+var scanFailures struct {
+	lock sync.Mutex
+	list []scanFailure
+}
+
+func recordScanFailure(path string, err error) {
+	locked := isLockedError(err)
+	if locked {
+		log.Warningf("Skipping %s, it is locked or in use by another process: %s\n", path, err)
+	} else {
+		log.Warningf("Failed to parse %s: %s\n", path, err)
+	}
+	scanFailures.lock.Lock()
+	defer scanFailures.lock.Unlock()
+	scanFailures.list = append(scanFailures.list, scanFailure{path: path, err: err, locked: locked})
+}
+
+// getScanFailures returns files that failed to parse so far sorted by path
+func getScanFailures() []scanFailure {
+	scanFailures.lock.Lock()
+	defer scanFailures.lock.Unlock()
+	failures := append([]scanFailure{}, scanFailures.list...)
+	sort.Slice(failures, func(i, j int) bool { return failures[i].path < failures[j].path })
+	return failures
+}
+
+func logScanFailures() {
+	locked, failed := 0, 0
+	for _, failure := range getScanFailures() {
+		if failure.locked {
+			locked++
+		} else {
+			failed++
+		}
+	}
+	if locked > 0 {
+		log.Warningf("Skipped %d files locked by other processes, they are retried on next scan\n", locked)
+	}
+	if failed > 0 {
+		log.Warningf("Failed to parse %d files\n", failed)
+	}
+}
+
+// brokenLink is symlink whose target does not exist or can not be accessed
+type brokenLink struct {
+	path   string
+	target string
+	err    error
+}
+
+// brokenLinks are recorded while walking folders separately from files that failed to parse
+var brokenLinks struct {
+	lock sync.Mutex
+	list []brokenLink
+}
+
+func recordBrokenLink(path string, err error) {
+	target, readErr := os.Readlink(path)
+	if readErr != nil {
+		target = "?"
+	}
+	log.Warningf("Skipping broken symlink %s to %s: %s\n", path, target, err)
+	brokenLinks.lock.Lock()
+	defer brokenLinks.lock.Unlock()
+	brokenLinks.list = append(brokenLinks.list, brokenLink{path: path, target: target, err: err})
+}
+
+// getBrokenLinks returns broken symlinks found so far sorted by path
+func getBrokenLinks() []brokenLink {
+	brokenLinks.lock.Lock()
+	defer brokenLinks.lock.Unlock()
+	links := append([]brokenLink{}, brokenLinks.list...)
+	sort.Slice(links, func(i, j int) bool { return links[i].path < links[j].path })
+	return links
+}
+
+func logBrokenLinks() {
+	if count := len(getBrokenLinks()); count > 0 {
+		log.Warningf("Found %d broken symlinks\n", count)
+	}
+}
+
+// PrintBrokenLinks prints broken symlinks found while scanning along with their targets
+func PrintBrokenLinks() {
+	fmt.Printf("* Broken symlinks:\n")
+	for _, link := range getBrokenLinks() {
+		fmt.Printf("    %s -> %s\n", link.path, link.target)
+	}
+}
+
+// WriteErrorsReport writes files that failed to parse into file at path, one per line with reason separated by tab
+func WriteErrorsReport(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	for _, failure := range getScanFailures() {
+		reason := "failed"
+		if failure.locked {
+			reason = "locked"
+		}
+		if _, err := fmt.Fprintf(file, "%s\t%s\t%s\n", failure.path, reason, failure.err); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
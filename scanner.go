@@ -1,25 +1,83 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type scanInfo struct {
 	path           string
 	f              os.FileInfo
 	existingRecord *FileMetadata
+	// Only scan files inside unchanged archive
+	archiveOnly bool
 }
 
-func makeParserWorker(wg *sync.WaitGroup, jobs <-chan *scanInfo, results chan<- *FileMetadata) {
+func makeParserWorker(wg *sync.WaitGroup, jobs <-chan *scanInfo, results chan<- *FileMetadata, opts ParseOptions) {
 	for j := range jobs {
-		record, err := parseFileMetadata(j.path, j.f, j.existingRecord)
-		if err == nil {
+		var record *FileMetadata
+		var err error
+		if !j.archiveOnly {
+			record, err = parseFileMetadataWithTimeout(j.path, j.f, j.existingRecord, opts)
+			if err != nil {
+				recordScanFailure(j.path, err)
+			}
+		}
+		var entries []*FileMetadata
+		if err == nil && opts.ScanArchives && isArchiveFile(j.path) {
+			if entries, err = getArchiveEntries(j.path, j.f); err != nil {
+				atomic.AddInt64(&counters.parseErrors, 1)
+				log.Warningf("Failed to read archive %s: %s\n", j.path, err)
+			}
+			// Entries have to be counted before archive job is done
+			wg.Add(len(entries))
+		}
+		if record != nil {
 			results <- record
 		} else {
 			wg.Done()
 		}
+		for _, entry := range entries {
+			results <- entry
+		}
+	}
+}
+
+// parseFileMetadataWithTimeout parses file in separate goroutine and abandons it after FileTimeout, so that worker is not blocked by hanging reads
+// Abandoned goroutine keeps running until read returns, its result is discarded
+func parseFileMetadataWithTimeout(path string, f os.FileInfo, existingRecord *FileMetadata, opts ParseOptions) (*FileMetadata, error) {
+	if opts.FileTimeout <= 0 {
+		return parseFileMetadata(path, f, existingRecord, opts)
+	}
+	type parseResult struct {
+		record *FileMetadata
+		err    error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.FileTimeout)
+	defer cancel()
+	// Buffered, so that abandoned goroutine does not block on sending its result
+	done := make(chan parseResult, 1)
+	go func() {
+		// Result is counted by whichever of select cases happens, so that abandoned read is not counted when it returns
+		record, err := readFileMetadata(path, f, existingRecord, opts)
+		done <- parseResult{record, err}
+	}()
+	select {
+	case result := <-done:
+		countParseResult(result.err)
+		return result.record, result.err
+	case <-ctx.Done():
+		err := fmt.Errorf("%w after %s", ErrFileTimeout, opts.FileTimeout)
+		countParseResult(err)
+		return nil, err
 	}
 }
 
@@ -33,82 +91,441 @@ func makeAdderWorker(results <-chan *FileMetadata, fh *FileHashes) {
 func addParsedFileRecord(fh *FileHashes, record *FileMetadata) {
 	fh.lock.Lock()
 	defer fh.lock.Unlock()
+	if fh.options.IgnoreEmpty && record.Size == 0 {
+		// Files inside archives are only checked once they are read
+		log.Debugf("Skipping empty %s\n", record.Path)
+		return
+	}
 	log.Debugf("Adding %s\n", record.Path)
+	if archivePath, _, ok := splitArchivePath(record.Path); ok {
+		if existing := fh.files[record.Path]; existing != nil {
+			removeRecord(fh, existing)
+		}
+		fh.archives[archivePath] = true
+	}
 	addRecord(fh, record)
 	addFileToDB(fh, record)
 }
 
-func makeWalkFunc(jobs chan<- *scanInfo, fh *FileHashes) filepath.WalkFunc {
+func makeWalkFunc(ctx context.Context, jobs chan<- *scanInfo, fh *FileHashes) filepath.WalkFunc {
+	thumbnailsFolder := GetThumbnailsFolder(fh.dbPath)
+	// Walk is sequential, so rules can be loaded as folders are entered without locking
+	ignores := newIgnoreTree()
 	return func(path string, f os.FileInfo, err error) error {
-		if f == nil || f.IsDir() {
+		if err := ctx.Err(); err != nil {
+			// Stop walking, files that were already queued are still processed
+			return err
+		}
+		if f != nil && f.IsDir() && path == thumbnailsFolder {
+			// Do not scan thumbnails cached for database
+			return filepath.SkipDir
+		}
+		if f != nil && fh.options.ExcludeHidden && isHidden(f) {
+			log.Debugf("Skipping hidden %s\n", path)
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f != nil && fh.options.Filter != nil && !fh.options.Filter.Allows(path, f.IsDir()) {
+			log.Debugf("Skipping excluded %s\n", path)
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f != nil && ignores.ignored(path, f.IsDir()) {
+			log.Debugf("Skipping ignored %s\n", path)
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f != nil && f.IsDir() {
+			if err := ignores.load(path); err != nil {
+				log.Warningf("Failed to read %s in %s: %s\n", ignoreFileName, path, err)
+			}
+			return nil
+		}
+		if f == nil || f.Name() == ignoreFileName {
+			// Ignore files are not recorded, so that copies of them are never reported
+			return nil
+		}
+		if fh.options.NewOnly {
+			fh.lock.RLock()
+			known := fh.files[path] != nil
+			fh.lock.RUnlock()
+			if known {
+				return nil
+			}
+		}
+		if f.Mode()&os.ModeSymlink != 0 && !isRecordedSymlink(path, fh.options) {
+			// Symlinks are not copies, e.g. placeholders left by quarantine, so they are not recorded by default
+			fh.lock.Lock()
+			if record := fh.files[path]; record != nil {
+				removeRecord(fh, record)
+			}
+			fh.lock.Unlock()
+			return nil
+		}
+		if fh.options.IgnoreEmpty && f.Size() == 0 {
+			log.Debugf("Skipping empty %s\n", path)
+			fh.lock.Lock()
+			if record := fh.files[path]; record != nil {
+				removeRecord(fh, record)
+			}
+			fh.lock.Unlock()
 			return nil
 		}
 		fh.lock.Lock()
 		record := fh.files[path]
 		if record != nil {
 			if checkFileDidNotChange(f, record) {
+				scanArchive := fh.options.ScanArchives && isArchiveFile(path) && !fh.archives[path]
 				fh.lock.Unlock()
+				if scanArchive {
+					fh.wg.Add(1)
+					jobs <- &scanInfo{path: path, f: f, archiveOnly: true}
+				}
 				return nil
 			}
-			log.Warningf("Metadata changed for %s\n", path)
+			if fh.options.RehashTouched {
+				log.Debugf("Metadata changed for %s\n", path)
+			} else {
+				log.Warningf("Metadata changed for %s\n", path)
+			}
 			removeRecord(fh, record)
+			if fh.archives[path] {
+				removeArchiveEntries(fh, path)
+			}
 		}
 		fh.lock.Unlock()
-		jobs <- &scanInfo{path: path, f: f, existingRecord: record}
+		// Job has to be counted before it is queued, otherwise it can be done before it is added
 		fh.wg.Add(1)
+		atomic.AddInt64(&counters.filesQueued, 1)
+		jobs <- &scanInfo{path: path, f: f, existingRecord: record}
 		return nil
 	}
 }
 
-func parseFileMetadata(path string, f os.FileInfo, existingRecord *FileMetadata) (*FileMetadata, error) {
-	log.Infof("Processing %s\n", path)
+// isRecordedSymlink checks if symlink is recorded as reference to its target, which has to be existing file
+// Dangling symlinks are never hashed, they are recorded as broken links instead
+func isRecordedSymlink(path string, opts ParseOptions) bool {
+	target, err := fsys.Stat(path)
+	if err != nil {
+		recordBrokenLink(path, err)
+		return false
+	}
+	if !opts.HashSymlinks {
+		log.Debugf("Skipping symlink %s\n", path)
+		return false
+	}
+	if isRemotePath(path) {
+		// Targets of remote symlinks can not be resolved
+		log.Debugf("Skipping remote symlink %s\n", path)
+		return false
+	}
+	if target.IsDir() {
+		log.Debugf("Skipping symlink to folder %s\n", path)
+		return false
+	}
+	return true
+}
+
+// isHidden checks if file name starts with dot or file has hidden attribute
+func isHidden(f os.FileInfo) bool {
+	name := f.Name()
+	return (len(name) > 1 && strings.HasPrefix(name, ".") && name != "..") || hasHiddenAttribute(f)
+}
+
+// ParseOptions controls how file metadata is extracted
+type ParseOptions struct {
+	// Folder to cache image thumbnails in, thumbnails are not generated when empty
+	ThumbnailsFolder string
+	// Rehash files with same size but different timestamps, and only update timestamps if contents did not change
+	RehashTouched bool
+	// Absolute folder that paths under it are stored relative to in database file, all paths are stored as absolute when empty
+	DBRoot string
+	// Path prefix of records loaded from database that is replaced with RemapTo, e.g. when database was built on another machine
+	RemapFrom string
+	RemapTo   string
+	// Skip files and folders starting with dot or having hidden attribute when scanning
+	ExcludeHidden bool
+	// Calculate audio fingerprints of audio and video files with fpcalc
+	AudioFingerprints bool
+	// Do not check files in directories whose modification time did not change since previous scan
+	SkipUnchangedDirs bool
+	// Scan files inside zip archives and record them with virtual paths, e.g. backup.zip!/photo.jpg
+	ScanArchives bool
+	// Priority of EXIF dates used as shooting date, DefaultDateTags are used when empty
+	DateTags []string
+	// Layouts of dates in file names used when media has no date, file names are not checked when empty
+	FilenameDates []FilenameDateLayout
+	// Use earlier of creation and modification time as shooting date when file has no other date
+	FilesystemDates bool
+	// Do not record empty files at all, recorded ones are only reported as duplicates with MatchEmpty
+	IgnoreEmpty bool
+	// Index empty files, so that they are reported as duplicates of each other
+	MatchEmpty bool
+	// Log summary of scan progress at this interval instead of logging every processed file, when positive
+	ProgressInterval time.Duration
+	// Only calculate image hashes of files whose size matches size of another file, after all files are scanned
+	// Images that only match image of different size (e.g. with edited metadata) are missed
+	LazyImageHashes bool
+	// Record symlinks to files as references to their targets with hash of target, they are skipped when false
+	HashSymlinks bool
+	// Size of chunks hashed separately to find files sharing part of contents, chunks are not hashed when 0
+	ChunkSize int64
+	// Include and exclude patterns selecting scanned files, all files are scanned when nil
+	Filter *PathFilter
+	// Trust existing records without checking files, only paths that are not recorded yet are parsed
+	// Changed files are not rehashed and removed files are not dropped, so records may be stale
+	NewOnly bool
+	// Abandon parsing of file that takes longer than this, e.g. on stale network mount, files are parsed without timeout when 0
+	FileTimeout time.Duration
+}
+
+// parseFileMetadata reads record of file and counts file as parsed or failed
+func parseFileMetadata(path string, f os.FileInfo, existingRecord *FileMetadata, opts ParseOptions) (*FileMetadata, error) {
+	record, err := readFileMetadata(path, f, existingRecord, opts)
+	countParseResult(err)
+	return record, err
+}
+
+// countParseResult counts file as parsed or failed, each file is counted once, abandoned reads are not counted again when they return
+func countParseResult(err error) {
+	if err != nil {
+		atomic.AddInt64(&counters.parseErrors, 1)
+	} else {
+		atomic.AddInt64(&counters.filesParsed, 1)
+	}
+}
+
+func readFileMetadata(path string, f os.FileInfo, existingRecord *FileMetadata, opts ParseOptions) (*FileMetadata, error) {
+	if opts.ProgressInterval > 0 {
+		log.Debugf("Processing %s\n", path)
+	} else {
+		log.Infof("Processing %s\n", path)
+	}
 	fileHash, err := getFileHash(path)
 	if err != nil {
 		return nil, err
 	}
-	imageHash, err := getImageHash(path)
-	if err != nil {
-		log.Debugf("Not an image %s\n", path)
+	if f.Mode()&os.ModeSymlink != 0 {
+		return getSymlinkMetadata(path, f, fileHash, existingRecord)
+	}
+	atomic.AddInt64(&counters.bytesHashed, f.Size())
+	if opts.RehashTouched && existingRecord != nil && existingRecord.Size == f.Size() {
+		if fileHash == existingRecord.FileHash {
+			// Only timestamps were changed, e.g. by sync tool, keep the rest of metadata as is
+			log.Debugf("Timestamps changed for %s\n", path)
+			atomic.AddInt64(&counters.touchedFiles, 1)
+			record := *existingRecord
+			record.Created = getCreationTime(path, f)
+			record.Modified = f.ModTime()
+			record.DeviceID, record.Inode = getFileID(f)
+			return &record, nil
+		}
+		atomic.AddInt64(&counters.editedFiles, 1)
+	}
+	imageHash, corrupt := "", ""
+	if !opts.LazyImageHashes {
+		imageHash, corrupt = getImageMetadata(path, opts.ThumbnailsFolder)
 	}
-	dateShot, err := getMediaDate(path)
+	var chunkHashes []string
+	if opts.ChunkSize > 0 {
+		if chunkHashes, err = getChunkHashes(path, opts.ChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	creationTime := getCreationTime(path, f)
+	dateShot, err := getMediaDate(path, opts.DateTags, opts.FilenameDates)
 	if err != nil {
 		log.Debugf("Not a supported media file %s\n", path)
+		if opts.FilesystemDates {
+			dateShot = getFilesystemDate(creationTime, f.ModTime())
+			log.Infof("Using file system date for %s\n", path)
+		}
 	}
-	creationTime := getCreationTime(f)
-	if existingRecord != nil && (fileHash != existingRecord.FileHash || imageHash != existingRecord.ImageHash || dateShot != existingRecord.DateShot) {
+	duration := 0.0
+	if isVideoFile(path) {
+		if duration, err = getVideoDuration(path); err != nil {
+			log.Debugf("No video duration for %s: %s\n", path, err)
+		}
+	}
+	audioDuration, audioFingerprint := 0.0, ""
+	if opts.AudioFingerprints && isAudioFile(path) {
+		audioDuration, audioFingerprint, err = getAudioFingerprint(path)
+		if err != nil {
+			log.Debugf("No audio fingerprint for %s: %s\n", path, err)
+		}
+	}
+	firstSeen := time.Now()
+	if existingRecord != nil {
+		// Preserve time when path was first recorded across refreshes
+		firstSeen = existingRecord.FirstSeen
+	}
+	// Image hash is not known yet when it is calculated lazily
+	if existingRecord != nil && (fileHash != existingRecord.FileHash || (!opts.LazyImageHashes && imageHash != existingRecord.ImageHash) || dateShot != existingRecord.DateShot) {
 		log.Warningf("Contents changed for %s\n", path)
 	}
-	return &FileMetadata{Path: path, Created: creationTime, Modified: f.ModTime(), Size: f.Size(), FileHash: fileHash, ImageHash: imageHash, DateShot: dateShot}, nil
+	deviceID, inode := getFileID(f)
+	return &FileMetadata{Path: path, Created: creationTime, Modified: f.ModTime(), Size: f.Size(), FileHash: fileHash, ImageHash: imageHash, DateShot: dateShot, FirstSeen: firstSeen, DeviceID: deviceID, Inode: inode, AudioDuration: audioDuration, AudioFingerprint: audioFingerprint, Corrupt: corrupt, ImageHashPending: opts.LazyImageHashes, ChunkSize: opts.ChunkSize, ChunkHashes: chunkHashes, Duration: duration}, nil
+}
+
+// getSymlinkMetadata returns record of symlink with hash of its target, timestamps and size are of symlink itself,
+// so that it is only parsed again when symlink changes
+func getSymlinkMetadata(path string, f os.FileInfo, fileHash string, existingRecord *FileMetadata) (*FileMetadata, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return nil, err
+	}
+	firstSeen := time.Now()
+	if existingRecord != nil {
+		firstSeen = existingRecord.FirstSeen
+	}
+	deviceID, inode := getFileID(f)
+	return &FileMetadata{Path: path, Created: getCreationTime(path, f), Modified: f.ModTime(), Size: f.Size(), FileHash: fileHash, FirstSeen: firstSeen, DeviceID: deviceID, Inode: inode, SymlinkTarget: target}, nil
+}
+
+// getImageMetadata returns image hash of file, or reason why it is possibly corrupt, both are empty for other files
+func getImageMetadata(path string, thumbnailsFolder string) (string, string) {
+	image, err := readImage(path)
+	imageHash := ""
+	if err == nil {
+		imageHash, err = getImageHash(path, image)
+	}
+	if errors.Is(err, ErrCorruptImage) {
+		// Hash of partially decoded image is meaningless, so image is only flagged
+		log.Warningf("Failed to decode %s: %s\n", path, err)
+		return "", err.Error()
+	} else if err != nil {
+		log.Debugf("Not an image %s\n", path)
+		return "", ""
+	}
+	if len(thumbnailsFolder) > 0 {
+		if err := writeThumbnail(getThumbnailPath(thumbnailsFolder, imageHash), image); err != nil {
+			log.Warningf("Failed to save thumbnail for %s: %s\n", path, err)
+		}
+	}
+	return imageHash, ""
+}
+
+// getFilesystemDate returns earlier of creation and modification times, ignoring unknown ones
+func getFilesystemDate(created time.Time, modified time.Time) time.Time {
+	if !created.IsZero() && (modified.IsZero() || created.Before(modified)) {
+		return created
+	}
+	return modified
 }
 
 // checkFileDidNotChange checks that file on record wasn't changed
 func checkFileDidNotChange(f os.FileInfo, record *FileMetadata) bool {
-	return !f.IsDir() && f.Size() == record.Size && getCreationTime(f) == record.Created && f.ModTime() == record.Modified && len(record.FileHash) > 0
+	return !f.IsDir() && f.Size() == record.Size && getCreationTime(record.Path, f) == record.Created && f.ModTime() == record.Modified && len(record.FileHash) > 0
 }
 
 // ReadDB reads cache database, checks and refreshes outdated file records
-func ReadDB(dbPath string, compact bool) (*FileHashes, error) {
-	return readDB(dbPath, compact, readDBRecord, updateToAbsolutePath)
+func ReadDB(dbPath string, compact bool, opts ParseOptions) (*FileHashes, error) {
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	lockFile, err := lockDB(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := replayWAL(absPath); err != nil {
+		releaseDB(lockFile)
+		return nil, err
+	}
+	touched, edited := atomic.LoadInt64(&counters.touchedFiles), atomic.LoadInt64(&counters.editedFiles)
+	loadRecord := readDBRecord
+	if opts.NewOnly {
+		// Records are trusted as they are, so that files are not checked while loading
+		loadRecord = simulateDBRecord
+	}
+	fh, err := readDB(dbPath, compact, false, opts, loadRecord, updateToAbsolutePath)
+	if err != nil {
+		releaseDB(lockFile)
+		return nil, err
+	}
+	fh.lockFile = lockFile
+	if opts.RehashTouched {
+		logTouchedFiles(touched, edited)
+	}
+	return fh, nil
+}
+
+// ReadSimulatedDB reads database records as they are cached without checking files, so that duplicate search can be evaluated quickly
+// Database is neither locked nor modified, so files must not be moved based on its contents
+func ReadSimulatedDB(dbPath string, opts ParseOptions) (*FileHashes, error) {
+	return readDB(dbPath, false, false, opts, simulateDBRecord, updateToAbsolutePath)
+}
+
+// logTouchedFiles logs how many files had only timestamps changed and how many were edited since counters had given values
+func logTouchedFiles(touched int64, edited int64) {
+	touched = atomic.LoadInt64(&counters.touchedFiles) - touched
+	edited = atomic.LoadInt64(&counters.editedFiles) - edited
+	log.Infof("Files with only timestamps changed: %d, files with contents changed: %d\n", touched, edited)
 }
 
 // ScanFolders scans specified paths and adds them to database
 func ScanFolders(folders []string, fh *FileHashes, concurrency int) error {
+	return ScanFoldersContext(context.Background(), folders, fh, concurrency)
+}
+
+// ScanFoldersContext scans folders until context is done, files queued before that are still processed and saved
+// Context error is returned if scan was stopped before all folders were scanned
+func ScanFoldersContext(ctx context.Context, folders []string, fh *FileHashes, concurrency int) error {
 	log.Infof("Scanning paths\n")
+	touched, edited := atomic.LoadInt64(&counters.touchedFiles), atomic.LoadInt64(&counters.editedFiles)
 	jobs := make(chan *scanInfo, concurrency*4)
 	results := make(chan *FileMetadata, concurrency*4)
 	for w := 0; w < concurrency; w++ {
-		go makeParserWorker(&fh.wg, jobs, results)
+		go makeParserWorker(&fh.wg, jobs, results, fh.options)
 	}
 	go makeAdderWorker(results, fh)
-	walkFunc := makeWalkFunc(jobs, fh)
+	if fh.options.ProgressInterval > 0 {
+		progressCtx, stopProgress := context.WithCancel(context.Background())
+		defer stopProgress()
+		go newScanProgress().log(progressCtx, fh.options.ProgressInterval)
+	}
+	walkFunc := makeWalkFunc(ctx, jobs, fh)
+	if fh.options.SkipUnchangedDirs && fh.dirTimes == nil {
+		dirTimes, err := loadDirTimes(getDirTimesPath(fh.dbPath))
+		if err != nil {
+			return err
+		}
+		fh.dirTimes = dirTimes
+	}
+	scanned := make([]string, 0, len(folders))
 	for _, path := range folders {
-		path, err := filepath.Abs(path)
+		path, err := absPath(path)
 		if err != nil {
 			return err
 		}
+		if f, err := fsys.Lstat(path); err == nil && !f.IsDir() {
+			// Single files are only processed, so that long lists of files are not logged twice or counted in folder stats
+			if err := walkFunc(path, f, nil); err != nil && err == ctx.Err() {
+				log.Warningf("Stopped scanning %s: %s\n", path, err)
+				break
+			}
+			continue
+		}
+		scanned = append(scanned, path)
 		log.Infof("Scanning %s\n", path)
-		err = filepath.Walk(path, walkFunc)
-		if err != nil {
+		if fh.options.SkipUnchangedDirs {
+			err = walkChangedDirs(path, fh.dirTimes, walkFunc)
+		} else {
+			err = fsys.Walk(path, walkFunc)
+		}
+		if err != nil && err == ctx.Err() {
+			log.Warningf("Stopped scanning %s: %s\n", path, err)
+			break
+		} else if err != nil {
 			return err
 		}
 		log.Infof("Finished scanning %s\n", path)
@@ -117,18 +534,120 @@ func ScanFolders(folders []string, fh *FileHashes, concurrency int) error {
 	close(jobs)
 	fh.wg.Wait()
 	close(results)
+	hashPendingImages(fh, concurrency)
+	if fh.options.SkipUnchangedDirs {
+		if err := saveDirTimes(getDirTimesPath(fh.dbPath), fh.dirTimes); err != nil {
+			return err
+		}
+	}
+	if fh.options.RehashTouched {
+		logTouchedFiles(touched, edited)
+	}
+	logScanFailures()
+	logBrokenLinks()
+	logTypeStats(scanned, fh)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	log.Infof("Finished scanning all paths\n")
 	return nil
 }
 
+type typeStats struct {
+	ext       string
+	count     int
+	size      int64
+	images    int
+	withDates int
+}
+
+// logTypeStats logs number of files, their total size and how many of them were recognized as images
+// or had shooting date for each file extension found under scanned paths
+func logTypeStats(paths []string, fh *FileHashes) {
+	fh.lock.RLock()
+	defer fh.lock.RUnlock()
+	stats := make(map[string]*typeStats)
+	for recordPath, record := range fh.files {
+		if !isUnderAny(recordPath, paths) {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(recordPath))
+		s := stats[ext]
+		if s == nil {
+			s = &typeStats{ext: ext}
+			stats[ext] = s
+		}
+		s.count++
+		s.size += record.Size
+		if len(record.ImageHash) > 0 {
+			s.images++
+		}
+		if !record.DateShot.IsZero() {
+			s.withDates++
+		}
+	}
+	sorted := make([]*typeStats, 0, len(stats))
+	for _, s := range stats {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].size != sorted[j].size {
+			return sorted[i].size > sorted[j].size
+		}
+		return sorted[i].ext < sorted[j].ext
+	})
+	log.Infof("%-10s %8s %12s %8s %8s\n", "Type", "Files", "Size", "Images", "Dated")
+	for _, s := range sorted {
+		ext := s.ext
+		if len(ext) == 0 {
+			ext = "(none)"
+		}
+		log.Infof("%-10s %8d %12s %8d %8d\n", ext, s.count, formatSize(s.size), s.images, s.withDates)
+	}
+}
+
+// isUnderAny checks if path is one of folders or is located under one of them
+func isUnderAny(path string, folders []string) bool {
+	for _, folder := range folders {
+		if path == folder || strings.HasPrefix(path, fmt.Sprintf("%s%c", folder, filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// simulateDBRecord loads record as is, empty files are still dropped when they are ignored
+func simulateDBRecord(fh *FileHashes, record *FileMetadata) (bool, error) {
+	if fh.options.IgnoreEmpty && record.Size == 0 {
+		return true, nil
+	}
+	return replaceLatestRecord(fh, record)
+}
+
 func readDBRecord(fh *FileHashes, record *FileMetadata) (bool, error) {
-	f, err := os.Stat(record.Path)
+	if fh.options.IgnoreEmpty && record.Size == 0 {
+		log.Debugf("Dropping empty %s\n", record.Path)
+		return true, nil
+	}
+	if archivePath, _, ok := splitArchivePath(record.Path); ok {
+		return readArchiveEntryRecord(fh, record, archivePath)
+	}
+	stat := fsys.Stat
+	if len(record.SymlinkTarget) > 0 {
+		// Symlink records describe symlink itself, following it would parse link again as regular copy of its target
+		stat = fsys.Lstat
+	}
+	f, err := stat(record.Path)
 	if os.IsNotExist(err) {
 		log.Warningf("File not found %s\n", record.Path)
 		return true, nil
 	} else if err != nil {
 		return false, err
 	}
+	if fh.options.IgnoreEmpty && f.Size() == 0 {
+		log.Debugf("Dropping empty %s\n", record.Path)
+		return true, nil
+	}
 	if fh.files[record.Path] != nil && checkFileDidNotChange(f, fh.files[record.Path]) {
 		log.Debugf("Already have accurate record for %s\n", record.Path)
 		return true, nil
@@ -139,7 +658,7 @@ func readDBRecord(fh *FileHashes, record *FileMetadata) (bool, error) {
 		return replaceLatestRecord(fh, record)
 	}
 	log.Debugf("Refreshing changed file %s\n", record.Path)
-	record, err = parseFileMetadata(record.Path, f, record)
+	record, err = parseFileMetadata(record.Path, f, record, fh.options)
 	if err != nil {
 		return false, err
 	}
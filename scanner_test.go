@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	logging "github.com/op/go-logging"
 )
@@ -12,6 +17,220 @@ func BenchmarkParseFileMetadata(b *testing.B) {
 	path := "samples/sample.jpg"
 	f, _ := os.Stat(path)
 	for n := 0; n < b.N; n++ {
-		parseFileMetadata(path, f, nil)
+		parseFileMetadata(path, f, nil, ParseOptions{})
+	}
+}
+
+func TestParseFileMetadataFlagsTruncatedImage(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "truncated.jpg")
+	if err := ioutil.WriteFile(path, data[:len(data)*9/10], 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Stat(path)
+	record, err := parseFileMetadata(path, f, nil, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.ImageHash) > 0 || len(record.Corrupt) == 0 {
+		t.Errorf("Expected truncated image to be flagged as corrupt without image hash, got %+v", record)
+	}
+	f, _ = os.Stat("samples/sample.jpg")
+	record, err = parseFileMetadata("samples/sample.jpg", f, nil, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.ImageHash) == 0 || len(record.Corrupt) > 0 {
+		t.Errorf("Expected valid image to be hashed, got %+v", record)
+	}
+}
+
+func TestScanLazyImageHashes(t *testing.T) {
+	data, err := ioutil.ReadFile("samples/sample.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	for name, contents := range map[string][]byte{"a.jpg": data, "b.jpg": data, "tagged.jpg": append(append([]byte{}, data...), "<x:xmpmeta/>"...)} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), contents, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{LazyImageHashes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{root}, fh, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if record := fh.files[filepath.Join(root, name)]; len(record.ImageHash) == 0 || record.ImageHashPending {
+			t.Errorf("Expected image with same size as another file to be hashed, got %+v", record)
+		}
+	}
+	tagged := fh.files[filepath.Join(root, "tagged.jpg")]
+	if len(tagged.ImageHash) > 0 || !tagged.ImageHashPending {
+		t.Errorf("Expected image with unique size to be pending, got %+v", tagged)
+	}
+	// Pending images are hashed by scan without lazy hashes
+	fh.options.LazyImageHashes = false
+	if err := ScanFolders([]string{root}, fh, 2); err != nil {
+		t.Fatal(err)
+	}
+	tagged = fh.files[filepath.Join(root, "tagged.jpg")]
+	if tagged.ImageHash != fh.files[filepath.Join(root, "a.jpg")].ImageHash || tagged.ImageHashPending {
+		t.Errorf("Expected pending image to be hashed, got %+v", tagged)
+	}
+}
+
+func TestScanSymlinks(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "target.txt")
+	if err := ioutil.WriteFile(target, []byte("contents"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "missing.txt"), filepath.Join(root, "dangling.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, hashSymlinks := range []bool{false, true} {
+		dbPath := filepath.Join(t.TempDir(), "db.txt")
+		fh, err := ReadDB(dbPath, false, ParseOptions{HashSymlinks: hashSymlinks})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ScanFolders([]string{root}, fh, 1); err != nil {
+			t.Fatal(err)
+		}
+		if fh.files[filepath.Join(root, "dangling.txt")] != nil {
+			t.Errorf("Expected dangling symlink to not be recorded (hash symlinks: %v)", hashSymlinks)
+		}
+		found := false
+		for _, link := range getBrokenLinks() {
+			found = found || (link.path == filepath.Join(root, "dangling.txt") && link.target == filepath.Join(root, "missing.txt"))
+		}
+		if !found {
+			t.Errorf("Expected dangling symlink to be reported as broken link (hash symlinks: %v)", hashSymlinks)
+		}
+		link := fh.files[filepath.Join(root, "link.txt")]
+		if !hashSymlinks {
+			if link != nil {
+				t.Errorf("Expected symlink to not be recorded, got %+v", link)
+			}
+			continue
+		}
+		if link == nil || link.SymlinkTarget != target || link.FileHash != fh.files[target].FileHash {
+			t.Fatalf("Expected symlink to be recorded with target and its hash, got %+v", link)
+		}
+		dups, err := FindDuplicates(SearchOptions{}, fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dups) != 0 {
+			t.Errorf("Expected symlink to not be reported as duplicate of target, got %v", dups)
+		}
+		CloseDB(fh)
+		// Symlink record has to survive loading database again
+		fh, err = ReadDB(dbPath, false, ParseOptions{HashSymlinks: hashSymlinks})
+		if err != nil {
+			t.Fatal(err)
+		}
+		link = fh.files[filepath.Join(root, "link.txt")]
+		if link == nil || link.SymlinkTarget != target || isIndexed(link, false) {
+			t.Errorf("Expected reloaded symlink to be recorded with target and not indexed, got %+v", link)
+		}
+		CloseDB(fh)
+	}
+}
+
+// hangingFilesystem is in-memory filesystem whose files at listed paths can not be opened until release is closed, like files on stale network mount
+// Released opens are reported on released, so that test can wait for abandoned reads before filesystem is replaced back
+type hangingFilesystem struct {
+	*memFilesystem
+	hanging  map[string]bool
+	release  chan struct{}
+	released chan string
+}
+
+func (h *hangingFilesystem) Open(path string) (File, error) {
+	if h.hanging[path] {
+		<-h.release
+		defer func() { h.released <- path }()
+	}
+	return h.memFilesystem.Open(path)
+}
+
+func TestScanFileTimeout(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem := useMemFilesystem(t)
+	mem.writeFile("/mnt/stale.txt", "stale", modified)
+	mem.writeFile("/mnt/local.txt", "local", modified)
+	hanging := &hangingFilesystem{memFilesystem: mem, hanging: map[string]bool{"/mnt/stale.txt": true}, release: make(chan struct{}), released: make(chan string, 1)}
+	fsys = hanging
+	logging.SetLevel(logging.CRITICAL, "cleaner")
+	parsed, failed := atomic.LoadInt64(&counters.filesParsed), atomic.LoadInt64(&counters.parseErrors)
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.txt"), false, ParseOptions{FileTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(fh)
+	if err := ScanFolders([]string{"/mnt"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fh.files["/mnt/local.txt"] == nil {
+		t.Errorf("Expected other files to be scanned after timeout")
+	}
+	if fh.files["/mnt/stale.txt"] != nil {
+		t.Errorf("Expected timed out file not to be recorded")
+	}
+	found := false
+	for _, failure := range getScanFailures() {
+		if failure.path == "/mnt/stale.txt" {
+			found = errors.Is(failure.err, ErrFileTimeout)
+		}
+	}
+	if !found {
+		t.Errorf("Expected timed out file to be recorded as failed")
+	}
+	// Abandoned read fails once it is released, which should not count file again
+	mem.Remove("/mnt/stale.txt")
+	close(hanging.release)
+	<-hanging.released
+	// Failed read returns right after open
+	time.Sleep(50 * time.Millisecond)
+	if parsed, failed := atomic.LoadInt64(&counters.filesParsed)-parsed, atomic.LoadInt64(&counters.parseErrors)-failed; parsed != 1 || failed != 1 {
+		t.Errorf("Expected 1 parsed and 1 failed file, got %d parsed and %d failed", parsed, failed)
+	}
+}
+
+func TestScanNewOnly(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/import/a.txt", "old", modified},
+	})
+	oldHash := fh.files["/import/a.txt"].FileHash
+	mem.writeFile("/import/a.txt", "changed", modified.Add(time.Hour))
+	mem.writeFile("/import/b.txt", "new", modified)
+	fh.options.NewOnly = true
+	if err := ScanFolders([]string{"/import"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if record := fh.files["/import/a.txt"]; record == nil || record.FileHash != oldHash {
+		t.Errorf("Expected recorded file to be trusted without checking, got %+v", record)
+	}
+	if fh.files["/import/b.txt"] == nil {
+		t.Errorf("Expected new file to be recorded")
+	}
+	fh.options.NewOnly = false
+	if err := ScanFolders([]string{"/import"}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if record := fh.files["/import/a.txt"]; record == nil || record.FileHash == oldHash {
+		t.Errorf("Expected changed file to be parsed again without -new-only, got %+v", record)
 	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// Shells that move scripts are written for
+const (
+	// ScriptShellSh writes POSIX shell script
+	ScriptShellSh = "sh"
+	// ScriptShellPowerShell writes PowerShell script
+	ScriptShellPowerShell = "powershell"
+)
+
+// defaultScriptShell is shell that runs scripts on this system
+func defaultScriptShell() string {
+	if runtime.GOOS == "windows" {
+		return ScriptShellPowerShell
+	}
+	return ScriptShellSh
+}
+
+// MoveScript collects commands that move duplicates into shell script, so that they can be reviewed and run manually
+type MoveScript struct {
+	w     *bufio.Writer
+	shell string
+	// Folders that are already created by script
+	dirs map[string]bool
+}
+
+// NewMoveScript starts script for shell, commands stop at first failure when it is run
+func NewMoveScript(w io.Writer, shell string) (*MoveScript, error) {
+	script := &MoveScript{w: bufio.NewWriter(w), shell: shell, dirs: make(map[string]bool)}
+	switch shell {
+	case ScriptShellSh:
+		script.line("#!/bin/sh")
+		script.line("set -e")
+	case ScriptShellPowerShell:
+		script.line("$ErrorActionPreference = 'Stop'")
+	default:
+		return nil, fmt.Errorf("Unknown script shell %s", shell)
+	}
+	return script, nil
+}
+
+func (script *MoveScript) line(format string, args ...interface{}) {
+	fmt.Fprintf(script.w, format+"\n", args...)
+}
+
+// powerShellQuotes escapes quotes in PowerShell single quoted strings, typographic quotes end them as well, e.g. in "John’s iPhone"
+var powerShellQuotes = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+
+// quote quotes path as single argument, single quoted strings are not expanded by either shell
+func (script *MoveScript) quote(path string) string {
+	if script.shell == ScriptShellPowerShell {
+		return "'" + powerShellQuotes.Replace(path) + "'"
+	}
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// Mkdir creates folder with its parents unless script already created it
+func (script *MoveScript) Mkdir(dir string) {
+	if script.dirs[dir] {
+		return
+	}
+	script.dirs[dir] = true
+	if script.shell == ScriptShellPowerShell {
+		script.line("New-Item -ItemType Directory -Force -Path %s | Out-Null", script.quote(dir))
+	} else {
+		script.line("mkdir -p -- %s", script.quote(dir))
+	}
+}
+
+// Move moves file without overwriting destination
+func (script *MoveScript) Move(path string, newPath string) {
+	if script.shell == ScriptShellPowerShell {
+		script.line("Move-Item -LiteralPath %s -Destination %s", script.quote(path), script.quote(newPath))
+	} else {
+		script.line("mv -n -- %s %s", script.quote(path), script.quote(newPath))
+	}
+}
+
+// Remove deletes file
+func (script *MoveScript) Remove(path string) {
+	if script.shell == ScriptShellPowerShell {
+		script.line("Remove-Item -LiteralPath %s", script.quote(path))
+	} else {
+		script.line("rm -- %s", script.quote(path))
+	}
+}
+
+// Symlink creates symlink at path pointing to target
+func (script *MoveScript) Symlink(target string, path string) {
+	if script.shell == ScriptShellPowerShell {
+		script.line("New-Item -ItemType SymbolicLink -Path %s -Target %s | Out-Null", script.quote(path), script.quote(target))
+	} else {
+		script.line("ln -s -- %s %s", script.quote(target), script.quote(path))
+	}
+}
+
+// Flush writes buffered commands
+func (script *MoveScript) Flush() error {
+	return script.w.Flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveScriptQuote(t *testing.T) {
+	tests := []struct {
+		shell    string
+		path     string
+		expected string
+	}{
+		{ScriptShellSh, "/lib/a.jpg", `'/lib/a.jpg'`},
+		{ScriptShellSh, "/lib/it's $HOME `x`.jpg", `'/lib/it'\''s $HOME ` + "`x`" + `.jpg'`},
+		{ScriptShellPowerShell, `C:\lib\a [1].jpg`, `'C:\lib\a [1].jpg'`},
+		{ScriptShellPowerShell, `C:\lib\it's $env:HOME.jpg`, `'C:\lib\it''s $env:HOME.jpg'`},
+		{ScriptShellPowerShell, "C:\\lib\\John\u2019s iPhone\u2019; rm x\u201b.jpg", "'C:\\lib\\John\u2019\u2019s iPhone\u2019\u2019; rm x\u201b\u201b.jpg'"},
+	}
+	for _, test := range tests {
+		script, err := NewMoveScript(&bytes.Buffer{}, test.shell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quoted := script.quote(test.path); quoted != test.expected {
+			t.Errorf("%s: expected %s to be quoted as %s, got %s", test.shell, test.path, test.expected, quoted)
+		}
+	}
+	if _, err := NewMoveScript(&bytes.Buffer{}, "cmd"); err == nil {
+		t.Error("Expected unknown shell to be rejected")
+	}
+}
+
+func TestMoveDuplicatesScript(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/IMG_0001.JPG", "image", modified},
+		{"/lib/incoming/it's/IMG_0001.JPG", "image", modified},
+		{"/lib/incoming/it's/IMG_0001.xmp", "<x:xmpmeta/>", modified},
+	})
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	script, err := NewMoveScript(&out, ScriptShellSh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Quarantine: true, Sidecars: SidecarsDelete, SidecarExtensions: splitExtensions(DefaultSidecarExtensions), Script: script}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	if err := script.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"#!/bin/sh",
+		"set -e",
+		`mkdir -p -- '/removed/incoming/it'\''s'`,
+		`mv -n -- '/lib/incoming/it'\''s/IMG_0001.JPG' '/removed/incoming/it'\''s/IMG_0001.JPG'`,
+		`ln -s -- '/lib/masters/IMG_0001.JPG' '/lib/incoming/it'\''s/IMG_0001.JPG'`,
+		`rm -- '/lib/incoming/it'\''s/IMG_0001.xmp'`,
+	}
+	if strings.TrimSpace(out.String()) != strings.Join(expected, "\n") {
+		t.Errorf("Expected script:\n%s\ngot:\n%s", strings.Join(expected, "\n"), out.String())
+	}
+	// Script is written instead of moving files
+	for _, path := range []string{"/lib/incoming/it's/IMG_0001.JPG", "/lib/incoming/it's/IMG_0001.xmp"} {
+		if _, err := mem.Stat(path); err != nil {
+			t.Errorf("Expected %s to stay in place: %v", path, err)
+		}
+	}
+}
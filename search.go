@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// duplicateSearch holds state of duplicate search shared by workers, it is only read while search is running
+type duplicateSearch struct {
+	opts            SearchOptions
+	fh              *FileHashes
+	visited         map[string]*FileMetadata
+	duplicatePrefix string
+	masterPrefix    string
+}
+
+// listingLine is duplicate listing line that is printed once its group is complete
+type listingLine struct {
+	format  string
+	path    string
+	master  string
+	message string
+}
+
+// duplicateGroup is master with its duplicates found by search worker
+type duplicateGroup struct {
+	master *FileMetadata
+	dups   []*FileMetadata
+	lines  []listingLine
+	// Reasons why master was picked over each of duplicates
+	reasons map[*FileMetadata]string
+	// Paths that should not be searched again when search is resumed
+	visited []string
+}
+
+// getHashComponents merges hash buckets with more than one record that share records into groups of records
+// Duplicates are only found within such group, so groups can be searched independently, records without any copies are never listed
+func getHashComponents(fh *FileHashes) [][]*FileMetadata {
+	parent := make(map[string]string)
+	find := func(hash string) string {
+		root := hash
+		for parent[root] != "" && parent[root] != root {
+			root = parent[root]
+		}
+		for hash != root {
+			hash, parent[hash] = parent[hash], root
+		}
+		return root
+	}
+	union := func(a string, b string) {
+		if a, b = find(a), find(b); a != b {
+			parent[a] = b
+		}
+	}
+	for hash, bucket := range fh.hashes {
+		if len(bucket) <= 1 {
+			continue
+		}
+		for _, record := range bucket {
+			// Bucket is either file or image hash of record, other hash links its bucket with this one
+			union(hash, record.FileHash)
+			if len(record.ImageHash) > 0 {
+				union(hash, record.ImageHash)
+			}
+		}
+	}
+	components := make(map[string][]*FileMetadata)
+	listed := make(map[*FileMetadata]bool)
+	for hash, bucket := range fh.hashes {
+		if len(bucket) <= 1 {
+			continue
+		}
+		root := find(hash)
+		for _, record := range bucket {
+			if !listed[record] {
+				listed[record] = true
+				components[root] = append(components[root], record)
+			}
+		}
+	}
+	result := make([][]*FileMetadata, 0, len(components))
+	for _, component := range components {
+		result = append(result, component)
+	}
+	return result
+}
+
+// run searches hash components with workers and returns channel receiving found groups, which is closed when search is complete
+// Workers stop when done is closed
+func (s *duplicateSearch) run(done <-chan struct{}) <-chan duplicateGroup {
+	components := make(chan []*FileMetadata)
+	groups := make(chan duplicateGroup)
+	workers := s.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for component := range components {
+				if !s.searchComponent(component, done, groups) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(groups)
+		defer wg.Wait()
+		defer close(components)
+		for _, component := range getHashComponents(s.fh) {
+			select {
+			case components <- component:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return groups
+}
+
+// searchComponent finds duplicate groups among records of hash component and sends them to groups, returns false if search was stopped
+func (s *duplicateSearch) searchComponent(records []*FileMetadata, done <-chan struct{}, groups chan<- duplicateGroup) bool {
+	opts := s.opts
+	duplicatePrefix, masterPrefix := s.duplicatePrefix, s.masterPrefix
+	// Records only share hashes with records of same component, so visited paths are tracked per component
+	// Visited paths are still needed within component, since image matches and files outside of searched folders can be part of several groups
+	visited := make(map[string]*FileMetadata)
+	for _, record := range records {
+		if s.visited[record.Path] != nil {
+			visited[record.Path] = record
+		}
+	}
+	for _, record := range records {
+		path := record.Path
+		if visited[path] != nil {
+			continue
+		}
+		if len(masterPrefix) > 0 {
+			if !strings.HasPrefix(path, masterPrefix) {
+				continue
+			}
+		} else if len(duplicatePrefix) > 0 && !strings.HasPrefix(path, duplicatePrefix) {
+			continue
+		}
+		prefix := ""
+		dups := make(map[*FileMetadata]bool)
+		if len(masterPrefix) > 0 {
+			// With masters we only care about finding duplicates in duplicates directory
+			prefix = duplicatePrefix
+		} else {
+			// Find masters anywhere given file in duplicates directory
+		}
+		if len(prefix) > 0 {
+			log.Debugf("Looking for duplicates of %s in %s\n", record.Path, prefix)
+		} else {
+			log.Debugf("Looking for duplicates of %s\n", record.Path)
+		}
+		getDupsForFile(record, visited, prefix, s.fh.hashes[record.FileHash], dups, opts.SameExtension)
+		if len(record.ImageHash) > 0 && !opts.FileHashOnly {
+			getDupsForFile(record, visited, prefix, s.fh.hashes[record.ImageHash], dups, opts.SameExtension)
+		}
+		if opts.PerDirectory {
+			keepSameDirectory(record, dups)
+		}
+		if len(dups) == 0 {
+			continue
+		}
+		master, reasons := pickMaster(dups, duplicatePrefix, masterPrefix, opts.Policy)
+		log.Debugf("Picked master: %s (Shot: %s, Created: %s, Modified: %s)\n", master.Path, master.DateShot, master.Created, master.Modified)
+		group := duplicateGroup{master: master, reasons: make(map[*FileMetadata]string)}
+		group.lines = append(group.lines, listingLine{opts.Listing.Master, master.Path, master.Path, ""})
+		visited[master.Path] = master
+		physicalFiles := []*FileMetadata{master}
+		for dup := range dups {
+			if dup == master {
+				continue
+			}
+			if opts.SameFileCheck {
+				if same := findSameFile(physicalFiles, dup); same != nil {
+					group.lines = append(group.lines, listingLine{opts.Listing.Skipped, dup.Path, master.Path, fmt.Sprintf("Same file as %s", same.Path)})
+					visited[dup.Path] = dup
+					continue
+				}
+				physicalFiles = append(physicalFiles, dup)
+			}
+			isStrictMatch := master.FileHash == dup.FileHash
+			matchType := getMatchType(master, dup)
+			log.Debugf("Duplicate File: %s (%s, Shot: %s, Created: %s, Modified: %s)\n", dup.Path, matchType, dup.DateShot, dup.Created, dup.Modified)
+			if opts.Policy.Protected.Contains(dup.Path) {
+				group.lines = append(group.lines, listingLine{opts.Listing.Skipped, dup.Path, master.Path, "Duplicate is protected"})
+				visited[dup.Path] = dup
+			} else if len(masterPrefix) > 0 && strings.HasPrefix(dup.Path, masterPrefix) && masterPrefix != duplicatePrefix {
+				group.lines = append(group.lines, listingLine{opts.Listing.Skipped, dup.Path, master.Path, "Duplicate is in master directory"})
+			} else if len(duplicatePrefix) > 0 && !strings.HasPrefix(dup.Path, duplicatePrefix) {
+				group.lines = append(group.lines, listingLine{opts.Listing.Skipped, dup.Path, master.Path, "Duplicate outside duplicates directory"})
+			} else if len(masterPrefix) > 0 && !strings.HasPrefix(master.Path, masterPrefix) {
+				group.lines = append(group.lines, listingLine{opts.Listing.Skipped, dup.Path, master.Path, "Master is outside of master directory"})
+			} else {
+				if !isStrictMatch {
+					group.lines = append(group.lines, listingLine{opts.Listing.Image, dup.Path, master.Path, reasons[dup]})
+				} else {
+					group.lines = append(group.lines, listingLine{opts.Listing.Duplicate, dup.Path, master.Path, reasons[dup]})
+					visited[dup.Path] = dup
+				}
+				group.dups = append(group.dups, dup)
+				group.reasons[dup] = reasons[dup]
+			}
+		}
+		group.visited = []string{master.Path}
+		for _, dup := range group.dups {
+			if visited[dup.Path] != nil {
+				group.visited = append(group.visited, dup.Path)
+			}
+		}
+		select {
+		case groups <- group:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
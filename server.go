@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DuplicateGroup holds master with its duplicates
+type DuplicateGroup struct {
+	Master     *FileMetadata
+	Duplicates []*FileMetadata
+	// Reasons why master was picked over each of duplicates in same order, e.g. "is larger"
+	Reasons []string
+}
+
+// getDuplicateGroups converts duplicates into list of groups sorted by master path, reasons are empty when not known
+func getDuplicateGroups(dups map[*FileMetadata][]*FileMetadata, reasons map[*FileMetadata]string) []DuplicateGroup {
+	groups := make([]DuplicateGroup, 0, len(dups))
+	for master, list := range dups {
+		group := DuplicateGroup{Master: master, Duplicates: list, Reasons: make([]string, 0, len(list))}
+		for _, dup := range list {
+			group.Reasons = append(group.Reasons, reasons[dup])
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Master.Path < groups[j].Master.Path })
+	return groups
+}
+
+type scanStatus struct {
+	Running  bool
+	Folders  []string
+	Started  time.Time
+	Finished time.Time
+	Error    string
+}
+
+type scanRequest struct {
+	Folders []string
+}
+
+type moveRequest struct {
+	Duplicates   string
+	Masters      string
+	Destination  string
+	RemovePrefix string
+	Apply        bool
+}
+
+type server struct {
+	fh          *FileHashes
+	concurrency int
+	policy      MasterPolicy
+	// Options of moves given on command line, requests only override destination and prefix removed from moved paths
+	moveOptions MoveOptions
+	// Moves are only applied on request when server was started with -yes, since nobody is asked to confirm them
+	allowApply bool
+	// busy is held while scan, search or move is running, so that they never modify database concurrently
+	busy       sync.Mutex
+	statusLock sync.Mutex
+	status     scanStatus
+	// Number of duplicates found by last search
+	duplicates int64
+}
+
+// Serve exposes HTTP API to scan folders, query duplicates and move them
+//
+//	POST /scan {"Folders": [...]} starts scan in background, GET /scan returns its status
+//	GET /duplicates?duplicates=...&masters=... returns duplicate groups
+//	POST /move {"Duplicates", "Masters", "Destination", "RemovePrefix", "Apply"} moves duplicates, other move options are taken from command line
+//	and Apply is refused with 403 Forbidden unless server was started with -yes
+//	GET /metrics returns scan and database metrics in Prometheus format
+//
+// Requests fail with 409 Conflict while another scan or move is running
+func Serve(addr string, fh *FileHashes, concurrency int, policy MasterPolicy, moveOptions MoveOptions, allowApply bool) error {
+	s := &server{fh: fh, concurrency: concurrency, policy: policy, moveOptions: moveOptions, allowApply: allowApply}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scan", s.handleScan)
+	mux.HandleFunc("/duplicates", s.handleDuplicates)
+	mux.HandleFunc("/move", s.handleMove)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	log.Infof("Serving on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Failed to write response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"Error": message})
+}
+
+func (s *server) getStatus() scanStatus {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	return s.status
+}
+
+func (s *server) setStatus(status scanStatus) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status = status
+}
+
+func (s *server) setDuplicates(dups map[*FileMetadata][]*FileMetadata) {
+	count := 0
+	for _, list := range dups {
+		count += len(list)
+	}
+	atomic.StoreInt64(&s.duplicates, int64(count))
+}
+
+func (s *server) handleScan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.getStatus())
+	case http.MethodPost:
+		request := scanRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(request.Folders) == 0 {
+			writeError(w, http.StatusBadRequest, "No folders to scan")
+			return
+		}
+		if !s.busy.TryLock() {
+			writeError(w, http.StatusConflict, "Another operation is running")
+			return
+		}
+		status := scanStatus{Running: true, Folders: request.Folders, Started: time.Now()}
+		s.setStatus(status)
+		go func() {
+			defer s.busy.Unlock()
+			if err := ScanFolders(request.Folders, s.fh, s.concurrency); err != nil {
+				log.Errorf("Scan failed: %s\n", err)
+				status.Error = err.Error()
+			}
+			status.Running = false
+			status.Finished = time.Now()
+			s.setStatus(status)
+		}()
+		writeJSON(w, http.StatusAccepted, status)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Use GET or POST")
+	}
+}
+
+func (s *server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	if !s.busy.TryLock() {
+		writeError(w, http.StatusConflict, "Another operation is running")
+		return
+	}
+	defer s.busy.Unlock()
+	query := r.URL.Query()
+	reasons := make(map[*FileMetadata]string)
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: query.Get("duplicates"), MastersFolder: query.Get("masters"), Policy: s.policy, Concurrency: s.concurrency, Reasons: reasons}, s.fh)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.setDuplicates(dups)
+	writeJSON(w, http.StatusOK, getDuplicateGroups(dups, reasons))
+}
+
+func (s *server) handleMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Use POST")
+		return
+	}
+	request := moveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(request.Destination) == 0 {
+		writeError(w, http.StatusBadRequest, "No destination")
+		return
+	}
+	if request.Apply && !s.allowApply {
+		writeError(w, http.StatusForbidden, "Moves are only applied when server is started with -yes")
+		return
+	}
+	if !s.busy.TryLock() {
+		writeError(w, http.StatusConflict, "Another operation is running")
+		return
+	}
+	defer s.busy.Unlock()
+	reasons := make(map[*FileMetadata]string)
+	dups, err := FindDuplicates(SearchOptions{DuplicatesFolder: request.Duplicates, MastersFolder: request.Masters, Policy: s.policy, Concurrency: s.concurrency, Reasons: reasons}, s.fh)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.setDuplicates(dups)
+	opts := s.moveOptions
+	opts.Destination = request.Destination
+	if len(request.RemovePrefix) > 0 {
+		opts.RemovePrefix = request.RemovePrefix
+	}
+	opts.Apply = request.Apply
+	moved, err := MoveDuplicates(opts, dups, s.fh)
+	if moved {
+		if err := CompactDB(s.fh); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Moved": moved, "Groups": getDuplicateGroups(dups, reasons)})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerMoveApply(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name       string
+		allowApply bool
+		apply      bool
+		status     int
+		moved      bool
+	}{
+		{"dry run", false, false, http.StatusOK, false},
+		{"apply without -yes", false, true, http.StatusForbidden, false},
+		{"apply with -yes", true, true, http.StatusOK, true},
+	}
+	for _, test := range tests {
+		mem, fh := makeMemTestFiles(t, []memTestFile{
+			{"/lib/masters/a.txt", "same", modified},
+			{"/lib/incoming/a.txt", "same", modified},
+		})
+		s := &server{fh: fh, concurrency: 1, allowApply: test.allowApply}
+		body := fmt.Sprintf(`{"Masters": "/lib/masters", "Destination": "/removed", "RemovePrefix": "/lib", "Apply": %t}`, test.apply)
+		response := httptest.NewRecorder()
+		s.handleMove(response, httptest.NewRequest(http.MethodPost, "/move", strings.NewReader(body)))
+		if response.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.status, response.Code, response.Body.String())
+		}
+		_, err := mem.Stat("/removed/incoming/a.txt")
+		if moved := err == nil; moved != test.moved {
+			t.Errorf("%s: expected duplicate to be moved: %t, got %t", test.name, test.moved, moved)
+		}
+	}
+}
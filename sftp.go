@@ -0,0 +1,775 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SFTP version 3 packet types, see draft-ietf-secsh-filexfer-02
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpSymlink  = 20
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpOpenRead = 1
+)
+
+// SFTP status codes
+const (
+	sftpOK               = 0
+	sftpEOF              = 1
+	sftpNoSuchFile       = 2
+	sftpPermissionDenied = 3
+)
+
+// SFTP attribute flags
+const (
+	sftpAttrSize        = 0x1
+	sftpAttrUIDGID      = 0x2
+	sftpAttrPermissions = 0x4
+	sftpAttrTimes       = 0x8
+	sftpAttrExtended    = 0x80000000
+)
+
+// sftpReadSize is size of data requested by single read, servers are only required to serve 32 KiB
+const sftpReadSize = 32 * 1024
+
+// sftpReadAhead is number of reads that are sent before first of them is answered, so that hashing is not bound by round trip time
+const sftpReadAhead = 16
+
+// sshCommand is ssh client that SFTP sessions are started with, so that keys, agent and host settings of user are used
+var sshCommand = "ssh"
+
+// sftpResponse is packet received for request, or error when connection failed before it was received
+type sftpResponse struct {
+	kind byte
+	data []byte
+	err  error
+}
+
+// sftpClient sends requests over SFTP session and matches responses to them by request ids, so that requests can be sent by concurrent scanners
+type sftpClient struct {
+	w io.WriteCloser
+	// Requests are written whole under write lock, which is separate, so that responses are received while request is written
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	nextID    uint32
+	pending   map[uint32]chan sftpResponse
+	// Error that stopped session, all later requests fail with it
+	err error
+	// Waits for ssh to exit once session is closed, nil when session does not run in process
+	wait func() error
+}
+
+// dialSFTP starts SFTP session with host, which may include user and port, e.g. user@host:2222
+func dialSFTP(host string) (*sftpClient, error) {
+	var args []string
+	if name, port, err := net.SplitHostPort(host); err == nil {
+		host = name
+		args = append(args, "-p", port)
+	}
+	args = append(args, "-s", host, "sftp")
+	cmd := exec.Command(sshCommand, args...)
+	// Password and host key prompts go to terminal
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Starting SFTP session with %s\n", host)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	client, err := newSFTPClient(r, w)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("Failed to start SFTP session with %s: %w", host, err)
+	}
+	client.wait = cmd.Wait
+	return client, nil
+}
+
+// newSFTPClient negotiates protocol version over connection and starts receiving responses
+func newSFTPClient(r io.Reader, w io.WriteCloser) (*sftpClient, error) {
+	init := appendUint32([]byte{sftpInit}, 3)
+	if _, err := w.Write(appendUint32(nil, uint32(len(init)))); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(init); err != nil {
+		return nil, err
+	}
+	kind, data, err := readSFTPPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	if kind != sftpVersion || len(data) < 4 {
+		return nil, fmt.Errorf("%w: unexpected packet %d", ErrSFTPProtocol, kind)
+	}
+	if version := binary.BigEndian.Uint32(data); version != 3 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrSFTPProtocol, version)
+	}
+	c := &sftpClient{w: w, pending: make(map[uint32]chan sftpResponse)}
+	go c.receive(r)
+	return c, nil
+}
+
+// readSFTPPacket reads length prefixed packet and returns its type and payload
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > 1<<24 {
+		return 0, nil, fmt.Errorf("%w: packet of %d bytes", ErrSFTPProtocol, length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// receive passes responses to requests waiting for them until connection fails
+func (c *sftpClient) receive(r io.Reader) {
+	for {
+		kind, data, err := readSFTPPacket(r)
+		if err == nil && len(data) < 4 {
+			err = fmt.Errorf("%w: packet %d without id", ErrSFTPProtocol, kind)
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(data)
+		c.lock.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.lock.Unlock()
+		if ch != nil {
+			ch <- sftpResponse{kind: kind, data: data[4:]}
+		}
+	}
+}
+
+// fail stops session with error, requests waiting for responses receive it
+func (c *sftpClient) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		ch <- sftpResponse{err: c.err}
+		delete(c.pending, id)
+	}
+}
+
+// send sends request with payload and returns channel its response is delivered to
+func (c *sftpClient) send(kind byte, payload []byte) (chan sftpResponse, error) {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID++
+	// Buffered, so that receiver never waits for requests that are abandoned
+	// Channel is registered before request is sent, since response may arrive before write returns
+	ch := make(chan sftpResponse, 1)
+	c.pending[id] = ch
+	c.lock.Unlock()
+	packet := appendUint32(nil, uint32(len(payload)+5))
+	packet = append(packet, kind)
+	packet = appendUint32(packet, id)
+	packet = append(packet, payload...)
+	c.writeLock.Lock()
+	_, err := c.w.Write(packet)
+	c.writeLock.Unlock()
+	if err != nil {
+		// Waiting requests can not be answered once part of packet was written
+		c.fail(err)
+		return nil, err
+	}
+	return ch, nil
+}
+
+// request sends request and waits for its response
+func (c *sftpClient) request(kind byte, payload []byte) (sftpResponse, error) {
+	ch, err := c.send(kind, payload)
+	if err != nil {
+		return sftpResponse{}, err
+	}
+	response := <-ch
+	return response, response.err
+}
+
+// requestStatus sends request that is answered with status only
+func (c *sftpClient) requestStatus(kind byte, payload []byte) error {
+	response, err := c.request(kind, payload)
+	if err != nil {
+		return err
+	}
+	return getSFTPError(response, sftpStatus)
+}
+
+// getSFTPError returns error for status response, or for response of other kind than expected
+func getSFTPError(response sftpResponse, expected byte) error {
+	if response.kind == expected && expected != sftpStatus {
+		return nil
+	}
+	if response.kind != sftpStatus {
+		return fmt.Errorf("%w: unexpected packet %d", ErrSFTPProtocol, response.kind)
+	}
+	d := sftpDecoder{data: response.data}
+	code := d.uint32()
+	message := d.string()
+	if d.err != nil {
+		return d.err
+	}
+	switch code {
+	case sftpOK:
+		if expected == sftpStatus {
+			return nil
+		}
+		return fmt.Errorf("%w: unexpected status", ErrSFTPProtocol)
+	case sftpEOF:
+		return io.EOF
+	case sftpNoSuchFile:
+		return os.ErrNotExist
+	case sftpPermissionDenied:
+		return os.ErrPermission
+	}
+	return fmt.Errorf("SFTP error %d: %s", code, message)
+}
+
+// Close ends session and waits for ssh to exit
+func (c *sftpClient) Close() error {
+	err := c.w.Close()
+	if c.wait != nil {
+		c.wait()
+	}
+	return err
+}
+
+func (c *sftpClient) stat(kind byte, remotePath string) (os.FileInfo, error) {
+	response, err := c.request(kind, appendString(nil, remotePath))
+	if err != nil {
+		return nil, err
+	}
+	if err := getSFTPError(response, sftpAttrs); err != nil {
+		return nil, err
+	}
+	d := sftpDecoder{data: response.data}
+	info := d.attrs(path.Base(remotePath))
+	return info, d.err
+}
+
+func (c *sftpClient) getHandle(kind byte, payload []byte) (string, error) {
+	response, err := c.request(kind, payload)
+	if err != nil {
+		return "", err
+	}
+	if err := getSFTPError(response, sftpHandle); err != nil {
+		return "", err
+	}
+	d := sftpDecoder{data: response.data}
+	handle := d.string()
+	return handle, d.err
+}
+
+func (c *sftpClient) open(remotePath string) (*sftpFile, error) {
+	payload := appendString(nil, remotePath)
+	payload = appendUint32(payload, sftpOpenRead)
+	// No attributes are set for opened file
+	payload = appendUint32(payload, 0)
+	handle, err := c.getHandle(sftpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{client: c, handle: handle}, nil
+}
+
+func (c *sftpClient) readDir(remotePath string) ([]os.FileInfo, error) {
+	handle, err := c.getHandle(sftpOpendir, appendString(nil, remotePath))
+	if err != nil {
+		return nil, err
+	}
+	defer c.requestStatus(sftpClose, appendString(nil, handle))
+	var entries []os.FileInfo
+	for {
+		response, err := c.request(sftpReaddir, appendString(nil, handle))
+		if err != nil {
+			return nil, err
+		}
+		if err := getSFTPError(response, sftpName); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		d := sftpDecoder{data: response.data}
+		count := d.uint32()
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			name := d.string()
+			// Long name is meant for humans, e.g. output of ls -l
+			d.string()
+			info := d.attrs(name)
+			if name != "." && name != ".." {
+				entries = append(entries, info)
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (c *sftpClient) mkdirAll(remotePath string) error {
+	if info, err := c.stat(sftpStat, remotePath); err == nil {
+		if !info.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	if parent := path.Dir(remotePath); parent != remotePath {
+		if err := c.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	err := c.requestStatus(sftpMkdir, appendUint32(appendString(nil, remotePath), 0))
+	if err != nil {
+		// Folder may have been created by concurrent mover
+		if info, statErr := c.stat(sftpStat, remotePath); statErr == nil && info.IsDir() {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *sftpClient) remove(remotePath string) error {
+	// Remove only deletes files, folders are removed like os.Remove does
+	if info, err := c.stat(sftpLstat, remotePath); err == nil && info.IsDir() {
+		return c.requestStatus(sftpRmdir, appendString(nil, remotePath))
+	}
+	return c.requestStatus(sftpRemove, appendString(nil, remotePath))
+}
+
+// sftpFile is remote file opened for reading, reads are split into several requests that are sent at once
+type sftpFile struct {
+	client *sftpClient
+	handle string
+	offset int64
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var requests []chan sftpResponse
+	var sizes []int
+	for start := 0; start < len(p) && len(requests) < sftpReadAhead; start += sftpReadSize {
+		size := len(p) - start
+		if size > sftpReadSize {
+			size = sftpReadSize
+		}
+		payload := appendString(nil, f.handle)
+		payload = appendUint64(payload, uint64(f.offset)+uint64(start))
+		payload = appendUint32(payload, uint32(size))
+		ch, err := f.client.send(sftpRead, payload)
+		if err != nil {
+			return 0, err
+		}
+		requests = append(requests, ch)
+		sizes = append(sizes, size)
+	}
+	n := 0
+	var err error
+	for i, ch := range requests {
+		response := <-ch
+		if err != nil {
+			// Data after short read would be placed at wrong offset, so remaining responses are dropped
+			continue
+		}
+		if err = response.err; err != nil {
+			continue
+		}
+		if err = getSFTPError(response, sftpData); err != nil {
+			continue
+		}
+		d := sftpDecoder{data: response.data}
+		data := d.string()
+		if err = d.err; err != nil {
+			continue
+		}
+		n += copy(p[n:], data)
+		if len(data) < sizes[i] {
+			// Short read, e.g. at end of file, is returned as is and next read continues after it
+			err = errShortRead
+		}
+	}
+	f.offset += int64(n)
+	if err == errShortRead || err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// errShortRead stops collecting read responses once one of them returned less data than requested
+var errShortRead = errors.New("Short read")
+
+func (f *sftpFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		response, err := f.client.request(sftpFstat, appendString(nil, f.handle))
+		if err != nil {
+			return 0, err
+		}
+		if err := getSFTPError(response, sftpAttrs); err != nil {
+			return 0, err
+		}
+		d := sftpDecoder{data: response.data}
+		info := d.attrs("")
+		if d.err != nil {
+			return 0, d.err
+		}
+		offset += info.Size()
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *sftpFile) Close() error {
+	return f.client.requestStatus(sftpClose, appendString(nil, f.handle))
+}
+
+// sftpFileInfo describes remote file by attributes returned by server, timestamps have second precision
+type sftpFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f *sftpFileInfo) Name() string       { return f.name }
+func (f *sftpFileInfo) Size() int64        { return f.size }
+func (f *sftpFileInfo) Mode() os.FileMode  { return f.mode }
+func (f *sftpFileInfo) ModTime() time.Time { return f.modTime }
+func (f *sftpFileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f *sftpFileInfo) Sys() interface{}   { return nil }
+
+// getSFTPFileMode converts Unix permissions and file type reported by server to os.FileMode
+func getSFTPFileMode(permissions uint32) os.FileMode {
+	mode := os.FileMode(permissions & 0777)
+	switch permissions & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0100000:
+	default:
+		mode |= os.ModeIrregular
+	}
+	return mode
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// sftpDecoder reads fields of packet payload, first error is kept and later fields are read as zero values
+type sftpDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *sftpDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = fmt.Errorf("%w: truncated packet", ErrSFTPProtocol)
+		return nil
+	}
+	field := d.data[:n]
+	d.data = d.data[n:]
+	return field
+}
+
+func (d *sftpDecoder) uint32() uint32 {
+	if field := d.take(4); field != nil {
+		return binary.BigEndian.Uint32(field)
+	}
+	return 0
+}
+
+func (d *sftpDecoder) uint64() uint64 {
+	if field := d.take(8); field != nil {
+		return binary.BigEndian.Uint64(field)
+	}
+	return 0
+}
+
+func (d *sftpDecoder) string() string {
+	return string(d.take(int(d.uint32())))
+}
+
+func (d *sftpDecoder) attrs(name string) *sftpFileInfo {
+	info := &sftpFileInfo{name: name}
+	flags := d.uint32()
+	if flags&sftpAttrSize != 0 {
+		info.size = int64(d.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		info.mode = getSFTPFileMode(d.uint32())
+	}
+	if flags&sftpAttrTimes != 0 {
+		// Access time is not used
+		d.uint32()
+		info.modTime = time.Unix(int64(d.uint32()), 0)
+	}
+	if flags&sftpAttrExtended != 0 {
+		count := d.uint32()
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			d.string()
+			d.string()
+		}
+	}
+	return info
+}
+
+// sftpConnection is SFTP session with host, or error it failed to start with, so that unreachable host is not dialed for every file
+type sftpConnection struct {
+	client *sftpClient
+	err    error
+}
+
+// remoteFilesystem passes paths on remote hosts (e.g. sftp:/user@host/path) to SFTP sessions and other paths to local filesystem
+// Sessions are started on first use and kept open until process exits
+type remoteFilesystem struct {
+	local       Filesystem
+	dial        func(host string) (*sftpClient, error)
+	lock        sync.Mutex
+	connections map[string]*sftpConnection
+}
+
+func newRemoteFilesystem(local Filesystem) *remoteFilesystem {
+	return &remoteFilesystem{local: local, dial: dialSFTP, connections: make(map[string]*sftpConnection)}
+}
+
+// getClient returns SFTP session for host of remote path along with path on host, ok is false for local paths
+func (r *remoteFilesystem) getClient(op string, fullPath string) (*sftpClient, string, bool, error) {
+	host, remotePath, ok := splitRemotePath(fullPath)
+	if !ok {
+		return nil, "", false, nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	connection := r.connections[host]
+	if connection == nil {
+		connection = &sftpConnection{}
+		connection.client, connection.err = r.dial(host)
+		r.connections[host] = connection
+	}
+	if connection.err != nil {
+		return nil, "", true, &os.PathError{Op: op, Path: fullPath, Err: connection.err}
+	}
+	return connection.client, remotePath, true, nil
+}
+
+func (r *remoteFilesystem) Open(fullPath string) (File, error) {
+	client, remotePath, ok, err := r.getClient("open", fullPath)
+	if !ok {
+		return r.local.Open(fullPath)
+	} else if err != nil {
+		return nil, err
+	}
+	f, err := client.open(remotePath)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fullPath, Err: err}
+	}
+	return f, nil
+}
+
+func (r *remoteFilesystem) stat(op string, kind byte, fullPath string, local func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	client, remotePath, ok, err := r.getClient(op, fullPath)
+	if !ok {
+		return local(fullPath)
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := client.stat(kind, remotePath)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: fullPath, Err: err}
+	}
+	return info, nil
+}
+
+func (r *remoteFilesystem) Stat(fullPath string) (os.FileInfo, error) {
+	return r.stat("stat", sftpStat, fullPath, r.local.Stat)
+}
+
+func (r *remoteFilesystem) Lstat(fullPath string) (os.FileInfo, error) {
+	return r.stat("lstat", sftpLstat, fullPath, r.local.Lstat)
+}
+
+func (r *remoteFilesystem) ReadDir(fullPath string) ([]os.FileInfo, error) {
+	client, remotePath, ok, err := r.getClient("readdir", fullPath)
+	if !ok {
+		return r.local.ReadDir(fullPath)
+	} else if err != nil {
+		return nil, err
+	}
+	entries, err := client.readDir(remotePath)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: fullPath, Err: err}
+	}
+	return entries, nil
+}
+
+func (r *remoteFilesystem) Walk(root string, walkFn filepath.WalkFunc) error {
+	if !isRemotePath(root) {
+		return r.local.Walk(root, walkFn)
+	}
+	info, err := r.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = r.walk(filepath.Clean(root), info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walk walks remote tree like filepath.Walk, entries are described by attributes listed with folder instead of being stat'ed one by one
+func (r *remoteFilesystem) walk(fullPath string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if err := walkFn(fullPath, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := r.ReadDir(fullPath)
+	if err != nil {
+		return walkFn(fullPath, info, err)
+	}
+	for _, entry := range entries {
+		if err := r.walk(filepath.Join(fullPath, entry.Name()), entry, walkFn); err != nil && (err != filepath.SkipDir || !entry.IsDir()) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *remoteFilesystem) Rename(oldpath, newpath string) error {
+	oldHost, _, oldRemote := splitRemotePath(oldpath)
+	newHost, newRemotePath, newRemote := splitRemotePath(newpath)
+	if !oldRemote && !newRemote {
+		return r.local.Rename(oldpath, newpath)
+	}
+	if oldHost != newHost {
+		// Like moving between local volumes, files are never copied between hosts
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	client, oldRemotePath, _, err := r.getClient("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	if err := client.requestStatus(sftpRename, appendString(appendString(nil, oldRemotePath), newRemotePath)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+func (r *remoteFilesystem) Remove(fullPath string) error {
+	client, remotePath, ok, err := r.getClient("remove", fullPath)
+	if !ok {
+		return r.local.Remove(fullPath)
+	} else if err != nil {
+		return err
+	}
+	if err := client.remove(remotePath); err != nil {
+		return &os.PathError{Op: "remove", Path: fullPath, Err: err}
+	}
+	return nil
+}
+
+func (r *remoteFilesystem) MkdirAll(fullPath string, perm os.FileMode) error {
+	client, remotePath, ok, err := r.getClient("mkdir", fullPath)
+	if !ok {
+		return r.local.MkdirAll(fullPath, perm)
+	} else if err != nil {
+		return err
+	}
+	if err := client.mkdirAll(remotePath); err != nil {
+		return &os.PathError{Op: "mkdir", Path: fullPath, Err: err}
+	}
+	return nil
+}
+
+func (r *remoteFilesystem) Symlink(oldname, newname string) error {
+	client, remotePath, ok, err := r.getClient("symlink", newname)
+	if !ok {
+		return r.local.Symlink(oldname, newname)
+	} else if err != nil {
+		return err
+	}
+	// Link can only point to file on same host
+	host, target, remoteTarget := splitRemotePath(oldname)
+	if newHost, _, _ := splitRemotePath(newname); !remoteTarget || host != newHost {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	// OpenSSH takes target before link path, unlike protocol draft, and other servers follow it
+	if err := client.requestStatus(sftpSymlink, appendString(appendString(nil, target), remotePath)); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
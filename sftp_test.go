@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sftpTestServer serves folder over SFTP version 3, absolute paths on host are resolved inside root
+type sftpTestServer struct {
+	root    string
+	files   map[string]*os.File
+	dirs    map[string][]os.FileInfo
+	handles int
+}
+
+// startSFTPTestServer serves root on in-memory connection and returns client connected to it
+func startSFTPTestServer(t *testing.T, root string) *sftpClient {
+	requests, requestsWriter := io.Pipe()
+	responses, responsesWriter := io.Pipe()
+	server := &sftpTestServer{root: root, files: make(map[string]*os.File), dirs: make(map[string][]os.FileInfo)}
+	go server.serve(requests, responsesWriter)
+	client, err := newSFTPClient(responses, requestsWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func writeSFTPTestPacket(w io.Writer, kind byte, payload []byte) error {
+	packet := appendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, kind)
+	_, err := w.Write(append(packet, payload...))
+	return err
+}
+
+func (s *sftpTestServer) serve(r io.Reader, w io.WriteCloser) {
+	defer w.Close()
+	if kind, _, err := readSFTPPacket(r); err != nil || kind != sftpInit {
+		return
+	}
+	if err := writeSFTPTestPacket(w, sftpVersion, appendUint32(nil, 3)); err != nil {
+		return
+	}
+	for {
+		kind, data, err := readSFTPPacket(r)
+		if err != nil {
+			return
+		}
+		d := &sftpDecoder{data: data[4:]}
+		responseKind, payload := s.handle(kind, d)
+		if err := writeSFTPTestPacket(w, responseKind, append(data[:4:4], payload...)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *sftpTestServer) local(remotePath string) string {
+	return filepath.Join(s.root, filepath.FromSlash(remotePath))
+}
+
+func (s *sftpTestServer) status(err error) (byte, []byte) {
+	code := uint32(sftpOK)
+	switch {
+	case err == io.EOF:
+		code = sftpEOF
+	case os.IsNotExist(err):
+		code = sftpNoSuchFile
+	case os.IsPermission(err):
+		code = sftpPermissionDenied
+	case err != nil:
+		code = 4
+	}
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	return sftpStatus, appendString(appendString(appendUint32(nil, code), message), "")
+}
+
+func (s *sftpTestServer) attrs(info os.FileInfo) []byte {
+	permissions := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		permissions |= 0040000
+	case info.Mode()&os.ModeSymlink != 0:
+		permissions |= 0120000
+	default:
+		permissions |= 0100000
+	}
+	b := appendUint32(nil, sftpAttrSize|sftpAttrPermissions|sftpAttrTimes)
+	b = appendUint64(b, uint64(info.Size()))
+	b = appendUint32(b, permissions)
+	b = appendUint32(b, uint32(info.ModTime().Unix()))
+	return appendUint32(b, uint32(info.ModTime().Unix()))
+}
+
+func (s *sftpTestServer) newHandle() string {
+	s.handles++
+	return fmt.Sprintf("%d", s.handles)
+}
+
+func (s *sftpTestServer) handle(kind byte, d *sftpDecoder) (byte, []byte) {
+	switch kind {
+	case sftpOpen:
+		f, err := os.Open(s.local(d.string()))
+		if err != nil {
+			return s.status(err)
+		}
+		handle := s.newHandle()
+		s.files[handle] = f
+		return sftpHandle, appendString(nil, handle)
+	case sftpOpendir:
+		entries, err := ioutil.ReadDir(s.local(d.string()))
+		if err != nil {
+			return s.status(err)
+		}
+		handle := s.newHandle()
+		s.dirs[handle] = entries
+		return sftpHandle, appendString(nil, handle)
+	case sftpReaddir:
+		handle := d.string()
+		entries := s.dirs[handle]
+		if len(entries) == 0 {
+			return s.status(io.EOF)
+		}
+		s.dirs[handle] = nil
+		payload := appendUint32(nil, uint32(len(entries)))
+		for _, entry := range entries {
+			payload = appendString(payload, entry.Name())
+			payload = appendString(payload, entry.Name())
+			payload = append(payload, s.attrs(entry)...)
+		}
+		return sftpName, payload
+	case sftpClose:
+		handle := d.string()
+		if f := s.files[handle]; f != nil {
+			f.Close()
+		}
+		delete(s.files, handle)
+		delete(s.dirs, handle)
+		return s.status(nil)
+	case sftpRead:
+		f := s.files[d.string()]
+		offset := d.uint64()
+		data := make([]byte, d.uint32())
+		n, err := f.ReadAt(data, int64(offset))
+		if n == 0 && err != nil {
+			return s.status(err)
+		}
+		return sftpData, appendString(nil, string(data[:n]))
+	case sftpStat, sftpLstat, sftpFstat:
+		var info os.FileInfo
+		var err error
+		switch kind {
+		case sftpStat:
+			info, err = os.Stat(s.local(d.string()))
+		case sftpLstat:
+			info, err = os.Lstat(s.local(d.string()))
+		default:
+			info, err = s.files[d.string()].Stat()
+		}
+		if err != nil {
+			return s.status(err)
+		}
+		return sftpAttrs, s.attrs(info)
+	case sftpMkdir:
+		return s.status(os.Mkdir(s.local(d.string()), 0777))
+	case sftpRemove, sftpRmdir:
+		return s.status(os.Remove(s.local(d.string())))
+	case sftpRename:
+		oldPath, newPath := s.local(d.string()), s.local(d.string())
+		if _, err := os.Lstat(newPath); err == nil {
+			return s.status(os.ErrExist)
+		}
+		return s.status(os.Rename(oldPath, newPath))
+	case sftpSymlink:
+		target, link := s.local(d.string()), s.local(d.string())
+		return s.status(os.Symlink(target, link))
+	}
+	return s.status(fmt.Errorf("Unsupported request %d", kind))
+}
+
+// useSFTPTestServer replaces filesystem used by scan and move with one that serves user@host from root until test ends
+func useSFTPTestServer(t *testing.T, root string) {
+	remote := newRemoteFilesystem(osFilesystem{})
+	remote.dial = func(host string) (*sftpClient, error) {
+		if host != "user@host" {
+			return nil, fmt.Errorf("Unknown host %s", host)
+		}
+		return startSFTPTestServer(t, root), nil
+	}
+	previous := fsys
+	fsys = remote
+	t.Cleanup(func() { fsys = previous })
+}
+
+func TestSFTPScanAndMove(t *testing.T) {
+	host := t.TempDir()
+	// Large file is read with several requests sent at once
+	large := bytes.Repeat([]byte("0123456789abcdef"), 40000)
+	files := map[string][]byte{
+		"lib/masters/a.txt":  []byte("same"),
+		"lib/incoming/a.txt": []byte("same"),
+		"lib/incoming/b.bin": large,
+	}
+	for path, data := range files {
+		path = filepath.Join(host, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useSFTPTestServer(t, host)
+	folders, err := ExpandPaths([]string{"sftp://user@host/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "db.txt")
+	fh, err := ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders(folders, fh, 4); err != nil {
+		t.Fatal(err)
+	}
+	largeHash, err := getFileHash(filepath.Join(host, "lib", "incoming", "b.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteLarge := filepath.Join(folders[0], "incoming", "b.bin")
+	if record := fh.files[remoteLarge]; record == nil || record.FileHash != largeHash || record.Size != int64(len(large)) {
+		t.Fatalf("Expected %s to be recorded with hash %s, got %+v", remoteLarge, largeHash, record)
+	}
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "sftp://user@host/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := countDuplicates(dups); count != 1 {
+		t.Fatalf("Expected 1 duplicate, got %v", dups)
+	}
+	opts := MoveOptions{Destination: "sftp://user@host/removed", RemovePrefix: "sftp://user@host/lib", Quarantine: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, filepath.Join(host, "removed", "incoming", "a.txt"))
+	if target, err := os.Readlink(filepath.Join(host, "lib", "incoming", "a.txt")); err != nil || target != filepath.Join(host, "lib", "masters", "a.txt") {
+		t.Errorf("Expected symlink to master, got %s: %v", target, err)
+	}
+	// Remote records are checked over SFTP when database is loaded again, database is compacted after moves like in main
+	if err := CompactDB(fh); err != nil {
+		t.Fatal(err)
+	}
+	CloseDB(fh)
+	fh, err = ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(fh)
+	if record := fh.files[filepath.Join(folders[0], "masters", "a.txt")]; record == nil {
+		t.Error("Expected remote master to be loaded from database")
+	}
+	if record := fh.files[remoteLarge]; record == nil || record.FileHash != largeHash {
+		t.Errorf("Expected remote file to be loaded from database, got %+v", record)
+	}
+	if record := fh.files[filepath.Join(folders[0], "incoming", "a.txt")]; record != nil {
+		t.Errorf("Expected moved duplicate to not be recorded, got %+v", record)
+	}
+}
+
+func TestSFTPOtherHosts(t *testing.T) {
+	useSFTPTestServer(t, t.TempDir())
+	if _, err := fsys.Stat("sftp:/other/lib"); err == nil {
+		t.Error("Expected unknown host to fail")
+	}
+	if err := fsys.Rename("sftp:/user@host/a", "/tmp/a"); err == nil {
+		t.Error("Expected rename between hosts to fail")
+	}
+}
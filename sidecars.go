@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Policies of sidecar files of moved duplicates
+const (
+	// SidecarsMove moves sidecars along with their media
+	SidecarsMove = "move"
+	// SidecarsLeave leaves sidecars in place
+	SidecarsLeave = "leave"
+	// SidecarsDelete deletes sidecars of moved media
+	SidecarsDelete = "delete"
+)
+
+// DefaultSidecarExtensions lists extensions of metadata files written next to media by photo editors, e.g. Lightroom XMP and iOS AAE
+const DefaultSidecarExtensions = "xmp,aae"
+
+// findSidecars returns existing sidecars of media, both appended (IMG_0001.JPG.xmp) and replacing (IMG_0001.xmp) extension naming is recognized
+// Replacing sidecar is shared by media with same name, e.g. RAW and JPEG of same photo, so it is only returned when all such media are moved
+func findSidecars(path string, extensions []string, moved map[string]bool) ([]string, error) {
+	var sidecars []string
+	found := make(map[string]bool)
+	if hasExtension(path, extensions) {
+		// Sidecars do not have sidecars
+		return nil, nil
+	}
+	for _, base := range []string{path, strings.TrimSuffix(path, filepath.Ext(path))} {
+		for _, ext := range extensions {
+			sidecar, ok := findCompanion(base+ext, []string{ext})
+			if !ok || found[sidecar] {
+				continue
+			}
+			if base != path {
+				shared, err := hasRemainingSibling(path, extensions, moved)
+				if err != nil {
+					return nil, err
+				}
+				if shared {
+					log.Debugf("Leaving %s in place, it is shared with other media next to %s\n", sidecar, path)
+					continue
+				}
+			}
+			found[sidecar] = true
+			sidecars = append(sidecars, sidecar)
+		}
+	}
+	return sidecars, nil
+}
+
+// hasRemainingSibling checks if other media with same name but different extension is next to path and is not moved, sidecars are not counted
+func hasRemainingSibling(path string, extensions []string, moved map[string]bool) (bool, error) {
+	folder := filepath.Dir(path)
+	entries, err := fsys.ReadDir(folder)
+	if err != nil {
+		return false, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, entry := range entries {
+		sibling := filepath.Join(folder, entry.Name())
+		if entry.IsDir() || sibling == path || moved[sibling] || hasExtension(sibling, extensions) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())), name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getDuplicateSidecars returns sidecars that are moved or deleted along with duplicate, ones that are duplicates themselves or protected are left out
+func getDuplicateSidecars(path string, opts MoveOptions, duplicatePaths map[string]bool) ([]string, error) {
+	sidecars, err := findSidecars(path, opts.SidecarExtensions, duplicatePaths)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, sidecar := range sidecars {
+		if duplicatePaths[sidecar] {
+			continue
+		}
+		if opts.Protected.Contains(sidecar) {
+			log.Warningf("Leaving protected sidecar %s of %s in place\n", sidecar, path)
+			continue
+		}
+		result = append(result, sidecar)
+	}
+	return result, nil
+}
+
+// getSidecarDestination returns destination of sidecar next to destination of its media, keeping its naming style
+func getSidecarDestination(path string, newPath string, sidecar string) string {
+	if strings.HasPrefix(sidecar, path) {
+		return newPath + sidecar[len(path):]
+	}
+	return getCompanionDestination(newPath, sidecar)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Kinds of storage returned by getStorageKind
+const (
+	storageUnknown = "unknown storage"
+	storageMemory  = "memory"
+	storageSSD     = "solid state drive"
+	storageHDD     = "rotational disk"
+	storageNetwork = "network share"
+)
+
+// getStorageConcurrency returns number of files that are best parsed in parallel on storage of given kind, or fallback when kind is not known
+// Solid state drives serve parallel reads well, while parallel reads make rotational disks seek and network shares are bound by link
+func getStorageConcurrency(kind string, fallback int) int {
+	switch kind {
+	case storageMemory, storageSSD:
+		return runtime.NumCPU()
+	case storageHDD:
+		return 1
+	case storageNetwork:
+		return 2
+	}
+	return fallback
+}
+
+// AutoConcurrency picks scan concurrency by kind of storage of folders, the lowest concurrency of all folders is used
+// Returned reason describes detected storage, so that choice can be logged
+func AutoConcurrency(folders []string, fallback int) (int, string) {
+	concurrency, reason := 0, ""
+	for _, folder := range folders {
+		kind, err := storageNetwork, error(nil)
+		if !isRemotePath(folder) {
+			kind, err = getStorageKind(folder)
+		}
+		if err != nil {
+			log.Warningf("Failed to detect storage of %s: %s\n", folder, err)
+			kind = storageUnknown
+		}
+		folderConcurrency := getStorageConcurrency(kind, fallback)
+		log.Debugf("Detected %s at %s, concurrency %d\n", kind, folder, folderConcurrency)
+		if concurrency == 0 || folderConcurrency < concurrency {
+			concurrency, reason = folderConcurrency, fmt.Sprintf("%s is on %s", folder, kind)
+		}
+	}
+	if concurrency == 0 {
+		return fallback, "no folders to scan"
+	}
+	return concurrency, reason
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// getStorageKind detects kind of storage containing path by file system type and rotational flag of block device in sysfs
+func getStorageKind(path string) (string, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return storageUnknown, err
+	}
+	switch uint32(fs.Type) {
+	case unix.NFS_SUPER_MAGIC, unix.SMB_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC, unix.FUSE_SUPER_MAGIC:
+		// FUSE file systems are mostly remote, e.g. sshfs or rclone mounts
+		return storageNetwork, nil
+	case unix.TMPFS_MAGIC:
+		return storageMemory, nil
+	}
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return storageUnknown, err
+	}
+	device := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(stat.Dev), unix.Minor(stat.Dev))
+	// Partitions do not have queue of their own, it belongs to their disk
+	for _, path := range []string{device + "/queue/rotational", device + "/../queue/rotational"} {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == "1" {
+			return storageHDD, nil
+		}
+		return storageSSD, nil
+	}
+	return storageUnknown, nil
+}
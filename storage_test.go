@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGetStorageConcurrency(t *testing.T) {
+	tests := []struct {
+		kind     string
+		expected int
+	}{
+		{storageSSD, runtime.NumCPU()},
+		{storageMemory, runtime.NumCPU()},
+		{storageHDD, 1},
+		{storageNetwork, 2},
+		{storageUnknown, 3},
+	}
+	for _, test := range tests {
+		if concurrency := getStorageConcurrency(test.kind, 3); concurrency != test.expected {
+			t.Errorf("Expected concurrency %d for %s, got %d", test.expected, test.kind, concurrency)
+		}
+	}
+	if concurrency, _ := AutoConcurrency(nil, 3); concurrency != 3 {
+		t.Errorf("Expected fallback concurrency without folders, got %d", concurrency)
+	}
+	if concurrency, reason := AutoConcurrency([]string{t.TempDir()}, 3); concurrency <= 0 || len(reason) == 0 {
+		t.Errorf("Expected concurrency with reason for temporary folder, got %d (%s)", concurrency, reason)
+	}
+}
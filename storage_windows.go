@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// getStorageKind only detects network drives, kind of local drives is not known
+func getStorageKind(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return storageUnknown, err
+	}
+	root, err := windows.UTF16PtrFromString(fmt.Sprintf("%s%c", filepath.VolumeName(path), filepath.Separator))
+	if err != nil {
+		return storageUnknown, err
+	}
+	if windows.GetDriveType(root) == windows.DRIVE_REMOTE {
+		return storageNetwork, nil
+	}
+	return storageUnknown, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordStore persists database records
+type recordStore interface {
+	// load passes stored records to fn in order they were written, older records of same path may be passed before latest one
+	load(fn func(record *FileMetadata) error) error
+	// put saves record, superseding older record of same path, and reports whether it added stored record rather than replacing older one in place
+	put(record *FileMetadata) (bool, error)
+	// rewrite saves copy of stored records into backup path and replaces them with given records
+	rewrite(records []*FileMetadata, backup string) error
+	// close releases resources held by store
+	close() error
+}
+
+// isBoltPath checks if database at path is stored in BoltDB
+func isBoltPath(dbPath string) bool {
+	ext := strings.ToLower(filepath.Ext(dbPath))
+	return ext == ".bolt" || ext == ".bbolt"
+}
+
+// openStore opens record store for database path, BoltDB is used for .bolt files and append-only text file otherwise
+// Records can only be loaded from read-only store
+func openStore(dbPath string, readOnly bool) (recordStore, error) {
+	if isBoltPath(dbPath) {
+		return openBoltStore(dbPath, readOnly)
+	}
+	return &textStore{path: dbPath}, nil
+}
+
+// textStore keeps records as JSON lines, updated records are appended and outdated ones are only removed by rewrite
+type textStore struct {
+	path string
+}
+
+func (s *textStore) load(fn func(record *FileMetadata) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &FileMetadata{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *textStore) put(record *FileMetadata) (bool, error) {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	// Older records of same path are only dropped by rewrite
+	return true, writeRecordToFile(file, record)
+}
+
+func (s *textStore) rewrite(records []*FileMetadata, backup string) error {
+	if err := os.Rename(s.path, backup); err != nil {
+		// Database is only missing before anything was saved, so there is nothing to back up
+		if _, statErr := os.Stat(s.path); !os.IsNotExist(statErr) {
+			return err
+		}
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := writeRecordToFile(file, record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	// Rewrite is only committed once records are on disk, since backup is the only other copy of them
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (s *textStore) close() error {
+	return nil
+}
+
+func writeRecordToFile(file *os.File, record *FileMetadata) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if _, err = file.WriteString("\n"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+const benchmarkRecords = 100000
+
+func makeBenchmarkRecords() []*FileMetadata {
+	records := make([]*FileMetadata, benchmarkRecords)
+	for i := range records {
+		records[i] = &FileMetadata{
+			Path:     fmt.Sprintf("/photos/%03d/IMG_%06d.jpg", i%1000, i),
+			Size:     int64(1000000 + i),
+			FileHash: fmt.Sprintf("%040x", i),
+			Created:  time.Unix(int64(1500000000+i), 0),
+			Modified: time.Unix(int64(1500000000+i), 0),
+		}
+	}
+	return records
+}
+
+func benchmarkReadDB(b *testing.B, dbPath string) {
+	logging.SetLevel(logging.WARNING, "cleaner")
+	store, err := openStore(dbPath, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := store.rewrite(makeBenchmarkRecords(), dbPath+".backup"); err != nil {
+		b.Fatal(err)
+	}
+	// Release file lock so that database can be opened again
+	if err := store.close(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		fh, err := ReadSnapshotDB(dbPath, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(fh.files) != benchmarkRecords {
+			b.Fatalf("Expected %d records, got %d", benchmarkRecords, len(fh.files))
+		}
+		if err := CloseDB(fh); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadDBText(b *testing.B) {
+	benchmarkReadDB(b, filepath.Join(b.TempDir(), "db.txt"))
+}
+
+func BenchmarkReadDBBolt(b *testing.B) {
+	benchmarkReadDB(b, filepath.Join(b.TempDir(), "db.bolt"))
+}
+
+func TestBoltStoreReplacesRecords(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "db.bolt")
+	store, err := openBoltStore(dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	if added, err := store.put(&FileMetadata{Path: "/a", FileHash: "old"}); err != nil || !added {
+		t.Fatalf("Expected new record to be added: %v", err)
+	}
+	// Replaced record does not leave outdated one behind, so it should not count towards compaction
+	if added, err := store.put(&FileMetadata{Path: "/a", FileHash: "new", ImageHash: "image"}); err != nil || added {
+		t.Fatalf("Expected record to be replaced in place: %v", err)
+	}
+	var loaded []*FileMetadata
+	if err := store.load(func(record *FileMetadata) error {
+		loaded = append(loaded, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].FileHash != "new" {
+		t.Fatalf("Expected only latest record, got %v", loaded)
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		hashes := tx.Bucket(boltHashesBucket)
+		if paths := hashes.Bucket([]byte("old")); paths != nil && paths.Get([]byte("/a")) != nil {
+			t.Errorf("Expected /a to be removed from old hash index")
+		}
+		for _, hash := range []string{"new", "image"} {
+			if paths := hashes.Bucket([]byte(hash)); paths == nil || paths.Get([]byte("/a")) == nil {
+				t.Errorf("Expected /a in %s hash index", hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltDBRefreshedRecordsAreNotStale(t *testing.T) {
+	logging.SetLevel(logging.ERROR, "cleaner")
+	root := t.TempDir()
+	path := filepath.Join(root, "a.txt")
+	if err := ioutil.WriteFile(path, []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := ReadDB(filepath.Join(t.TempDir(), "db.bolt"), false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(fh)
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("new contents"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if stale := fh.dbLines - len(fh.files); stale != 0 {
+		t.Errorf("Expected no outdated records in BoltDB, got %d", stale)
+	}
+}
+
+func TestReadDBFailsWhenDatabaseIsInUse(t *testing.T) {
+	logging.SetLevel(logging.ERROR, "cleaner")
+	dbPath := filepath.Join(t.TempDir(), "db.txt")
+	fh, err := ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDB(dbPath, false, ParseOptions{}); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatal("Expected database in use error")
+	}
+	if err := CloseDB(fh); err != nil {
+		t.Fatal(err)
+	}
+	fh, err = ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CloseDB(fh)
+}
+
+func TestReindexDB(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+	a := filepath.Join(root, "a.txt")
+	expected := fh.files[a].FileHash
+	firstSeen := fh.files[a].FirstSeen
+	fh.files[a].FileHash = "stale"
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	extra := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(extra, "c.txt"), []byte("c"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	before, after, err := ReindexDB(fh, []string{extra}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 2 || after != 2 {
+		t.Errorf("Expected 2 records before and after, got %d and %d", before, after)
+	}
+	if record := fh.files[a]; record == nil || record.FileHash != expected || !record.FirstSeen.Equal(firstSeen) {
+		t.Errorf("Expected record to be hashed again keeping first seen time, got %+v", record)
+	}
+	if fh.files[filepath.Join(root, "b.txt")] != nil || fh.files[filepath.Join(extra, "c.txt")] == nil {
+		t.Errorf("Expected missing file to be dropped and folder to be scanned")
+	}
+	CloseDB(fh)
+	reloaded, err := ReadSnapshotDB(fh.dbPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.files) != 2 || reloaded.files[a].FileHash != expected {
+		t.Errorf("Expected database to be rewritten, got %d records", len(reloaded.files))
+	}
+}
+
+func TestReadSnapshotBoltDB(t *testing.T) {
+	logging.SetLevel(logging.ERROR, "cleaner")
+	root := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "db.bolt")
+	fh, err := ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanFolders([]string{root}, fh, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := CloseDB(fh); err != nil {
+		t.Fatal(err)
+	}
+	// Snapshots are closed once loaded, so database can be read again and opened for writing
+	for i := 0; i < 2; i++ {
+		snapshot, err := ReadSnapshotDB(dbPath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.files) != 1 {
+			t.Errorf("Expected 1 record in snapshot, got %d", len(snapshot.files))
+		}
+	}
+	fh, err = ReadDB(dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Expected database to be opened after snapshots were read: %v", err)
+	}
+	CloseDB(fh)
+	// Empty database is not initialized by snapshot
+	empty := filepath.Join(t.TempDir(), "empty.bolt")
+	db, err := bolt.Open(empty, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if snapshot, err := ReadSnapshotDB(empty, ""); err != nil || len(snapshot.files) != 0 {
+		t.Fatalf("Expected empty snapshot, got %v", err)
+	}
+	db, err = bolt.Open(empty, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltFilesBucket) != nil {
+			t.Error("Expected snapshot not to create buckets")
+		}
+		return nil
+	})
+}
+
+func TestReadSimulatedDB(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{{"/lib/a.txt", "same", modified}, {"/lib/b.txt", "same", modified}})
+	if err := CloseDB(fh); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Remove("/lib/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	simulated, err := ReadSimulatedDB(fh.dbPath, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dups, err := FindDuplicates(SearchOptions{}, simulated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 {
+		t.Errorf("Expected cached records of removed file to be trusted, got %d duplicate groups", len(dups))
+	}
+	if err := CloseDB(simulated); err != nil {
+		t.Fatal(err)
+	}
+	checked, err := ReadDB(fh.dbPath, false, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(checked)
+	if len(checked.files) != 1 {
+		t.Errorf("Expected record of removed file to be dropped when files are checked, got %d records", len(checked.files))
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	_, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/kept.txt", "kept", modified},
+		{"/lib/unindexed.txt", "unindexed", modified},
+		{"/lib/changed.txt", "changed", modified},
+	})
+	if discrepancies := CheckIndex(fh); discrepancies != 0 {
+		t.Errorf("Expected consistent index, got %d discrepancies", discrepancies)
+	}
+	unindexed := fh.files["/lib/unindexed.txt"]
+	fh.hashes[unindexed.FileHash] = deleteRecord(fh.hashes[unindexed.FileHash], unindexed)
+	// Record indexed under old hash is stale, and missing under new one
+	changed := fh.files["/lib/changed.txt"]
+	changed.FileHash = "changed"
+	if discrepancies := CheckIndex(fh); discrepancies != 3 {
+		t.Errorf("Expected 3 discrepancies, got %d", discrepancies)
+	}
+	if len(fh.hashes[unindexed.FileHash]) != 1 || len(fh.hashes["changed"]) != 1 {
+		t.Errorf("Expected index to be rebuilt, got %v", fh.hashes)
+	}
+	if discrepancies := CheckIndex(fh); discrepancies != 0 {
+		t.Errorf("Expected rebuilt index to be consistent, got %d discrepancies", discrepancies)
+	}
+}
+
+func TestVerifyDB(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/kept.txt", "kept", modified},
+		{"/lib/removed.txt", "removed", modified},
+		{"/lib/resized.txt", "resized", modified},
+		{"/lib/touched.txt", "touched", modified},
+	})
+	if err := mem.Remove("/lib/removed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	mem.writeFile("/lib/resized.txt", "resized again", modified)
+	mem.writeFile("/lib/touched.txt", "touched", modified.Add(time.Hour))
+	var paths []string
+	for _, discrepancy := range VerifyDB(fh) {
+		paths = append(paths, discrepancy.path)
+	}
+	expected := []string{"/lib/removed.txt", "/lib/resized.txt", "/lib/touched.txt"}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("Expected discrepancies in %v, got %v", expected, paths)
+	}
+	if len(fh.files) != 4 {
+		t.Errorf("Expected records to be kept, got %d", len(fh.files))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// unknownDate replaces date placeholders of destination template for files without shooting date
+const unknownDate = "unknown"
+
+// movePlaceholders map placeholders of destination templates to their values for record and its relative path
+var movePlaceholders = map[string]func(record *FileMetadata, relPath string) string{
+	"year":     getDateComponent("2006"),
+	"month":    getDateComponent("01"),
+	"day":      getDateComponent("02"),
+	"dir":      func(record *FileMetadata, relPath string) string { return filepath.Dir(relPath) },
+	"basename": func(record *FileMetadata, relPath string) string { return filepath.Base(relPath) },
+	"name": func(record *FileMetadata, relPath string) string {
+		return strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+	},
+	"ext": func(record *FileMetadata, relPath string) string {
+		return strings.TrimPrefix(filepath.Ext(relPath), ".")
+	},
+	"hash": func(record *FileMetadata, relPath string) string { return record.FileHash },
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]*)\}`)
+
+func getDateComponent(layout string) func(record *FileMetadata, relPath string) string {
+	return func(record *FileMetadata, relPath string) string {
+		if record.DateShot.IsZero() {
+			return unknownDate
+		}
+		return record.DateShot.Format(layout)
+	}
+}
+
+// ValidateMoveTemplate checks that destination template only has known placeholders
+func ValidateMoveTemplate(template string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if movePlaceholders[match[1]] == nil {
+			return fmt.Errorf("Unknown placeholder %s in move template", match[0])
+		}
+	}
+	return nil
+}
+
+// expandMoveTemplate builds path relative to destination folder from template, e.g. {year}/{month}/{basename}
+// Expanded path must stay inside destination folder
+func expandMoveTemplate(template string, record *FileMetadata, relPath string) (string, error) {
+	expanded := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value := movePlaceholders[placeholder[1:len(placeholder)-1]]; value != nil {
+			return value(record, relPath)
+		}
+		return placeholder
+	})
+	expanded = filepath.Clean(filepath.FromSlash(expanded))
+	if filepath.IsAbs(expanded) || expanded == "." || expanded == ".." || strings.HasPrefix(expanded, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Move template %s expands to %s outside of destination for %s", template, expanded, record.Path)
+	}
+	return expanded, nil
+}
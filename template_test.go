@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandMoveTemplate(t *testing.T) {
+	shot := &FileMetadata{Path: "/photos/trip/IMG_1.JPG", FileHash: "abc", DateShot: time.Date(2019, 7, 4, 12, 30, 0, 0, time.UTC)}
+	unknown := &FileMetadata{Path: "/photos/trip/notes.txt", FileHash: "def"}
+	tests := []struct {
+		template string
+		record   *FileMetadata
+		expected string
+	}{
+		{"{year}/{month}/{day}/{basename}", shot, "2019/07/04/IMG_1.JPG"},
+		{"{year}/{month}/{basename}", unknown, "unknown/unknown/notes.txt"},
+		{"{dir}/{name}-{hash}.{ext}", shot, "photos/trip/IMG_1-abc.JPG"},
+		{"by-hash/{hash}", unknown, "by-hash/def"},
+	}
+	for _, test := range tests {
+		relPath, err := getRelativeDestination(test.record, MoveOptions{Template: test.template})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.FromSlash(test.expected); relPath != expected {
+			t.Errorf("%s: expected %s, got %s", test.template, expected, relPath)
+		}
+	}
+	if _, err := expandMoveTemplate("../{basename}", shot, "photos/trip/IMG_1.JPG"); err == nil {
+		t.Error("Expected template leaving destination to fail")
+	}
+	if err := ValidateMoveTemplate("{year}/{album}/{basename}"); err == nil {
+		t.Error("Expected unknown placeholder to fail")
+	}
+}
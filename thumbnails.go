@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailSize is maximum width and height of generated thumbnails
+const thumbnailSize = 160
+
+// GetThumbnailsFolder returns folder where thumbnails are cached for database
+func GetThumbnailsFolder(dbPath string) string {
+	return dbPath + ".thumbs"
+}
+
+// getThumbnailPath returns path of cached thumbnail for image hash
+func getThumbnailPath(folder string, imageHash string) string {
+	return filepath.Join(folder, imageHash+".jpg")
+}
+
+// writeThumbnail scales image down and saves it as JPEG, existing thumbnail is kept as is
+func writeThumbnail(path string, img image.Image) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width > height {
+			width, height = thumbnailSize, height*thumbnailSize/width
+		} else {
+			width, height = width*thumbnailSize/height, thumbnailSize
+		}
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Src, nil)
+	// Write into temporary file first, so that concurrent writers never leave partial thumbnail behind
+	file, err := ioutil.TempFile(filepath.Dir(path), "thumb")
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(file, thumbnail, nil); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// ensureThumbnail returns path of thumbnail for record, generating it when missing
+func ensureThumbnail(folder string, record *FileMetadata) (string, error) {
+	path := getThumbnailPath(folder, record.ImageHash)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	img, err := readImage(record.Path)
+	if err != nil {
+		return "", err
+	}
+	return path, writeThumbnail(path, img)
+}
+
+type htmlFile struct {
+	Path      string
+	Thumbnail template.URL
+	Size      int64
+	DateShot  time.Time
+	Modified  time.Time
+	MatchType string
+	IsMaster  bool
+}
+
+type htmlGroup struct {
+	Files       []htmlFile
+	Reclaimable int64
+}
+
+type htmlReport struct {
+	Groups      []htmlGroup
+	Duplicates  int
+	Reclaimable int64
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": formatSize,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duplicates</title>
+<style>
+body { font-family: sans-serif; }
+.group { border-bottom: 1px solid #ccc; padding: 8px 0; }
+.files { display: flex; flex-wrap: wrap; }
+figure { margin: 4px; padding: 4px; width: 180px; border: 2px solid transparent; }
+figure.master { border-color: #2a2; background: #efe; }
+figcaption { font-size: small; word-break: break-all; }
+.match { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{len .Groups}} duplicate groups, {{.Duplicates}} duplicates, {{size .Reclaimable}} reclaimable</h1>
+{{range .Groups}}<div class="group">
+<div>Reclaimable: {{size .Reclaimable}}</div>
+<div class="files">
+{{range .Files}}<figure{{if .IsMaster}} class="master"{{end}}>{{if .Thumbnail}}<img src="{{.Thumbnail}}">{{end}}<figcaption>
+<div class="match">{{.MatchType}}</div>
+<div>{{.Path}}</div>
+<div>Size: {{size .Size}}</div>
+<div>Shot: {{date .DateShot}}</div>
+<div>Modified: {{date .Modified}}</div>
+</figcaption></figure>
+{{end}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// formatSize formats byte count using binary units
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	unit := ""
+	for _, unit = range units {
+		value /= 1024
+		if value < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
+
+// WriteHTMLReport writes HTML page with thumbnails of masters and their duplicates side by side with reclaimable sizes
+// Missing thumbnails are generated and cached in thumbnails folder
+func WriteHTMLReport(reportPath string, thumbnailsFolder string, dups map[*FileMetadata][]*FileMetadata) error {
+	reportPath, err := filepath.Abs(reportPath)
+	if err != nil {
+		return err
+	}
+	thumbnailsFolder, err = filepath.Abs(thumbnailsFolder)
+	if err != nil {
+		return err
+	}
+	masters := make([]*FileMetadata, 0, len(dups))
+	for master := range dups {
+		masters = append(masters, master)
+	}
+	sort.Slice(masters, func(i, j int) bool { return masters[i].Path < masters[j].Path })
+	report := htmlReport{Groups: make([]htmlGroup, 0, len(masters))}
+	for _, master := range masters {
+		group := htmlGroup{}
+		group.Files = append(group.Files, htmlFile{Path: master.Path, Thumbnail: getThumbnailURL(reportPath, thumbnailsFolder, master), Size: master.Size, DateShot: master.DateShot, Modified: master.Modified, MatchType: "Master", IsMaster: true})
+		for _, dup := range dups[master] {
+			group.Files = append(group.Files, htmlFile{Path: dup.Path, Thumbnail: getThumbnailURL(reportPath, thumbnailsFolder, dup), Size: dup.Size, DateShot: dup.DateShot, Modified: dup.Modified, MatchType: getMatchType(master, dup)})
+			group.Reclaimable += dup.Size
+			report.Duplicates++
+		}
+		report.Reclaimable += group.Reclaimable
+		report.Groups = append(report.Groups, group)
+	}
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	log.Infof("Writing HTML report to %s\n", reportPath)
+	if err := htmlReportTemplate.Execute(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// getThumbnailURL returns thumbnail URL relative to report, or empty URL if record has no thumbnail
+func getThumbnailURL(reportPath string, thumbnailsFolder string, record *FileMetadata) template.URL {
+	if len(record.ImageHash) == 0 {
+		return ""
+	}
+	path, err := ensureThumbnail(thumbnailsFolder, record)
+	if err != nil {
+		log.Warningf("No thumbnail for %s: %s\n", record.Path, err)
+		return ""
+	}
+	u := &url.URL{}
+	if relPath, err := filepath.Rel(filepath.Dir(reportPath), path); err == nil {
+		u.Path = filepath.ToSlash(relPath)
+	} else {
+		u.Scheme = "file"
+		u.Path = filepath.ToSlash(path)
+		if !strings.HasPrefix(u.Path, "/") {
+			u.Path = "/" + u.Path
+		}
+	}
+	return template.URL(u.String())
+}
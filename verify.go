@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// dbDiscrepancy is record that does not match file it describes
+type dbDiscrepancy struct {
+	path   string
+	reason string
+}
+
+// VerifyDB checks that every recorded file exists with recorded size and modification time without reading its contents
+// Database is not modified, discrepancies are returned sorted by path
+func VerifyDB(fh *FileHashes) []dbDiscrepancy {
+	var paths []string
+	for path := range fh.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var discrepancies []dbDiscrepancy
+	for _, path := range paths {
+		if reason := verifyRecord(fh.files[path]); len(reason) > 0 {
+			discrepancies = append(discrepancies, dbDiscrepancy{path, reason})
+		}
+	}
+	return discrepancies
+}
+
+// verifyRecord returns why record does not match file, or empty string when it does
+func verifyRecord(record *FileMetadata) string {
+	if archivePath, _, ok := splitArchivePath(record.Path); ok {
+		// Entries are only checked through their archive, which is what decides whether they are scanned again
+		f, err := fsys.Stat(archivePath)
+		if err != nil {
+			return describeStatError(err)
+		}
+		if !record.ArchiveModified.Equal(f.ModTime()) {
+			return fmt.Sprintf("archive modified at %s instead of %s", f.ModTime(), record.ArchiveModified)
+		}
+		return ""
+	}
+	stat := fsys.Stat
+	if len(record.SymlinkTarget) > 0 {
+		// Symlink records describe symlink itself rather than its target
+		stat = fsys.Lstat
+	}
+	f, err := stat(record.Path)
+	if err != nil {
+		return describeStatError(err)
+	}
+	if f.IsDir() {
+		return "is a folder"
+	}
+	if f.Size() != record.Size {
+		return fmt.Sprintf("size %d instead of %d", f.Size(), record.Size)
+	}
+	if !f.ModTime().Equal(record.Modified) {
+		return fmt.Sprintf("modified at %s instead of %s", f.ModTime(), record.Modified)
+	}
+	return ""
+}
+
+func describeStatError(err error) string {
+	if os.IsNotExist(err) {
+		return "missing"
+	}
+	return err.Error()
+}
+
+// PrintDBDiscrepancies prints records that do not match files and returns their number
+func PrintDBDiscrepancies(fh *FileHashes) int {
+	discrepancies := VerifyDB(fh)
+	fmt.Printf("* Records that do not match files:\n")
+	for _, discrepancy := range discrepancies {
+		fmt.Printf("    %s (%s)\n", discrepancy.path, discrepancy.reason)
+	}
+	fmt.Printf("* %d of %d records do not match files\n", len(discrepancies), len(fh.files))
+	return len(discrepancies)
+}
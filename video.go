@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// videoExtensions lists extensions of QuickTime and MPEG-4 videos, whose duration is read from their moov atom
+var videoExtensions = map[string]bool{
+	".mov": true, ".mp4": true, ".m4v": true, ".3gp": true,
+}
+
+func isVideoFile(path string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// getVideoDuration reads duration of video in seconds from movie header inside moov atom
+func getVideoDuration(path string) (float64, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	log.Debugf("Reading moov duration %s\n", path)
+	moovSize, err := findAtom(f, "moov", -1)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := findAtom(f, "mvhd", moovSize); err != nil {
+		return 0, err
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return 0, err
+	}
+	// Version 1 header has 64 bit creation time, modification time and duration
+	var timescale uint32
+	var duration uint64
+	if header[0] == 1 {
+		var fields [28]byte
+		if _, err := io.ReadFull(f, fields[:]); err != nil {
+			return 0, err
+		}
+		timescale, duration = binary.BigEndian.Uint32(fields[16:20]), binary.BigEndian.Uint64(fields[20:28])
+	} else {
+		var fields [16]byte
+		if _, err := io.ReadFull(f, fields[:]); err != nil {
+			return 0, err
+		}
+		timescale, duration = binary.BigEndian.Uint32(fields[8:12]), uint64(binary.BigEndian.Uint32(fields[12:16]))
+	}
+	if timescale == 0 {
+		return 0, errors.New("Invalid movie header time scale")
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// findAtom skips atoms until one with given type and positions reader at its contents, returning their size
+// Only limit bytes are searched when it is not negative, e.g. contents of parent atom
+func findAtom(r io.ReadSeeker, atomType string, limit int64) (int64, error) {
+	var header [8]byte
+	for limit < 0 || limit >= int64(len(header)) {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(len(header))
+		if size == 1 {
+			// 64 bit size follows type
+			var extended [8]byte
+			if _, err := io.ReadFull(r, extended[:]); err != nil {
+				return 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(extended[:]))
+			headerSize += int64(len(extended))
+		} else if size == 0 {
+			// Atom extends to end of file
+			if string(header[4:8]) == atomType {
+				return math.MaxInt64, nil
+			}
+			break
+		}
+		if size < headerSize {
+			return 0, fmt.Errorf("Invalid size of %q atom", header[4:8])
+		}
+		if string(header[4:8]) == atomType {
+			return size - headerSize, nil
+		}
+		if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		if limit >= 0 {
+			limit -= size
+		}
+	}
+	return 0, fmt.Errorf("No %q atom", atomType)
+}
+
+// videoMatchDurationPrecision is precision in seconds that durations of videos are compared with
+const videoMatchDurationPrecision = 0.001
+
+// getVideoMatches groups videos with identical duration whose sizes differ by at most tolerance (e.g. 0.05 for 5%) from the smallest of them
+// Videos with same file hash are exact duplicates, so only one of them is included
+func getVideoMatches(fh *FileHashes, tolerance float64) [][]*FileMetadata {
+	byDuration := make(map[int64][]*FileMetadata)
+	for _, record := range fh.files {
+		if record.Duration > 0 {
+			key := int64(math.Round(record.Duration / videoMatchDurationPrecision))
+			byDuration[key] = append(byDuration[key], record)
+		}
+	}
+	var groups [][]*FileMetadata
+	for _, records := range byDuration {
+		sort.Slice(records, func(i, j int) bool {
+			if records[i].Size != records[j].Size {
+				return records[i].Size < records[j].Size
+			}
+			return records[i].Path < records[j].Path
+		})
+		var group []*FileMetadata
+		hashes := make(map[string]bool)
+		for _, record := range records {
+			if len(group) > 0 && float64(record.Size) > float64(group[0].Size)*(1+tolerance) {
+				if len(group) > 1 {
+					groups = append(groups, group)
+				}
+				group, hashes = nil, make(map[string]bool)
+			}
+			if hashes[record.FileHash] {
+				continue
+			}
+			hashes[record.FileHash] = true
+			group = append(group, record)
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].Path < groups[j][0].Path })
+	return groups
+}
+
+// PrintVideoMatches prints groups of videos with identical duration and similar size, which are likely duplicates for manual review
+// These are fuzzy matches, so they are never returned for moving
+func PrintVideoMatches(fh *FileHashes, tolerance float64) {
+	groups := getVideoMatches(fh, tolerance)
+	fmt.Printf("* Videos with same duration and similar size (%s, review before removing):\n", FuzzyMatch)
+	for _, group := range groups {
+		fmt.Printf("* %s:\n", formatDuration(group[0].Duration))
+		for _, record := range group {
+			fmt.Printf("%011d %s\n", record.Size, record.Path)
+		}
+	}
+	fmt.Printf("* %d groups of videos with same duration and size within %.0f%%\n", len(groups), tolerance*100)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// makeAtom encodes atom with given type and contents
+func makeAtom(atomType string, contents ...[]byte) []byte {
+	var buf bytes.Buffer
+	size := 8
+	for _, c := range contents {
+		size += len(c)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(size))
+	buf.WriteString(atomType)
+	for _, c := range contents {
+		buf.Write(c)
+	}
+	return buf.Bytes()
+}
+
+// makeMovieHeader encodes contents of mvhd atom with given time scale and duration
+func makeMovieHeader(version byte, timescale uint32, duration uint64) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{version, 0, 0, 0})
+	if version == 1 {
+		binary.Write(&buf, binary.BigEndian, [2]uint64{})
+		binary.Write(&buf, binary.BigEndian, timescale)
+		binary.Write(&buf, binary.BigEndian, duration)
+	} else {
+		binary.Write(&buf, binary.BigEndian, [2]uint32{})
+		binary.Write(&buf, binary.BigEndian, timescale)
+		binary.Write(&buf, binary.BigEndian, uint32(duration))
+	}
+	// Rate, volume and the rest of header
+	buf.Write(make([]byte, 80))
+	return buf.Bytes()
+}
+
+func TestGetVideoDuration(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	ftyp := makeAtom("ftyp", []byte("isom\x00\x00\x02\x00"))
+	tests := []struct {
+		name     string
+		data     []byte
+		duration float64
+	}{
+		{"version 0", bytes.Join([][]byte{ftyp, makeAtom("moov", makeAtom("mvhd", makeMovieHeader(0, 600, 50100)))}, nil), 83.5},
+		{"version 1", bytes.Join([][]byte{ftyp, makeAtom("moov", makeAtom("mvhd", makeMovieHeader(1, 1000, 83500)))}, nil), 83.5},
+		{"header after other atoms", bytes.Join([][]byte{ftyp, makeAtom("mdat", make([]byte, 100)), makeAtom("moov", makeAtom("udta"), makeAtom("mvhd", makeMovieHeader(0, 1000, 2000)))}, nil), 2},
+		{"no moov", bytes.Join([][]byte{ftyp, makeAtom("mdat", make([]byte, 100))}, nil), 0},
+		{"no header", bytes.Join([][]byte{ftyp, makeAtom("moov", makeAtom("udta"))}, nil), 0},
+	}
+	for _, test := range tests {
+		mem := useMemFilesystem(t)
+		mem.writeFile("/videos/clip.mp4", string(test.data), modified)
+		duration, err := getVideoDuration("/videos/clip.mp4")
+		if test.duration == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got duration %f", test.name, duration)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if math.Abs(duration-test.duration) > 0.001 {
+			t.Errorf("%s: expected duration %f, got %f", test.name, test.duration, duration)
+		}
+	}
+}
+
+func TestGetVideoMatches(t *testing.T) {
+	fh := &FileHashes{files: make(map[string]*FileMetadata)}
+	for _, record := range []*FileMetadata{
+		{Path: "/a.mov", FileHash: "a", Size: 1000, Duration: 83.5},
+		{Path: "/b.mp4", FileHash: "b", Size: 1040, Duration: 83.5},
+		// Exact copy of b is only listed once
+		{Path: "/c.mp4", FileHash: "b", Size: 1040, Duration: 83.5},
+		// Size differs too much
+		{Path: "/d.mov", FileHash: "d", Size: 2000, Duration: 83.5},
+		{Path: "/e.mov", FileHash: "e", Size: 1000, Duration: 83.6},
+		{Path: "/f.jpg", FileHash: "f", Size: 1000},
+		{Path: "/g.jpg", FileHash: "g", Size: 1000},
+	} {
+		fh.files[record.Path] = record
+	}
+	groups := getVideoMatches(fh, 0.05)
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0].Path != "/a.mov" || groups[0][1].FileHash != "b" {
+		t.Errorf("Expected a.mov to be grouped with one of its similar videos, got %v", groups)
+	}
+	if groups := getVideoMatches(fh, 1); len(groups) != 1 || len(groups[0]) != 3 {
+		t.Errorf("Expected larger video to be grouped with larger tolerance, got %v", groups)
+	}
+}
+
+func TestGetLongestVideos(t *testing.T) {
+	fh := &FileHashes{files: make(map[string]*FileMetadata)}
+	for _, record := range []*FileMetadata{
+		{Path: "/short.mov", Duration: 5},
+		{Path: "/long.mp4", Duration: 3600.5},
+		{Path: "/b.mov", Duration: 60},
+		{Path: "/a.mov", Duration: 60},
+		{Path: "/photo.jpg"},
+	} {
+		fh.files[record.Path] = record
+	}
+	var paths []string
+	for _, record := range getLongestVideos(fh, 0) {
+		paths = append(paths, record.Path)
+	}
+	if expected := []string{"/long.mp4", "/a.mov", "/b.mov", "/short.mov"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected videos %v, got %v", expected, paths)
+	}
+	if longest := getLongestVideos(fh, 2); len(longest) != 2 {
+		t.Errorf("Expected 2 longest videos, got %d", len(longest))
+	}
+	if formatted := formatDuration(3600.5); formatted != "1h0m0.5s" {
+		t.Errorf("Expected 1h0m0.5s, got %s", formatted)
+	}
+}
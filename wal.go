@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+// getWALPath returns path of write-ahead log kept next to database
+func getWALPath(dbPath string) string {
+	return dbPath + ".wal"
+}
+
+// walEntry records intent to perform operation, or its completion when Done is set
+type walEntry struct {
+	Op          string
+	Path        string
+	Destination string `json:",omitempty"`
+	Done        bool   `json:",omitempty"`
+}
+
+// writeAheadLog records destructive operations before they are performed, so that interrupted ones can be recovered
+type writeAheadLog struct {
+	file *os.File
+}
+
+func openWAL(dbPath string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(getWALPath(dbPath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &writeAheadLog{file: file}, nil
+}
+
+func (w *writeAheadLog) write(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// begin records operation that is about to be performed
+func (w *writeAheadLog) begin(op string, path string, destination string) error {
+	return w.write(walEntry{Op: op, Path: path, Destination: destination})
+}
+
+// commit records that operation was completed
+func (w *writeAheadLog) commit(op string, path string) error {
+	return w.write(walEntry{Op: op, Path: path, Done: true})
+}
+
+// close removes log once all operations in it were committed, otherwise log is kept, so that they are reported by replayWAL on next run
+func (w *writeAheadLog) close() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	pending, err := readPendingOperations(w.file.Name())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Warningf("Keeping write-ahead log %s, %d operations were not completed\n", w.file.Name(), len(pending))
+		return nil
+	}
+	return os.Remove(w.file.Name())
+}
+
+// replayWAL recovers operations that were interrupted before they were committed and removes log
+// Interrupted text database compaction is rolled back from backup, interrupted moves are reported
+func replayWAL(dbPath string) error {
+	walPath := getWALPath(dbPath)
+	pending, err := readPendingOperations(walPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		if err := recoverOperation(dbPath, entry); err != nil {
+			return err
+		}
+	}
+	return os.Remove(walPath)
+}
+
+// readPendingOperations returns operations in log that were begun but not committed
+func readPendingOperations(walPath string) ([]walEntry, error) {
+	file, err := os.Open(walPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var pending []walEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := walEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Last entry might be partially written if process was interrupted
+			log.Warningf("Skipping damaged write-ahead log entry: %s\n", err)
+			continue
+		}
+		if !entry.Done {
+			pending = append(pending, entry)
+			continue
+		}
+		for i, started := range pending {
+			if started.Op == entry.Op && started.Path == entry.Path {
+				pending = append(pending[:i], pending[i+1:]...)
+				break
+			}
+		}
+	}
+	return pending, scanner.Err()
+}
+
+func recoverOperation(dbPath string, entry walEntry) error {
+	switch entry.Op {
+	case "move":
+		_, sourceErr := os.Stat(entry.Path)
+		_, destinationErr := os.Stat(entry.Destination)
+		if sourceErr == nil && destinationErr == nil {
+			log.Warningf("Both %s and %s exist after interrupted move\n", entry.Path, entry.Destination)
+		} else if sourceErr == nil {
+			log.Warningf("Interrupted move of %s to %s was not applied\n", entry.Path, entry.Destination)
+		} else {
+			log.Warningf("Interrupted move of %s to %s was completed\n", entry.Path, entry.Destination)
+		}
+	case "quarantine":
+		if f, err := os.Lstat(entry.Path); err == nil && f.Mode()&os.ModeSymlink != 0 {
+			log.Warningf("Interrupted quarantine of %s in %s was completed\n", entry.Path, entry.Destination)
+		} else if err == nil {
+			log.Warningf("Interrupted quarantine of %s in %s was not applied\n", entry.Path, entry.Destination)
+		} else {
+			log.Warningf("Interrupted quarantine moved %s to %s, but did not leave symlink to master in its place\n", entry.Path, entry.Destination)
+		}
+	case "compact":
+		if isBoltPath(dbPath) {
+			// Transaction was not committed, so database is intact and only backup might be incomplete
+			log.Warningf("Removing backup %s of interrupted compaction\n", entry.Destination)
+			if err := os.Remove(entry.Destination); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		if _, err := os.Stat(entry.Destination); err != nil {
+			log.Warningf("No backup %s to restore after interrupted compaction\n", entry.Destination)
+			return nil
+		}
+		log.Warningf("Restoring database from backup %s after interrupted compaction\n", entry.Destination)
+		return os.Rename(entry.Destination, dbPath)
+	default:
+		log.Warningf("Unknown interrupted operation %s for %s\n", entry.Op, entry.Path)
+	}
+	return nil
+}
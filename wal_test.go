@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+)
+
+func TestReplayWALRestoresInterruptedCompaction(t *testing.T) {
+	logging.SetLevel(logging.ERROR, "cleaner")
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db.txt")
+	backup := dbPath + ".backup"
+	// Simulate compaction interrupted after database was moved to backup and partially rewritten
+	if err := ioutil.WriteFile(backup, []byte("{\"Path\":\"/a\"}\n{\"Path\":\"/b\"}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dbPath, []byte("{\"Path\":\"/a\"}\n{\"Pa"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := openWAL(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.begin("compact", dbPath, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayWAL(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\"Path\":\"/a\"}\n{\"Path\":\"/b\"}\n" {
+		t.Errorf("Expected database to be restored from backup, got %q", data)
+	}
+	assertNotExists(t, backup)
+	assertNotExists(t, getWALPath(dbPath))
+}
+
+func TestReplayWALIgnoresCommittedOperations(t *testing.T) {
+	logging.SetLevel(logging.ERROR, "cleaner")
+	dbPath := filepath.Join(t.TempDir(), "db.txt")
+	backup := dbPath + ".backup"
+	if err := ioutil.WriteFile(backup, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dbPath, []byte("new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := openWAL(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.begin("compact", dbPath, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.commit("compact", dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayWAL(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new\n" {
+		t.Errorf("Expected committed compaction to be kept, got %q", data)
+	}
+	assertExists(t, backup)
+}
+
+// failingStore moves database to backup and fails partway through rewrite
+type failingStore struct {
+	textStore
+}
+
+func (s *failingStore) rewrite(records []*FileMetadata, backup string) error {
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.path, []byte("{\"Pa"), 0666); err != nil {
+		return err
+	}
+	return errors.New("disk full")
+}
+
+func TestCompactDBRollsBackFailedRewrite(t *testing.T) {
+	logging.SetLevel(logging.CRITICAL, "cleaner")
+	dbPath := filepath.Join(t.TempDir(), "db.txt")
+	original := "{\"Path\":\"/a\"}\n{\"Path\":\"/a\"}\n"
+	if err := ioutil.WriteFile(dbPath, []byte(original), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fh := &FileHashes{dbPath: dbPath, files: map[string]*FileMetadata{"/a": {Path: "/a"}}, store: &failingStore{textStore{path: dbPath}}}
+	if err := CompactDB(fh); err == nil {
+		t.Fatal("Expected compaction to fail")
+	}
+	data, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Errorf("Expected database to be restored from backup, got %q", data)
+	}
+	assertNotExists(t, getWALPath(dbPath))
+	// Backup that can not be written keeps database in place
+	store := &textStore{path: dbPath}
+	if err := store.rewrite(nil, filepath.Join(dbPath+".missing", "backup")); err == nil {
+		t.Error("Expected rewrite to fail when backup can not be written")
+	}
+	if data, err := ioutil.ReadFile(dbPath); err != nil || string(data) != original {
+		t.Errorf("Expected database to be kept, got %q: %v", data, err)
+	}
+}
+
+// symlinkFailingFilesystem is in-memory filesystem that can not create symlinks
+type symlinkFailingFilesystem struct {
+	*memFilesystem
+}
+
+func (symlinkFailingFilesystem) Symlink(oldname string, newname string) error {
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrPermission}
+}
+
+func TestMoveDuplicatesKeepsWALOfInterruptedQuarantine(t *testing.T) {
+	modified := time.Date(2020, 7, 4, 12, 30, 0, 0, time.Local)
+	mem, fh := makeMemTestFiles(t, []memTestFile{
+		{"/lib/masters/a.txt", "same", modified},
+		{"/lib/incoming/a.txt", "same", modified},
+	})
+	defer CloseDB(fh)
+	fsys = symlinkFailingFilesystem{mem}
+	dups, err := FindDuplicates(SearchOptions{MastersFolder: "/lib/masters"}, fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Destination: "/removed", RemovePrefix: "/lib", Quarantine: true, Apply: true}
+	if _, err := MoveDuplicates(opts, dups, fh); err == nil {
+		t.Fatal("Expected quarantine to fail")
+	}
+	// Duplicate was moved without leaving symlink in its place, which is reported on next run
+	pending, err := readPendingOperations(getWALPath(fh.dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Op != "quarantine" || pending[0].Path != "/lib/incoming/a.txt" {
+		t.Errorf("Expected interrupted quarantine to be kept in log, got %v", pending)
+	}
+	if err := replayWAL(fh.dbPath); err != nil {
+		t.Fatal(err)
+	}
+	assertNotExists(t, getWALPath(fh.dbPath))
+}
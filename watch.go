@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long path has to stay unchanged before it is processed
+const watchDebounce = 2 * time.Second
+
+type pendingChange struct {
+	lastEvent time.Time
+	size      int64
+	modified  time.Time
+}
+
+// WatchFolders watches folders for changes and incrementally updates database until interrupted
+// If reportDups is set, duplicates of new and changed files are printed as they appear
+func WatchFolders(folders []string, fh *FileHashes, concurrency int, reportDups bool) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	for _, folder := range folders {
+		folder, err := filepath.Abs(folder)
+		if err != nil {
+			return err
+		}
+		if err := watchTree(watcher, folder, fh); err != nil {
+			return err
+		}
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	pending := make(map[string]*pendingChange)
+	log.Infof("Watching for changes\n")
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod || isDBFile(event.Name, fh) {
+				continue
+			}
+			log.Debugf("Watch event %s\n", event)
+			if change := pending[event.Name]; change != nil {
+				change.lastEvent = time.Now()
+			} else {
+				pending[event.Name] = &pendingChange{lastEvent: time.Now(), size: -1}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("Watch error: %s\n", err)
+		case <-ticker.C:
+			if err := processPendingChanges(watcher, pending, fh, concurrency, reportDups); err != nil {
+				return err
+			}
+		case <-interrupt:
+			log.Infof("Stopped watching\n")
+			return nil
+		}
+	}
+}
+
+// isDBFile checks if path is database file or belongs to its thumbnails cache
+func isDBFile(path string, fh *FileHashes) bool {
+	thumbnailsFolder := GetThumbnailsFolder(fh.dbPath)
+	return path == fh.dbPath || path == thumbnailsFolder || strings.HasPrefix(path, fmt.Sprintf("%s%c", thumbnailsFolder, filepath.Separator))
+}
+
+// watchTree adds folder and all its subfolders to watcher
+func watchTree(watcher *fsnotify.Watcher, folder string, fh *FileHashes) error {
+	return filepath.Walk(folder, func(path string, f os.FileInfo, err error) error {
+		if f == nil || !f.IsDir() {
+			return nil
+		}
+		if isDBFile(path, fh) {
+			return filepath.SkipDir
+		}
+		log.Debugf("Watching %s\n", path)
+		return watcher.Add(path)
+	})
+}
+
+// processPendingChanges scans paths that did not change for watchDebounce and removes records of deleted paths
+// Files that are still growing or being modified are postponed until they settle
+func processPendingChanges(watcher *fsnotify.Watcher, pending map[string]*pendingChange, fh *FileHashes, concurrency int, reportDups bool) error {
+	var settled []string
+	for path, change := range pending {
+		if time.Since(change.lastEvent) < watchDebounce {
+			continue
+		}
+		f, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			delete(pending, path)
+			forgetPath(fh, path)
+			continue
+		} else if err != nil {
+			delete(pending, path)
+			log.Warningf("Failed to check %s: %s\n", path, err)
+			continue
+		}
+		if f.IsDir() {
+			if err := watchTree(watcher, path, fh); err != nil {
+				return err
+			}
+		} else if f.Size() != change.size || f.ModTime() != change.modified {
+			// File might still be written, wait until it stays the same for another debounce interval
+			change.size = f.Size()
+			change.modified = f.ModTime()
+			change.lastEvent = time.Now()
+			continue
+		}
+		delete(pending, path)
+		settled = append(settled, path)
+	}
+	if len(settled) == 0 {
+		return nil
+	}
+	sort.Strings(settled)
+	if err := ScanFolders(settled, fh, concurrency); err != nil {
+		return err
+	}
+	if reportDups {
+		printDuplicatesOf(fh, settled)
+	}
+	return nil
+}
+
+// forgetPath removes records of deleted file or folder from memory, database file is cleaned up on compaction
+func forgetPath(fh *FileHashes, path string) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+	prefix := fmt.Sprintf("%s%c", path, filepath.Separator)
+	for recordPath, record := range fh.files {
+		if recordPath == path || strings.HasPrefix(recordPath, prefix) {
+			log.Infof("Removed %s\n", recordPath)
+			removeRecord(fh, record)
+		}
+	}
+}
+
+// printDuplicatesOf prints known duplicates of files at or under given paths
+func printDuplicatesOf(fh *FileHashes, paths []string) {
+	fh.lock.RLock()
+	defer fh.lock.RUnlock()
+	for _, path := range paths {
+		prefix := fmt.Sprintf("%s%c", path, filepath.Separator)
+		for recordPath, record := range fh.files {
+			if recordPath != path && !strings.HasPrefix(recordPath, prefix) {
+				continue
+			}
+			dups := make(map[*FileMetadata]bool)
+			for _, dup := range fh.hashes[record.FileHash] {
+				dups[dup] = true
+			}
+			if len(record.ImageHash) > 0 {
+				for _, dup := range fh.hashes[record.ImageHash] {
+					dups[dup] = true
+				}
+			}
+			delete(dups, record)
+			if len(dups) == 0 {
+				continue
+			}
+			fmt.Printf("* Duplicates for: %s\n", record.Path)
+			for dup := range dups {
+				fmt.Printf("    %s (%s)\n", dup.Path, getMatchType(record, dup))
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies extended attributes (e.g. user.xdg.tags) of src to dst, nothing is copied when file system does not support them
+// Attributes that can not be set, e.g. security ones without privileges, are skipped with warning
+func copyXattrs(src string, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if isXattrUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(dst, name, value, 0); err != nil {
+			if isXattrUnsupported(err) {
+				log.Warningf("Extended attributes are not supported at %s\n", dst)
+				return nil
+			}
+			log.Warningf("Failed to copy extended attribute %s to %s: %s\n", name, dst, err)
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buffer := make([]byte, size)
+	if size, err = unix.Listxattr(path, buffer); err != nil {
+		return nil, err
+	}
+	// Names are terminated by NUL
+	return strings.Split(strings.TrimRight(string(buffer[:size]), "\x00"), "\x00"), nil
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	value := make([]byte, size)
+	if size, err = unix.Getxattr(path, name, value); err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+func isXattrUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyFileKeepsXattrs(t *testing.T) {
+	root := t.TempDir()
+	fh := makeTestFiles(t, root, map[string]string{"photos/a.txt": "tagged"})
+	record := fh.files[filepath.Join(root, "photos", "a.txt")]
+	tags := []byte("Red\n6")
+	if err := unix.Setxattr(record.Path, "user.xdg.tags", tags, 0); err != nil {
+		t.Skipf("Extended attributes are not supported: %v", err)
+	}
+	path := filepath.Join(root, "mirror", "a.txt")
+	if err := copyFile(record, path); err != nil {
+		t.Fatal(err)
+	}
+	value, err := getXattr(path, "user.xdg.tags")
+	if err != nil || !bytes.Equal(value, tags) {
+		t.Errorf("Expected tags %q to be copied, got %q: %v", tags, value, err)
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// copyXattrs is not supported, alternate data streams are not copied
+func copyXattrs(src string, dst string) error {
+	return nil
+}